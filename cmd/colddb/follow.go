@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"music-database/db"
+)
+
+// runFollow replays the changes of a leader into the -dir directory until
+// interrupted. The leader is the URL of a server started with
+// -replication, or a database directory with a change log.
+func runFollow(d *db.Driver, args []string) error {
+	fs := flag.NewFlagSet("follow", flag.ContinueOnError)
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "admin token of the leader server (default $ADMIN_TOKEN)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected <leader url or directory>")
+	}
+	leader := fs.Arg(0)
+
+	follower, err := db.NewDirFollower(d.Dir(), nil)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Fprintf(os.Stderr, "following %s from change %d\n", leader, follower.Offset())
+	if strings.HasPrefix(leader, "http://") || strings.HasPrefix(leader, "https://") {
+		err = db.Follow(ctx, leader, follower, &db.FollowOptions{Token: *token})
+	} else {
+		var changes *db.ChangeLog
+		// The leader process owns the log; only read it
+		if changes, err = db.ReadChangeLog(leader); err != nil {
+			return err
+		}
+		defer changes.Close()
+		err = changes.Subscribe(ctx, follower.Offset(), follower.Apply)
+	}
+
+	if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "stopped at change %d\n", follower.Offset())
+		return nil
+	}
+	return err
+}
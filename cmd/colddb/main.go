@@ -0,0 +1,73 @@
+// Command colddb runs maintenance and query commands against a ColdDB
+// data directory.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"music-database/db"
+	_ "music-database/migrations"
+)
+
+type command struct {
+	usage string
+	run   func(d *db.Driver, args []string) error
+}
+
+var commands = map[string]command{
+	"export":   {"export [-format ndjson|csv] [-columns spec] [-o file] <collection>", runExport},
+	"follow":   {"follow <leader url or directory>", runFollow},
+	"import":   {"import [-format ndjson|csv] [-columns spec] [-key field] [-slug] [-generate] [-overwrite] [-dry-run] <collection> [file]", runImport},
+	"migrate":  {"migrate [-dry-run] [-q] [collection ...]", runMigrate},
+	"query":    {"query [-save name] [-explain] <collection> <query> | query -saved <name>", runQuery},
+	"searches": {"searches", runSearches},
+	"shard":    {"shard [-levels n] [-batch n] [-q] <collection> ...", runShard},
+	"trash":    {"trash [-restore resource | -purge [-older-than age]] <collection>", runTrash},
+}
+
+func main() {
+	dir := flag.String("dir", "data", "database directory")
+	readOnly := flag.Bool("read-only", false, "open the database without writing to it")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "colddb: unknown command '%s'\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	d, err := db.New(*dir, &db.Options{ReadOnly: *readOnly})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "colddb: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := cmd.run(d, flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "colddb %s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: colddb [-dir path] [-read-only] <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+}
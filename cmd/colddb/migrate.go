@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"music-database/db"
+)
+
+func runMigrate(d *db.Driver, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "report the changes without storing them")
+	quiet := fs.Bool("q", false, "do not report progress")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	opts := &db.MigrateOptions{DryRun: *dryRun}
+	if !*quiet {
+		opts.Progress = reportProgress
+	}
+
+	var results []db.MigrationResult
+	if fs.NArg() == 0 {
+		var err error
+		if results, err = d.Migrate(opts); err != nil {
+			return err
+		}
+	}
+	for _, collection := range fs.Args() {
+		result, err := d.MigrateCollection(collection, opts)
+		if err != nil {
+			return err
+		}
+		results = append(results, *result)
+	}
+
+	for _, r := range results {
+		switch {
+		case r.From == r.To:
+			fmt.Printf("%s: up to date at version %d\n", r.Collection, r.To)
+		case r.DryRun:
+			fmt.Printf("%s: would migrate from version %d to %d, changing %d of %d documents\n", r.Collection, r.From, r.To, len(r.Changed), r.Documents)
+		default:
+			fmt.Printf("%s: migrated from version %d to %d, changed %d of %d documents\n", r.Collection, r.From, r.To, len(r.Changed), r.Documents)
+		}
+		if r.DryRun {
+			for _, resource := range r.Changed {
+				fmt.Printf("  %s\n", resource)
+			}
+		}
+	}
+	return nil
+}
+
+// reportProgress prints the progress of a collection-wide operation on
+// stderr
+func reportProgress(p db.MigrationProgress) {
+	// Report every percent, not every document
+	if p.Done == p.Total || p.Done%max(p.Total/100, 1) == 0 {
+		fmt.Fprintf(os.Stderr, "\r%s: %d/%d", p.Collection, p.Done, p.Total)
+	}
+	if p.Done == p.Total {
+		fmt.Fprintln(os.Stderr)
+	}
+}
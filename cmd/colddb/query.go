@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"music-database/db"
+)
+
+func runQuery(d *db.Driver, args []string) error {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	save := fs.String("save", "", "save the query under this name")
+	saved := fs.String("saved", "", "run the saved search with this name")
+	explain := fs.Bool("explain", false, "print how the query was executed instead of its results")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var results []interface{}
+	var err error
+	if *saved != "" {
+		if fs.NArg() != 0 {
+			return fmt.Errorf("-saved takes no other arguments")
+		}
+		results, err = d.RunSavedSearch(*saved)
+	} else {
+		if fs.NArg() != 2 {
+			return fmt.Errorf("expected <collection> <query>")
+		}
+		collection, query := fs.Arg(0), fs.Arg(1)
+
+		st, parseErr := db.ParseStatement(query)
+		if parseErr != nil {
+			return fmt.Errorf("%v\n  %s\n  %*s", parseErr, query, parseErr.(*db.ParseError).Column, "^")
+		}
+		if *explain {
+			plan, err := d.Explain(collection, st)
+			if err != nil {
+				return err
+			}
+			fmt.Println(plan)
+			return nil
+		}
+		if *save != "" {
+			if err := d.SaveSearch(*save, collection, query); err != nil {
+				return err
+			}
+		}
+		results, err = d.Find(collection, st)
+	}
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, r := range results {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runSearches(d *db.Driver, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("expected no arguments")
+	}
+
+	searches, err := d.SavedSearches()
+	if err != nil {
+		return err
+	}
+	for _, s := range searches {
+		fmt.Printf("%s\t%s\t%s\n", s.Name, s.Collection, s.Query)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"music-database/db"
+)
+
+func runShard(d *db.Driver, args []string) error {
+	fs := flag.NewFlagSet("shard", flag.ContinueOnError)
+	levels := fs.Int("levels", 2, fmt.Sprintf("hash prefix directory levels, 1 to %d", db.MaxShardLevels))
+	batch := fs.Int("batch", 100, "files moved per lock of the collection")
+	quiet := fs.Bool("q", false, "do not report progress")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("expected <collection> ...")
+	}
+
+	opts := &db.ShardOptions{Levels: *levels, BatchSize: *batch}
+	if !*quiet {
+		opts.Progress = reportProgress
+	}
+
+	for _, collection := range fs.Args() {
+		moved, err := d.ShardCollection(collection, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: sharded into %d levels, moved %d files\n", collection, *levels, moved)
+	}
+	return nil
+}
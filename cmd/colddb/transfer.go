@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"music-database/db"
+)
+
+func runExport(d *db.Driver, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "ndjson", "output format: ndjson or csv")
+	columns := fs.String("columns", "", "CSV columns as Header=field[:type],...")
+	out := fs.String("o", "", "output file (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected <collection>")
+	}
+
+	f, err := parseFormat(*format, *columns)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	return d.Export(fs.Arg(0), w, f)
+}
+
+func runImport(d *db.Driver, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "ndjson", "input format: ndjson or csv")
+	columns := fs.String("columns", "", "CSV columns as Header=field[:type],...")
+	key := fs.String("key", db.IDField, "field holding the resource name")
+	slug := fs.Bool("slug", false, "derive the resource name from the key field with Slugify")
+	generate := fs.Bool("generate", false, "store documents without a key under a new ID")
+	overwrite := fs.Bool("overwrite", false, "replace existing resources")
+	dryRun := fs.Bool("dry-run", false, "check the input without storing anything")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 && fs.NArg() != 2 {
+		return fmt.Errorf("expected <collection> [file]")
+	}
+
+	f, err := parseFormat(*format, *columns)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if fs.NArg() == 2 {
+		file, err := os.Open(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	report, err := d.Import(fs.Arg(0), r, f, &db.ImportOptions{
+		KeyField:     *key,
+		Slug:         *slug,
+		GenerateKeys: *generate,
+		Overwrite:    *overwrite,
+		DryRun:       *dryRun,
+	})
+	if report == nil {
+		return err
+	}
+
+	for _, e := range report.Errors {
+		if e.Key != "" {
+			fmt.Fprintf(os.Stderr, "line %d (%s): %v\n", e.Line, e.Key, e.Err)
+		} else {
+			fmt.Fprintf(os.Stderr, "line %d: %v\n", e.Line, e.Err)
+		}
+	}
+
+	verb := "imported"
+	if *dryRun {
+		verb = "would import"
+	}
+	fmt.Printf("%s %d documents, %d errors\n", verb, len(report.Imported), len(report.Errors))
+	if err != nil {
+		// Stopped early, such as on reaching the collection limits
+		return err
+	}
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("%d documents failed", len(report.Errors))
+	}
+	return nil
+}
+
+// parseFormat builds a db.Format from a format name and a column spec
+// such as "Band=name,Album=albums[].name,Year=albums[].year:number"
+func parseFormat(name, columns string) (db.Format, error) {
+	var f db.Format
+	switch name {
+	case "ndjson":
+		f = db.NDJSON
+	case "csv":
+		f = db.CSV
+	default:
+		return f, fmt.Errorf("unknown format '%s'", name)
+	}
+
+	if columns == "" {
+		return f, nil
+	}
+	if f.Name != db.CSV.Name {
+		return f, fmt.Errorf("-columns only applies to csv")
+	}
+
+	for _, spec := range strings.Split(columns, ",") {
+		header, field, ok := strings.Cut(spec, "=")
+		if !ok {
+			header, field = spec, spec
+		}
+		field, typ, _ := strings.Cut(field, ":")
+		f.Columns = append(f.Columns, db.Column{
+			Header: strings.TrimSpace(header),
+			Field:  strings.TrimSpace(field),
+			Type:   strings.TrimSpace(typ),
+		})
+	}
+	return f, nil
+}
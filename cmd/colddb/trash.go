@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"music-database/db"
+)
+
+func runTrash(d *db.Driver, args []string) error {
+	fs := flag.NewFlagSet("trash", flag.ContinueOnError)
+	restore := fs.String("restore", "", "restore the named resource")
+	purge := fs.Bool("purge", false, "permanently remove the resources deleted before -older-than")
+	olderThan := fs.Duration("older-than", 0, "age of the resources purged; 0 purges every one")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected <collection>")
+	}
+	collection := fs.Arg(0)
+
+	switch {
+	case *restore != "":
+		if err := d.Restore(collection, *restore); err != nil {
+			return err
+		}
+		fmt.Printf("%s: restored %s\n", collection, *restore)
+	case *purge:
+		purged, err := d.PurgeTrash(collection, *olderThan)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: purged %d resources\n", collection, len(purged))
+	default:
+		entries, err := d.ListTrash(collection)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			fmt.Printf("%s\t%s\n", e.DeletedAt.Local().Format(time.DateTime), e.Resource)
+		}
+	}
+	return nil
+}
@@ -1,54 +1,185 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"io/fs"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
-	"music-database/internal/handler"
+	colddb "music-database/db"
 	"music-database/internal/database"
+	"music-database/internal/handler"
+	"music-database/internal/metrics"
+	_ "music-database/migrations"
 )
 
+// Number of parsed band documents kept in memory
+const documentCacheSize = 1024
+
 func main() {
+	replication := flag.Bool("replication", false, "record every change and stream it to followers at /replication")
+	slowQuery := flag.Duration("slow-query", 0, "log queries taking at least this long, e.g. 100ms; 0 disables")
+	logFormat := flag.String("log-format", "text", "log format: text or json")
+	logLevel := flag.String("log-level", "info", "lowest level logged: trace, debug, info, warn, error or fatal")
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "how long deleted bands can be restored; 0 keeps them forever")
+	readOnly := flag.Bool("read-only", false, "serve an existing data directory, such as a mounted snapshot, without writing to it")
+	adminToken := flag.String("admin-token", os.Getenv("ADMIN_TOKEN"), "bearer token required by the admin endpoints /maintenance and /replication, which are off without one (default $ADMIN_TOKEN)")
+	flag.Parse()
+
+	level, err := colddb.ParseLogLevel(*logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logHandler, err := colddb.NewLogHandler(os.Stderr, *logFormat, level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The standard log package writes through the same handler
+	logger := slog.New(logHandler)
+	slog.SetDefault(logger)
+	dbLogger := colddb.NewSlogLogger(logger)
+
 	// Set the project root directory explicitly
 	projectRoot := "/home/ihor/Desktop/projects/music_database"
-	
+
 	// Change to the project root directory
 	if err := os.Chdir(projectRoot); err != nil {
-		log.Fatal(err)
+		fatal("Failed to change to the project root", err)
 	}
 
 	// Print current working directory for debugging
 	cwd, err := os.Getwd()
 	if err != nil {
-		log.Fatal(err)
+		fatal("Failed to get the working directory", err)
 	}
-	log.Printf("Current working directory: %s", cwd)
-	
+	logger.Debug("Working directory", "dir", cwd)
+
 	// Check if templates directory exists
-	if _, err := os.Stat("templates"); os.IsNotExist(err) {
-		log.Fatal("templates directory not found")
-	} else if err != nil {
-		log.Fatal(err)
+	if _, err := os.Stat("templates"); err != nil {
+		fatal("Templates directory not found", err)
+	}
+
+	// Bring the stored documents up to the current schema, unless they
+	// cannot be written
+	store, err := colddb.New("data", &colddb.Options{Logger: dbLogger, AutoMigrate: !*readOnly, Replication: *replication,
+		SlowQueryThreshold: *slowQuery, ReadOnly: *readOnly})
+	if err != nil {
+		fatal("Failed to open the database", err)
 	}
 
 	db, err := database.New("data")
 	if err != nil {
-		log.Fatal(err)
+		fatal("Failed to open the database", err)
+	}
+	db.EnableCache(documentCacheSize)
+	db.Log = dbLogger
+	db.SlowQueryThreshold = *slowQuery
+	// The same band may be typed with different case or accents
+	db.AddUnique("bands", colddb.UniqueConstraint{Fields: []string{"name", "country"}, IgnoreCase: true})
+	db.Changes = store.ChangeLog()
+	// Writes of the server fail while the store is read-only or in
+	// maintenance
+	db.Gate = store
+	// A deleted band can be restored until it is purged
+	db.Trash = true
+	if *trashRetention > 0 && !*readOnly {
+		go purgeTrash(db, *trashRetention)
 	}
 
 	server := handler.NewServer(db)
 
-	http.HandleFunc("/", server.HandleIndex)
-	http.HandleFunc("/addBand", server.HandleAddBand)
-	http.HandleFunc("/bands/", server.HandleDeleteBand) // This will handle both DELETE /bands/{name} and POST /bands/{name}/albums
-	http.HandleFunc("/bands", server.HandleBands)
-	http.HandleFunc("/add-album", server.HandleAddAlbum)
-	http.HandleFunc("/bands-list", server.HandleBandsList)
-	http.Handle("/data/", http.StripPrefix("/data/", http.FileServer(http.Dir("data"))))
+	registry := metrics.NewRegistry()
+	registry.Register(metrics.StatsCollector(db.Stats))
+	registry.Register(metrics.DocumentsCollector(db.Counts))
+	registry.Register(metrics.RuntimeCollector())
 
-	log.Println("Server starting on :8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatal(err)
+	http.HandleFunc("/", registry.Instrument("HandleIndex", server.HandleIndex))
+	http.HandleFunc("/addBand", registry.Instrument("HandleAddBand", server.HandleAddBand))
+	http.HandleFunc("/bands/", registry.Instrument("HandleDeleteBand", server.HandleDeleteBand)) // This will handle both DELETE /bands/{name} and POST /bands/{name}/albums
+	http.HandleFunc("/bands", registry.Instrument("HandleBands", server.HandleBands))
+	http.HandleFunc("/add-album", registry.Instrument("HandleAddAlbum", server.HandleAddAlbum))
+	http.HandleFunc("/trash", registry.Instrument("HandleTrash", server.HandleTrash))
+	http.HandleFunc("/bands-list", registry.Instrument("HandleBandsList", server.HandleBandsList))
+	http.Handle("/metrics", registry.Handler())
+	if *adminToken != "" {
+		http.Handle("/maintenance", colddb.RequireToken(*adminToken, store.MaintenanceHandler()))
+		if db.Changes != nil {
+			http.Handle("/replication", colddb.RequireToken(*adminToken, db.Changes.Handler()))
+		}
+	} else if db.Changes != nil {
+		logger.Warn("Not serving /replication without -admin-token; followers can still read the change log from the data directory")
+	}
+	// The change log, slugs, trash and other metadata of the store are
+	// not for the public
+	http.Handle("/data/", http.StripPrefix("/data/", http.FileServer(hiddenFileSystem{http.Dir("data")})))
+
+	logger.Info("Server starting", "addr", ":8080")
+	if err := http.ListenAndServe(":8080", handler.LogRequests(logger, http.DefaultServeMux)); err != nil {
+		fatal("Server failed", err)
 	}
 }
+
+// hiddenFileSystem is an http.FileSystem that does not serve or list
+// files and directories whose name starts with a dot
+type hiddenFileSystem struct {
+	http.FileSystem
+}
+
+func (fsys hiddenFileSystem) Open(name string) (http.File, error) {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return nil, fs.ErrNotExist
+		}
+	}
+	f, err := fsys.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return hiddenFile{f}, nil
+}
+
+// hiddenFile leaves the files whose name starts with a dot out of a
+// directory listing
+type hiddenFile struct {
+	http.File
+}
+
+func (f hiddenFile) Readdir(n int) ([]fs.FileInfo, error) {
+	infos, err := f.File.Readdir(n)
+	visible := infos[:0]
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Name(), ".") {
+			visible = append(visible, info)
+		}
+	}
+	return visible, err
+}
+
+// How often deleted bands older than the retention are purged
+const trashPurgeInterval = time.Hour
+
+// purgeTrash permanently removes the deleted bands older than retention,
+// now and then every trashPurgeInterval
+func purgeTrash(db *database.Driver, retention time.Duration) {
+	for {
+		purged, err := db.PurgeTrash("bands", retention)
+		switch {
+		case err != nil:
+			slog.Warn("Failed to purge the trash", "error", err)
+		case len(purged) > 0:
+			slog.Info("Purged the trash", "collection", "bands", "purged", len(purged))
+		}
+		time.Sleep(trashPurgeInterval)
+	}
+}
+
+// fatal logs an error at the fatal level and exits
+func fatal(msg string, err error) {
+	slog.Default().LogAttrs(context.Background(), colddb.LevelFatal, msg, slog.String("error", err.Error()))
+	os.Exit(1)
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// BulkOptions controls UpdateWhere and DeleteWhere
+type BulkOptions struct {
+	// DryRun reports the matching resources without modifying them
+	DryRun bool
+}
+
+// UpdateWhere applies updates to every resource of the collection matching
+// query and returns the affected resource IDs. The whole operation runs
+// under the collection lock. When a resource fails validation or is
+// rejected by a hook, the IDs updated so far are returned with the error.
+func (d *Driver) UpdateWhere(collection string, query Query, updates map[string]interface{}, opts *BulkOptions) (_ []string, err error) {
+	start := time.Now()
+	defer d.done("update_where", collection, "", start, &err)
+	if err := checkCollection(collection); err != nil {
+		return nil, err
+	}
+
+	if opts == nil || !opts.DryRun {
+		end, err := d.BeginWrite()
+		if err != nil {
+			return nil, err
+		}
+		defer end()
+	}
+
+	mutex := d.lockCollection(collection)
+	ids, err := d.matchLocked(collection, query)
+	if err != nil || (opts != nil && opts.DryRun) {
+		mutex.Unlock()
+		return ids, err
+	}
+
+	var (
+		updated []string
+		events  []*HookEvent
+	)
+	for _, id := range ids {
+		var event *HookEvent
+		event, err = d.updateLocked(collection, id, func(data map[string]interface{}) (map[string]interface{}, error) {
+			for key, value := range updates {
+				data[key] = value
+			}
+			return data, nil
+		})
+		if err != nil {
+			break
+		}
+		updated = append(updated, id)
+		events = append(events, event)
+	}
+	mutex.Unlock()
+
+	for _, event := range events {
+		d.runAfterHooks(AfterWrite, event)
+		d.runAfterHooks(AfterUpdate, event)
+	}
+
+	// Update stats
+	d.updateStats(collection, "update_where", start)
+	return updated, err
+}
+
+// DeleteWhere removes every resource of the collection matching query and
+// returns the affected resource IDs. The whole operation runs under the
+// collection lock. When a before-delete hook rejects a resource, the IDs
+// deleted so far are returned with the error.
+func (d *Driver) DeleteWhere(collection string, query Query, opts *BulkOptions) (_ []string, err error) {
+	start := time.Now()
+	defer d.done("delete_where", collection, "", start, &err)
+	if err := checkCollection(collection); err != nil {
+		return nil, err
+	}
+
+	if opts == nil || !opts.DryRun {
+		end, err := d.BeginWrite()
+		if err != nil {
+			return nil, err
+		}
+		defer end()
+	}
+
+	unlock, _ := d.lockForDelete(context.Background(), collection)
+	ids, err := d.matchLocked(collection, query)
+	if err != nil || (opts != nil && opts.DryRun) {
+		unlock()
+		return ids, err
+	}
+
+	var (
+		deleted []string
+		events  []*HookEvent
+	)
+	for _, id := range ids {
+		var event *HookEvent
+		if err = d.checkRestrict(collection, id); err != nil {
+			break
+		}
+		if event, err = d.deleteLocked(collection, id); err != nil {
+			break
+		}
+		deleted = append(deleted, id)
+		events = append(events, event)
+	}
+	unlock()
+
+	for _, event := range events {
+		d.runAfterHooks(AfterDelete, event)
+	}
+	for _, id := range deleted {
+		if actionErr := d.applyDeleteActions(collection, id); actionErr != nil && err == nil {
+			err = actionErr
+		}
+	}
+
+	// Update stats
+	d.updateStats(collection, "delete_where", start)
+	return deleted, err
+}
+
+// matchLocked returns the IDs of the resources matching query. The caller
+// holds the collection mutex.
+func (d *Driver) matchLocked(collection string, query Query) ([]string, error) {
+	resources, err := d.listResources(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, resource := range resources {
+		data, err := d.readDocument(collection, resource)
+		if err != nil {
+			return nil, err
+		}
+		if matchQuery(data, query) {
+			ids = append(ids, resource)
+		}
+	}
+	return ids, nil
+}
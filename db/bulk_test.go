@@ -0,0 +1,89 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func writeBands(t *testing.T, d *Driver) {
+	t.Helper()
+	bands := map[string]interface{}{
+		"opeth":     map[string]interface{}{"name": "Opeth", "country": "Sweden", "year": 1990},
+		"katatonia": map[string]interface{}{"name": "Katatonia", "country": "Sweden", "year": 1991},
+		"camel":     map[string]interface{}{"name": "Camel", "country": "UK", "year": 1971},
+	}
+	for resource, doc := range bands {
+		if err := d.Write("bands", resource, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUpdateWhere(t *testing.T) {
+	d := newTestDriver(t, nil)
+	writeBands(t, d)
+	swedish := Query{Field: "country", Operator: "eq", Value: "Sweden"}
+
+	ids, err := d.UpdateWhere("bands", swedish, map[string]interface{}{"scene": "Stockholm"}, &BulkOptions{DryRun: true})
+	if err != nil || !reflect.DeepEqual(ids, []string{"katatonia", "opeth"}) {
+		t.Errorf("UpdateWhere(dry run) = %v, %v", ids, err)
+	}
+	var doc map[string]interface{}
+	if err := d.Read("bands", "opeth", &doc); err != nil || doc["scene"] != nil {
+		t.Errorf("dry run modified the document: %v, %v", doc, err)
+	}
+
+	ids, err = d.UpdateWhere("bands", swedish, map[string]interface{}{"scene": "Stockholm"}, nil)
+	if err != nil || !reflect.DeepEqual(ids, []string{"katatonia", "opeth"}) {
+		t.Errorf("UpdateWhere() = %v, %v", ids, err)
+	}
+	for resource, want := range map[string]interface{}{"opeth": "Stockholm", "katatonia": "Stockholm", "camel": nil} {
+		var doc map[string]interface{}
+		if err := d.Read("bands", resource, &doc); err != nil || doc["scene"] != want {
+			t.Errorf("%s: scene = %v, %v; want %v", resource, doc["scene"], err, want)
+		}
+	}
+}
+
+func TestUpdateWhereStopsOnRejection(t *testing.T) {
+	d := newTestDriver(t, nil)
+	writeBands(t, d)
+	d.AddHook("bands", BeforeWrite, func(e *HookEvent) error {
+		if e.Resource == "opeth" {
+			return errors.New("rejected")
+		}
+		return nil
+	})
+
+	ids, err := d.UpdateWhere("bands", Query{Field: "year", Operator: "gt", Value: float64(1980)}, map[string]interface{}{"active": true}, nil)
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("UpdateWhere() error = %v, want ErrInvalidInput", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"katatonia"}) {
+		t.Errorf("UpdateWhere() returned %v, want the resources updated before the rejection", ids)
+	}
+}
+
+func TestDeleteWhere(t *testing.T) {
+	d := newTestDriver(t, nil)
+	writeBands(t, d)
+	old := Query{Field: "year", Operator: "lt", Value: float64(1990)}
+
+	if ids, err := d.DeleteWhere("bands", old, &BulkOptions{DryRun: true}); err != nil || !reflect.DeepEqual(ids, []string{"camel"}) {
+		t.Errorf("DeleteWhere(dry run) = %v, %v", ids, err)
+	}
+	if all, _ := d.ReadAll("bands"); len(all) != 3 {
+		t.Errorf("dry run deleted %d documents", 3-len(all))
+	}
+
+	if ids, err := d.DeleteWhere("bands", old, nil); err != nil || !reflect.DeepEqual(ids, []string{"camel"}) {
+		t.Errorf("DeleteWhere() = %v, %v", ids, err)
+	}
+	if ids, err := d.DeleteWhere("bands", old, nil); err != nil || len(ids) != 0 {
+		t.Errorf("DeleteWhere() with no match = %v, %v", ids, err)
+	}
+	if all, _ := d.ReadAll("bands"); len(all) != 2 {
+		t.Errorf("%d documents left, want 2", len(all))
+	}
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ErrCorruptDocument is wrapped by the error of a resource file that is
+// not a JSON object
+var ErrCorruptDocument = errors.New("corrupt document")
+
+type cacheKey struct {
+	collection string
+	resource   string
+}
+
+// cachedDocument is a resource file as read from disk. Entries are checked
+// against the file's modification time and size on every access, so
+// external edits are picked up.
+type cachedDocument struct {
+	raw     []byte
+	doc     map[string]interface{} // nil when raw is not a JSON object
+	modTime time.Time
+	size    int64
+}
+
+// load returns the content of a resource file, from the cache when it is
+// enabled and the file did not change
+func (d *Driver) load(collection, resource string) (*cachedDocument, error) {
+	path, err := d.resourcePath(collection, resource)
+	if err != nil {
+		return nil, err
+	}
+	return d.loadPath(collection, resource, path)
+}
+
+// loadPath is load for a resource file already located
+func (d *Driver) loadPath(collection, resource, path string) (*cachedDocument, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, notFound(collection, resource)
+		}
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to read file", Err: err}
+	}
+	defer f.Close()
+
+	if d.cache == nil {
+		raw, err := io.ReadAll(f)
+		if err != nil {
+			return nil, &DbError{Code: ErrCodeInternal, Message: "failed to read file", Err: err}
+		}
+		return &cachedDocument{raw: raw}, nil
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to stat file", Err: err}
+	}
+
+	key := cacheKey{collection, resource}
+	if entry, ok := d.cache.Get(key); ok && entry.modTime.Equal(fi.ModTime()) && entry.size == fi.Size() {
+		d.stats.RecordCache(collection, true)
+		return entry, nil
+	}
+	d.stats.RecordCache(collection, false)
+
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to read file", Err: err}
+	}
+
+	entry := &cachedDocument{raw: raw, modTime: fi.ModTime(), size: fi.Size()}
+	if err := json.Unmarshal(raw, &entry.doc); err != nil {
+		entry.doc = nil
+	}
+	d.cache.Add(key, entry)
+	return entry, nil
+}
+
+// readAllDocuments returns the parsed documents of a collection, skipping
+// files that are not JSON objects, and the number of resource files
+// walked. The scan stops once ctx is done.
+func (d *Driver) readAllDocuments(ctx context.Context, collection string) (_ []map[string]interface{}, files int, _ error) {
+	if err := checkCollection(collection); err != nil {
+		return nil, 0, err
+	}
+
+	var docs []map[string]interface{}
+	err := WalkResources(d.collectionDir(collection), func(resource, path string, _ fs.DirEntry) error {
+		if err := ctx.Err(); err != nil {
+			return canceled(err)
+		}
+		files++
+
+		entry, err := d.loadFound(collection, resource, path)
+		if err == nil {
+			var data map[string]interface{}
+			if data, err = entry.document(); err == nil {
+				docs = append(docs, data)
+			}
+		}
+		if err != nil {
+			if isNotFound(err) {
+				return nil
+			}
+			if errors.Is(err, ErrCorruptDocument) {
+				return nil
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return docs, files, nil
+}
+
+// loadFound is loadPath for a file found by WalkResources, which may have
+// moved since while the collection is being sharded
+func (d *Driver) loadFound(collection, resource, path string) (*cachedDocument, error) {
+	entry, err := d.loadPath(collection, resource, path)
+	if isNotFound(err) {
+		return d.load(collection, resource)
+	}
+	return entry, err
+}
+
+// document returns a copy of the parsed document that callers may modify
+func (entry *cachedDocument) document() (map[string]interface{}, error) {
+	if entry.doc != nil {
+		return cloneDocument(entry.doc), nil
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(entry.raw, &data); err != nil {
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to unmarshal data", Err: fmt.Errorf("%w: %w", ErrCorruptDocument, err)}
+	}
+	return data, nil
+}
+
+func (d *Driver) uncache(collection, resource string) {
+	if d.cache != nil {
+		d.cache.Remove(cacheKey{collection, resource})
+	}
+}
+
+func (d *Driver) uncacheCollection(collection string) {
+	if d.cache != nil {
+		d.cache.RemoveFunc(func(k cacheKey) bool { return k.collection == collection })
+	}
+}
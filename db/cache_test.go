@@ -0,0 +1,118 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadCacheHits(t *testing.T) {
+	d := newTestDriver(t, &Options{CacheSize: 8})
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		var doc map[string]interface{}
+		if err := d.Read("bands", "opeth", &doc); err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+		if doc["name"] != "Opeth" {
+			t.Fatalf("Read() = %v", doc)
+		}
+		// The caller owns its copy
+		doc["name"] = "changed"
+	}
+
+	stats := d.GetStats("bands")
+	if hits, misses := stats["cache_hits"], stats["cache_misses"]; hits != 2 || misses != 1 {
+		t.Errorf("cache hits, misses = %v, %v, want 2, 1", hits, misses)
+	}
+}
+
+func TestReadSeesExternalChanges(t *testing.T) {
+	d := newTestDriver(t, &Options{CacheSize: 8})
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := d.Read("bands", "opeth", &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := d.resourcePath("bands", "opeth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"name": "Opeth", "country": "Sweden"}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	doc = nil
+	if err := d.Read("bands", "opeth", &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["country"] != "Sweden" {
+		t.Errorf("Read() after an external change = %v", doc)
+	}
+}
+
+func TestReadKeepsRecordCount(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+	before := d.Stats().RecordCount["bands"]
+
+	// A file added behind the driver's back is only seen by a walk
+	path, err := d.resourcePath("bands", "camel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"name": "Camel"}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]interface{}
+	if err := d.Read("bands", "opeth", &doc); err != nil {
+		t.Fatal(err)
+	}
+	if after := d.Stats().RecordCount["bands"]; after != before {
+		t.Errorf("record count after a read = %d, want %d: the read walked the collection", after, before)
+	}
+}
+
+func TestReadIntoExistingMap(t *testing.T) {
+	for _, cacheSize := range []int{0, 8} {
+		d := newTestDriver(t, &Options{CacheSize: cacheSize})
+		if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth", "year": 1990}); err != nil {
+			t.Fatal(err)
+		}
+
+		// The second read is a cache hit when the cache is on
+		for i := 0; i < 2; i++ {
+			doc := map[string]interface{}{"name": "old", "local": true}
+			if err := d.Read("bands", "opeth", &doc); err != nil {
+				t.Fatal(err)
+			}
+			if doc["name"] != "Opeth" || doc["year"] != float64(1990) || doc["local"] != true {
+				t.Errorf("cache size %d, read %d: Read() into a filled map = %v, want the document merged into it", cacheSize, i, doc)
+			}
+		}
+	}
+}
+
+func TestCorruptDocument(t *testing.T) {
+	entry := &cachedDocument{raw: []byte("not json")}
+	if _, err := entry.document(); !errors.Is(err, ErrCorruptDocument) || !errors.Is(err, ErrInternal) {
+		t.Errorf("document() error = %v, want ErrCorruptDocument and ErrInternal", err)
+	}
+	entry = &cachedDocument{raw: []byte(`{"name": "Opeth"}`)}
+	if doc, err := entry.document(); err != nil || doc["name"] != "Opeth" {
+		t.Errorf("document() = %v, %v", doc, err)
+	}
+}
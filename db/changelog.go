@@ -0,0 +1,445 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// changeLogFile is the append-only change log at the root of a database
+const changeLogFile = ".replog"
+
+// How often Subscribe checks the log for changes appended by another
+// process
+const changeLogPollInterval = time.Second
+
+// Change operations
+const (
+	OpPut    = "put"    // Resource stored with Data
+	OpDelete = "delete" // Resource removed
+	OpCreate = "create" // Empty collection created
+	OpDrop   = "drop"   // Collection removed
+	OpRename = "rename" // Collection renamed to To
+	OpFile   = "file"   // Collection metadata file such as .slugs stored with Data
+)
+
+// Change is a committed modification recorded in the change log
+type Change struct {
+	Seq        uint64          `json:"seq"`
+	Time       time.Time       `json:"time"`
+	Op         string          `json:"op"`
+	Collection string          `json:"collection"`
+	Resource   string          `json:"resource,omitempty"`
+	To         string          `json:"to,omitempty"`
+	Data       json.RawMessage `json:"data,omitempty"`
+}
+
+// ChangeLog records every committed change of a database directory in
+// order, so that followers can replay them. Only one process may append
+// to the log of a directory; others open it with ReadChangeLog.
+type ChangeLog struct {
+	mutex  sync.Mutex
+	path   string
+	file   *os.File // Nil for a log opened with ReadChangeLog
+	seq    uint64
+	notify chan struct{} // Closed and replaced on every append
+}
+
+// OpenChangeLog opens the change log of a database directory. A new log
+// starts with a put for every resource already stored, so that followers
+// starting from scratch receive the whole database.
+func OpenChangeLog(dir string) (*ChangeLog, error) {
+	l := &ChangeLog{path: filepath.Join(dir, changeLogFile), notify: make(chan struct{})}
+
+	_, err := os.Stat(l.path)
+	baseline := os.IsNotExist(err)
+
+	if l.file, err = os.OpenFile(l.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644); err != nil {
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to open change log", Err: err}
+	}
+
+	if baseline {
+		if err := l.appendBaseline(dir); err != nil {
+			l.file.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+
+	changes, err := l.Changes(0)
+	if err != nil {
+		l.file.Close()
+		return nil, err
+	}
+	if len(changes) > 0 {
+		l.seq = changes[len(changes)-1].Seq
+	}
+	return l, nil
+}
+
+// ReadChangeLog opens the change log of a database directory written by
+// another process, to subscribe to it. It never creates or writes the
+// log: it fails with ErrCodeNotFound when the directory has none, and
+// Append fails with ErrCodeReadOnly.
+func ReadChangeLog(dir string) (*ChangeLog, error) {
+	l := &ChangeLog{path: filepath.Join(dir, changeLogFile), notify: make(chan struct{})}
+	if _, err := os.Stat(l.path); err != nil {
+		if os.IsNotExist(err) {
+			return nil, &DbError{Code: ErrCodeNotFound, Message: fmt.Sprintf("no change log in '%s'; is the leader started with replication?", dir)}
+		}
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to open change log", Err: err}
+	}
+	return l, nil
+}
+
+func (l *ChangeLog) appendBaseline(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return &DbError{Code: ErrCodeInternal, Message: "failed to read database directory", Err: err}
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		collection, err := DecodeName(entry.Name())
+		if err != nil {
+			continue
+		}
+		if _, err := l.Append(Change{Op: OpCreate, Collection: collection}); err != nil {
+			return err
+		}
+
+		collectionDir := filepath.Join(dir, entry.Name())
+		for _, name := range []string{shardFile, slugFile, versionFile} {
+			data, err := os.ReadFile(filepath.Join(collectionDir, name))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return &DbError{Code: ErrCodeInternal, Message: "failed to read file", Err: err}
+			}
+			if _, err := l.Append(Change{Op: OpFile, Collection: collection, Resource: name, Data: data}); err != nil {
+				return err
+			}
+		}
+
+		err = WalkResources(collectionDir, func(resource, path string, _ fs.DirEntry) error {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return &DbError{Code: ErrCodeInternal, Message: "failed to read file", Err: err}
+			}
+			if !json.Valid(data) {
+				return nil
+			}
+			_, err = l.Append(Change{Op: OpPut, Collection: collection, Resource: resource, Data: data})
+			return err
+		})
+		if err != nil {
+			return walkError(err)
+		}
+	}
+	return nil
+}
+
+// Append assigns the next sequence number to a change and records it
+func (l *ChangeLog) Append(c Change) (uint64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return 0, &DbError{Code: ErrCodeReadOnly, Message: "change log is open for reading only"}
+	}
+	c.Seq = l.seq + 1
+	c.Time = time.Now().UTC()
+	if c.Op == OpFile {
+		// Metadata files may not be JSON; store them as a string
+		raw, err := json.Marshal(string(c.Data))
+		if err != nil {
+			return 0, &DbError{Code: ErrCodeInternal, Message: "failed to marshal change", Err: err}
+		}
+		c.Data = raw
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return 0, &DbError{Code: ErrCodeInternal, Message: "failed to marshal change", Err: err}
+	}
+	if _, err := l.file.Write(append(b, '\n')); err != nil {
+		return 0, &DbError{Code: ErrCodeInternal, Message: "failed to write change log", Err: err}
+	}
+
+	l.seq = c.Seq
+	close(l.notify)
+	l.notify = make(chan struct{})
+	return c.Seq, nil
+}
+
+// Seq returns the sequence number of the last recorded change
+func (l *ChangeLog) Seq() uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.seq
+}
+
+// Changes returns the changes recorded after the given sequence number
+func (l *ChangeLog) Changes(after uint64) ([]Change, error) {
+	changes, _, err := l.read(0, after)
+	return changes, err
+}
+
+// read returns the changes after the given sequence number found from
+// byte offset on, and the offset following the last complete line
+func (l *ChangeLog) read(offset int64, after uint64) ([]Change, int64, error) {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, offset, &DbError{Code: ErrCodeInternal, Message: "failed to read change log", Err: err}
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, &DbError{Code: ErrCodeInternal, Message: "failed to read change log", Err: err}
+	}
+
+	var changes []Change
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			// A line without a newline is still being written
+			return changes, offset, nil
+		}
+
+		var c Change
+		if err := json.Unmarshal(line, &c); err != nil {
+			return nil, offset, &DbError{Code: ErrCodeInternal, Message: "corrupt change log", Err: err}
+		}
+		offset += int64(len(line))
+		if c.Seq > after {
+			changes = append(changes, c)
+		}
+	}
+}
+
+// Subscribe calls fn for every change after the given sequence number, in
+// order, then waits for new ones until ctx is done or fn fails. The log is
+// read once; each wakeup reads only what was appended since.
+func (l *ChangeLog) Subscribe(ctx context.Context, after uint64, fn func(Change) error) error {
+	var offset int64
+	for {
+		l.mutex.Lock()
+		notify := l.notify
+		l.mutex.Unlock()
+
+		changes, next, err := l.read(offset, after)
+		if err != nil {
+			return err
+		}
+		offset = next
+		for _, c := range changes {
+			if err := fn(c); err != nil {
+				return err
+			}
+			after = c.Seq
+		}
+		if len(changes) > 0 {
+			continue
+		}
+
+		select {
+		case <-notify:
+		case <-time.After(changeLogPollInterval):
+		case <-ctx.Done():
+			return canceled(ctx.Err())
+		}
+	}
+}
+
+// Handler streams the change log to followers as NDJSON. The "after"
+// query parameter is the sequence number to resume after. The response
+// stays open and new changes are sent as they are recorded.
+func (l *ChangeLog) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var after uint64
+		if s := r.URL.Query().Get("after"); s != "" {
+			var err error
+			if after, err = strconv.ParseUint(s, 10, 64); err != nil {
+				http.Error(w, "Invalid 'after' parameter", http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		flusher, _ := w.(http.Flusher)
+		enc := json.NewEncoder(w)
+		l.Subscribe(r.Context(), after, func(c Change) error {
+			if err := enc.Encode(c); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		})
+	})
+}
+
+// Close closes the log file
+func (l *ChangeLog) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// replicate records a change if replication is enabled. It is called
+// with the collection mutex held, so changes of a collection are recorded
+// in the order they are applied.
+func (d *Driver) replicate(c Change) {
+	if d.changes == nil {
+		return
+	}
+	if _, err := d.changes.Append(c); err != nil {
+		d.logEvent(slog.LevelError, "Failed to record change", slog.String("op", c.Op),
+			slog.String("collection", c.Collection), slog.String("resource", c.Resource), slog.String("error", err.Error()))
+	}
+}
+
+// ChangeLog returns the change log of the driver, nil unless
+// Options.Replication is set
+func (d *Driver) ChangeLog() *ChangeLog {
+	return d.changes
+}
+
+// AddFollower ships every change after the follower's offset to it, in
+// the background, retrying after failures. Call the returned function to
+// stop.
+func (d *Driver) AddFollower(f Follower) (stop func(), err error) {
+	if d.changes == nil {
+		return nil, &DbError{Code: ErrCodeInvalidInput, Message: "replication is not enabled"}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for {
+			err := d.changes.Subscribe(ctx, f.Offset(), f.Apply)
+			if ctx.Err() != nil {
+				return
+			}
+			if IsRetryable(err) {
+				d.logEvent(slog.LevelDebug, "Replication to follower paused", slog.String("error", err.Error()))
+			} else {
+				d.logEvent(slog.LevelError, "Replication to follower failed", slog.String("error", err.Error()))
+			}
+
+			select {
+			case <-time.After(changeLogPollInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return cancel, nil
+}
+
+// applyChange replays a change recorded by another database. Like any
+// write it is rejected during maintenance, with a retryable error:
+// followers then wait and apply the change again.
+func (d *Driver) applyChange(c Change) error {
+	if err := checkCollection(c.Collection); err != nil {
+		return err
+	}
+	end, err := d.BeginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	switch c.Op {
+	case OpPut, OpDelete, OpFile:
+		if err := checkNames(c.Collection, c.Resource); err != nil {
+			return err
+		}
+		mutex := d.lockCollection(c.Collection)
+		defer mutex.Unlock()
+
+		switch c.Op {
+		case OpPut:
+			var b bytes.Buffer
+			if err := json.Indent(&b, c.Data, "", "\t"); err != nil {
+				return &DbError{Code: ErrCodeInvalidInput, Message: "invalid change data", Err: err}
+			}
+			b.WriteByte('\n')
+			if err := d.writeResource(c.Collection, c.Resource, b.Bytes()); err != nil {
+				return err
+			}
+			d.committed(c.Collection, c.Resource, b.Bytes())
+		case OpDelete:
+			if err := d.removeResource(c.Collection, c.Resource); err != nil && !os.IsNotExist(err) {
+				var dbErr *DbError
+				if errors.As(err, &dbErr) {
+					return err
+				}
+				return &DbError{Code: ErrCodeInternal, Message: "failed to delete file", Err: err}
+			}
+			d.removed(c.Collection, c.Resource)
+		case OpFile:
+			if c.Resource != slugFile && c.Resource != versionFile && c.Resource != shardFile {
+				return &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("unknown metadata file '%s'", c.Resource)}
+			}
+			var data string
+			if err := json.Unmarshal(c.Data, &data); err != nil {
+				return &DbError{Code: ErrCodeInvalidInput, Message: "invalid change data", Err: err}
+			}
+			if err := d.writeRaw(c.Collection, c.Resource, []byte(data)); err != nil {
+				return err
+			}
+			d.replicate(Change{Op: OpFile, Collection: c.Collection, Resource: c.Resource, Data: []byte(data)})
+		}
+	case OpCreate:
+		mutex := d.lockCollection(c.Collection)
+		defer mutex.Unlock()
+
+		if err := os.MkdirAll(d.collectionDir(c.Collection), 0755); err != nil {
+			return &DbError{Code: ErrCodeInternal, Message: "failed to create directory", Err: err}
+		}
+		d.replicate(c)
+	case OpDrop:
+		mutex := d.lockCollection(c.Collection)
+		defer mutex.Unlock()
+
+		if err := os.RemoveAll(d.collectionDir(c.Collection)); err != nil {
+			return &DbError{Code: ErrCodeInternal, Message: "failed to remove collection", Err: err}
+		}
+		d.reset(c.Collection)
+		d.replicate(c)
+	case OpRename:
+		if err := checkCollection(c.To); err != nil {
+			return err
+		}
+		unlock := d.lockCollections(c.Collection, c.To)
+		defer unlock()
+
+		if err := os.Rename(d.collectionDir(c.Collection), d.collectionDir(c.To)); err != nil && !os.IsNotExist(err) {
+			return &DbError{Code: ErrCodeInternal, Message: "failed to rename collection", Err: err}
+		}
+		d.reset(c.Collection)
+		d.reset(c.To)
+		d.replicate(c)
+	default:
+		return &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("unknown change operation '%s'", c.Op)}
+	}
+	return nil
+}
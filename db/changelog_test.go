@@ -0,0 +1,271 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or a few seconds passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAddFollower(t *testing.T) {
+	leader := newTestDriver(t, &Options{Replication: true})
+	if err := leader.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+
+	follower, err := NewDirFollower(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop, err := leader.AddFollower(follower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	if err := leader.Write("bands", "camel", map[string]interface{}{"name": "Camel"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.Delete("bands", "opeth"); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.RenameCollection("bands", "artists"); err != nil {
+		t.Fatal(err)
+	}
+	seq := leader.ChangeLog().Seq()
+	waitFor(t, "the follower", func() bool { return follower.Offset() == seq })
+
+	got, err := follower.Driver().ReadAll("artists")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Errorf("follower artists = %v, want camel only", got)
+	}
+	var doc map[string]interface{}
+	if err := follower.Driver().Read("artists", "camel", &doc); err != nil || doc["name"] != "Camel" {
+		t.Errorf("follower Read(camel) = %v, %v", doc, err)
+	}
+}
+
+func TestFollowHTTP(t *testing.T) {
+	leader := newTestDriver(t, &Options{Replication: true})
+	if err := leader.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(leader.ChangeLog().Handler())
+	defer server.Close()
+
+	follower, err := NewDirFollower(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Follow(ctx, server.URL, follower, nil) }()
+
+	waitFor(t, "the first change", func() bool { return follower.Offset() == 1 })
+	if err := leader.Write("bands", "camel", map[string]interface{}{"name": "Camel"}); err != nil {
+		t.Fatal(err)
+	}
+	seq := leader.ChangeLog().Seq()
+	waitFor(t, "the follower", func() bool { return follower.Offset() == seq })
+
+	cancel()
+	if err := <-done; !errors.Is(err, ErrCanceled) {
+		t.Errorf("Follow() error = %v, want ErrCanceled", err)
+	}
+	names, _ := follower.Driver().ReadAll("bands")
+	if len(names) != 2 {
+		t.Errorf("follower bands = %v, want 2", names)
+	}
+}
+
+func TestReadChangeLogMissing(t *testing.T) {
+	dir := t.TempDir()
+	_, err := ReadChangeLog(dir)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("ReadChangeLog() error = %v, want ErrNotFound", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, changeLogFile)); !os.IsNotExist(err) {
+		t.Errorf("ReadChangeLog() created the log: %v", err)
+	}
+}
+
+func TestReadChangeLogIsReadOnly(t *testing.T) {
+	leader := newTestDriver(t, &Options{Replication: true})
+	if err := leader.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(filepath.Join(leader.Dir(), changeLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := ReadChangeLog(leader.Dir())
+	if err != nil {
+		t.Fatalf("ReadChangeLog() error = %v", err)
+	}
+	defer l.Close()
+	if _, err := l.Append(Change{Op: OpCreate, Collection: "albums"}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Append() error = %v, want ErrReadOnly", err)
+	}
+
+	changes, err := l.Changes(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Op != OpPut || changes[0].Resource != "opeth" {
+		t.Errorf("Changes(0) = %+v, want the put of opeth", changes)
+	}
+	after, _ := os.ReadFile(filepath.Join(leader.Dir(), changeLogFile))
+	if string(after) != string(before) {
+		t.Error("reading the log changed it")
+	}
+}
+
+func TestChangeLogReadOffset(t *testing.T) {
+	l, err := OpenChangeLog(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for _, c := range []string{"a", "b", "c"} {
+		if _, err := l.Append(Change{Op: OpCreate, Collection: c}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes, offset, err := l.read(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Seq != 2 {
+		t.Fatalf("read(0, 1) = %+v, want changes 2 and 3", changes)
+	}
+
+	// A partly written line is left for the next read
+	if _, err := l.file.WriteString(`{"seq":4,"op":"create","coll`); err != nil {
+		t.Fatal(err)
+	}
+	changes, next, err := l.read(offset, 3)
+	if err != nil || len(changes) != 0 || next != offset {
+		t.Fatalf("read(offset, 3) = %+v, %d, %v; want nothing new at %d", changes, next, err, offset)
+	}
+	if _, err := l.file.WriteString("ection\":\"d\"}\n"); err != nil {
+		t.Fatal(err)
+	}
+	changes, _, err = l.read(offset, 3)
+	if err != nil || len(changes) != 1 || changes[0].Collection != "d" {
+		t.Fatalf("read(offset, 3) = %+v, %v; want the create of d", changes, err)
+	}
+}
+
+func TestFollowFromDirectory(t *testing.T) {
+	leader := newTestDriver(t, &Options{Replication: true})
+	if err := leader.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := ReadChangeLog(leader.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	follower, err := NewDirFollower(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- l.Subscribe(ctx, follower.Offset(), follower.Apply) }()
+
+	// Appended by the leader after the follower caught up; picked up by
+	// polling the file
+	waitFor(t, "the first change", func() bool { return follower.Offset() == 1 })
+	if err := leader.Write("bands", "camel", map[string]interface{}{"name": "Camel"}); err != nil {
+		t.Fatal(err)
+	}
+	seq := leader.ChangeLog().Seq()
+	waitFor(t, "the follower", func() bool { return follower.Offset() == seq })
+
+	cancel()
+	if err := <-done; !errors.Is(err, ErrCanceled) {
+		t.Errorf("Subscribe() error = %v, want ErrCanceled", err)
+	}
+	names, _ := follower.Driver().ReadAll("bands")
+	if len(names) != 2 {
+		t.Errorf("follower bands = %v, want 2", names)
+	}
+}
+
+func TestFollowHTTPToken(t *testing.T) {
+	leader := newTestDriver(t, &Options{Replication: true})
+	if err := leader.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(RequireToken("secret", leader.ChangeLog().Handler()))
+	defer srv.Close()
+
+	follower, err := NewDirFollower(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = Follow(ctx, srv.URL, follower, &FollowOptions{Token: "wrong"})
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("Follow() with a wrong token error = %v, want ErrInvalidInput", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- Follow(ctx, srv.URL, follower, &FollowOptions{Token: "secret"}) }()
+	waitFor(t, "the follower", func() bool { return follower.Offset() == 1 })
+	cancel()
+	<-done
+}
+
+func TestRequireToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		token, header string
+		want          int
+	}{
+		{"secret", "Bearer secret", http.StatusOK},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "secret", http.StatusUnauthorized},
+		{"secret", "", http.StatusUnauthorized},
+		{"", "Bearer ", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		w := httptest.NewRecorder()
+		RequireToken(tt.token, ok).ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("token %q, header %q: status %d, want %d", tt.token, tt.header, w.Code, tt.want)
+		}
+	}
+}
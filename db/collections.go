@@ -0,0 +1,336 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// CollectionOptions configures a collection created with CreateCollection
+type CollectionOptions struct {
+	// Validator is registered for the collection as with AddValidator
+	Validator ValidationFunc
+	// ShardLevels, if positive, stores the resources in that many levels
+	// of hash prefix directories, see ShardCollection
+	ShardLevels int
+}
+
+// CollectionInfo describes a collection
+type CollectionInfo struct {
+	Name         string
+	Count        int            // Number of resources
+	Size         int64          // Total size of the resource files in bytes
+	HasValidator bool           // Whether a validation function is registered
+	Hooks        map[string]int // Number of registered hooks by type
+	Indexes      []string       // Indexes maintained for the collection
+	Version      int            // Schema version set by the migrations
+	References   []Reference    // References declared by the collection
+	ShardLevels  int            // Hash prefix levels of the layout, 0 when flat
+	Limits       Limits         // Size limits, zero when unlimited
+	Trashed      int            // Number of resources in the trash
+}
+
+// Collections returns the names of every collection, sorted
+func (d *Driver) Collections() (_ []string, err error) {
+	defer d.done("collections", "", "", time.Now(), &err)
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to read database directory", Err: err}
+	}
+
+	var collections []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		// Skip directories that were not created through EncodeName
+		name, err := DecodeName(entry.Name())
+		if err != nil {
+			continue
+		}
+		collections = append(collections, name)
+	}
+
+	sort.Strings(collections)
+	return collections, nil
+}
+
+// CreateCollection creates an empty collection and fails with
+// ErrCodeAlreadyExists if it is already present
+func (d *Driver) CreateCollection(name string, opts *CollectionOptions) (err error) {
+	start := time.Now()
+	defer d.done("create_collection", name, "", start, &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+	if err := checkCollection(name); err != nil {
+		return err
+	}
+
+	if opts != nil && (opts.ShardLevels < 0 || opts.ShardLevels > MaxShardLevels) {
+		return &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("shard levels must be between 0 and %d", MaxShardLevels)}
+	}
+
+	mutex := d.lockCollection(name)
+	defer mutex.Unlock()
+
+	dir := d.collectionDir(name)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		if os.IsExist(err) {
+			return &DbError{Code: ErrCodeAlreadyExists, Message: fmt.Sprintf("collection '%s' already exists", name)}
+		}
+		return &DbError{Code: ErrCodeInternal, Message: "failed to create directory", Err: err}
+	}
+
+	d.replicate(Change{Op: OpCreate, Collection: name})
+
+	if opts != nil && opts.ShardLevels > 0 {
+		if err := d.writeLayout(name, Layout{Levels: opts.ShardLevels}); err != nil {
+			return err
+		}
+	}
+
+	// A new collection starts at the latest schema version
+	if version := latestVersion(name); version > 0 {
+		if err := d.writeVersion(name, version); err != nil {
+			return err
+		}
+	}
+
+	if opts != nil && opts.Validator != nil {
+		d.AddValidator(name, opts.Validator)
+	}
+
+	d.updateStats(name, "create_collection", start)
+	return nil
+}
+
+// DropCollection removes a collection and every resource in it.
+// Registered validators and hooks are kept.
+func (d *Driver) DropCollection(name string) (err error) {
+	start := time.Now()
+	defer d.done("drop_collection", name, "", start, &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+	if err := checkCollection(name); err != nil {
+		return err
+	}
+
+	mutex := d.lockCollection(name)
+	defer mutex.Unlock()
+
+	dir := d.collectionDir(name)
+	if _, err := os.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return collectionNotFound(name)
+		}
+		return &DbError{Code: ErrCodeInternal, Message: "failed to stat collection", Err: err}
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return &DbError{Code: ErrCodeInternal, Message: "failed to remove collection", Err: err}
+	}
+	d.reset(name)
+	d.replicate(Change{Op: OpDrop, Collection: name})
+
+	d.updateStats(name, "drop_collection", start)
+	return nil
+}
+
+// RenameCollection renames a collection. Validators, hooks, references,
+// unique constraints and limits registered for the old name move to the
+// new one.
+func (d *Driver) RenameCollection(oldName, newName string) (err error) {
+	start := time.Now()
+	defer d.done("rename_collection", oldName, "", start, &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+	if err := checkCollection(oldName); err != nil {
+		return err
+	}
+	if err := checkCollection(newName); err != nil {
+		return err
+	}
+	if oldName == newName {
+		return nil
+	}
+
+	unlock := d.lockCollections(oldName, newName)
+	defer unlock()
+
+	oldDir, newDir := d.collectionDir(oldName), d.collectionDir(newName)
+	if _, err := os.Stat(oldDir); err != nil {
+		if os.IsNotExist(err) {
+			return collectionNotFound(oldName)
+		}
+		return &DbError{Code: ErrCodeInternal, Message: "failed to stat collection", Err: err}
+	}
+	if _, err := os.Stat(newDir); err == nil {
+		return &DbError{Code: ErrCodeAlreadyExists, Message: fmt.Sprintf("collection '%s' already exists", newName)}
+	}
+
+	if err := os.Rename(oldDir, newDir); err != nil {
+		return &DbError{Code: ErrCodeInternal, Message: "failed to rename collection", Err: err}
+	}
+	d.reset(oldName)
+	d.reset(newName)
+	d.replicate(Change{Op: OpRename, Collection: oldName, To: newName})
+
+	d.mutex.Lock()
+	if validator, ok := d.validators[oldName]; ok {
+		d.validators[newName] = validator
+		delete(d.validators, oldName)
+	}
+	if hooks, ok := d.hooks[oldName]; ok {
+		d.hooks[newName] = hooks
+		delete(d.hooks, oldName)
+	}
+	if uniques, ok := d.uniques[oldName]; ok {
+		d.uniques[newName] = uniques
+		delete(d.uniques, oldName)
+	}
+	if refs, ok := d.references[oldName]; ok {
+		d.references[newName] = refs
+		delete(d.references, oldName)
+	}
+	for _, refs := range d.references {
+		for i := range refs {
+			if refs[i].Target == oldName {
+				refs[i].Target = newName
+			}
+		}
+	}
+	if limits, ok := d.limits[oldName]; ok {
+		d.limits[newName] = limits
+		delete(d.limits, oldName)
+	} else {
+		delete(d.limits, newName)
+	}
+	// Both locks are held. Callers waiting on either one find it moved
+	// once it is released and take the lock now under their name.
+	d.mutexes[newName] = d.mutexes[oldName]
+	delete(d.mutexes, oldName)
+	d.mutex.Unlock()
+
+	d.updateStats(oldName, "rename_collection", start)
+	d.updateStats(newName, "rename_collection", start)
+	return nil
+}
+
+// Describe returns the size, configuration and indexes of a collection
+func (d *Driver) Describe(name string) (_ *CollectionInfo, err error) {
+	defer d.done("describe", name, "", time.Now(), &err)
+	if err := checkCollection(name); err != nil {
+		return nil, err
+	}
+
+	mutex := d.lockCollection(name)
+	defer mutex.Unlock()
+
+	dir := d.collectionDir(name)
+	if _, err := os.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return nil, collectionNotFound(name)
+		}
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to stat collection", Err: err}
+	}
+
+	version, err := d.readVersion(name)
+	if err != nil {
+		return nil, err
+	}
+
+	layout, err := ReadLayout(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &CollectionInfo{Name: name, Hooks: make(map[string]int), Version: version, ShardLevels: layout.Levels, Limits: d.Limits(name)}
+	err = WalkResources(dir, func(_, _ string, entry fs.DirEntry) error {
+		fi, err := entry.Info()
+		if err != nil {
+			return &DbError{Code: ErrCodeInternal, Message: "failed to stat file", Err: err}
+		}
+		info.Count++
+		info.Size += fi.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, walkError(err)
+	}
+
+	trashed, err := ReadTrashDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	info.Trashed = len(trashed)
+
+	d.mutex.Lock()
+	_, info.HasValidator = d.validators[name]
+	for hookType, hooks := range d.hooks[name] {
+		info.Hooks[hookType.String()] = len(hooks)
+	}
+	info.References = append(info.References, d.references[name]...)
+	d.mutex.Unlock()
+
+	if _, err := os.Stat(filepath.Join(dir, slugFile)); err == nil {
+		info.Indexes = append(info.Indexes, "slugs")
+	}
+	if d.searchIndex(name) != nil {
+		info.Indexes = append(info.Indexes, "search")
+	}
+	for _, idx := range d.uniqueIndexes(name) {
+		info.Indexes = append(info.Indexes, fmt.Sprintf("unique(%s)", idx))
+	}
+	return info, nil
+}
+
+// lockCollections locks several collections in a fixed order so that
+// concurrent callers cannot deadlock, and returns the unlock function
+func (d *Driver) lockCollections(names ...string) func() {
+	unlock, _ := d.lockCollectionsContext(context.Background(), names...)
+	return unlock
+}
+
+// lockCollectionsContext is lockCollections giving up with a cancellation
+// error when ctx is done
+func (d *Driver) lockCollectionsContext(ctx context.Context, names ...string) (func(), error) {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+
+	var mutexes []*collectionLock
+	unlock := func() {
+		for i := len(mutexes) - 1; i >= 0; i-- {
+			mutexes[i].Unlock()
+		}
+	}
+	for i, name := range sorted {
+		if i > 0 && name == sorted[i-1] {
+			continue
+		}
+		m, err := d.lockContext(ctx, name)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		mutexes = append(mutexes, m)
+	}
+	return unlock, nil
+}
+
+func collectionNotFound(name string) *DbError {
+	return &DbError{Code: ErrCodeNotFound, Message: fmt.Sprintf("collection '%s' not found", name)}
+}
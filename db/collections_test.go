@@ -0,0 +1,138 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestCollectionLifecycle(t *testing.T) {
+	d := newTestDriver(t, nil)
+	d.AddHook("bands", BeforeWrite, func(*HookEvent) error { return nil })
+
+	if err := d.CreateCollection("bands", &CollectionOptions{Validator: func(interface{}) error { return nil }}); err != nil {
+		t.Fatalf("CreateCollection() error = %v", err)
+	}
+	if err := d.CreateCollection("bands", nil); !errors.Is(err, ErrConflict) {
+		t.Errorf("CreateCollection() again error = %v, want ErrConflict", err)
+	}
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("labels", "candlelight", map[string]interface{}{"name": "Candlelight"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := d.Collections(); err != nil || !reflect.DeepEqual(got, []string{"bands", "labels"}) {
+		t.Errorf("Collections() = %v, %v", got, err)
+	}
+
+	if err := d.RenameCollection("bands", "labels"); !errors.Is(err, ErrConflict) {
+		t.Errorf("RenameCollection() onto an existing collection error = %v, want ErrConflict", err)
+	}
+	if err := d.RenameCollection("bands", "artists"); err != nil {
+		t.Fatalf("RenameCollection() error = %v", err)
+	}
+	info, err := d.Describe("artists")
+	if err != nil {
+		t.Fatalf("Describe() error = %v", err)
+	}
+	if info.Count != 1 || info.Size == 0 || !info.HasValidator || info.Hooks["before-write"] != 1 {
+		t.Errorf("Describe() = %+v, want the document, validator and hook moved", info)
+	}
+	if _, err := d.Describe("bands"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Describe() of the old name error = %v, want ErrNotFound", err)
+	}
+
+	if err := d.DropCollection("artists"); err != nil {
+		t.Fatalf("DropCollection() error = %v", err)
+	}
+	if err := d.DropCollection("artists"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DropCollection() again error = %v, want ErrNotFound", err)
+	}
+	if got, err := d.Collections(); err != nil || !reflect.DeepEqual(got, []string{"labels"}) {
+		t.Errorf("Collections() after drop = %v, %v", got, err)
+	}
+}
+
+func TestRenameCollectionMovesLimits(t *testing.T) {
+	d := newTestDriver(t, nil)
+	d.SetLimits("bands", Limits{MaxDocuments: 1})
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.RenameCollection("bands", "artists"); err != nil {
+		t.Fatalf("RenameCollection() error = %v", err)
+	}
+
+	if got := d.Limits("artists"); got.MaxDocuments != 1 {
+		t.Errorf("Limits(artists) = %+v, want the limits of bands", got)
+	}
+	if got := d.Limits("bands"); !got.IsZero() {
+		t.Errorf("Limits(bands) = %+v, want none", got)
+	}
+	err := d.Write("artists", "camel", map[string]interface{}{"name": "Camel"})
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Write() over the moved limit error = %v, want ErrQuotaExceeded", err)
+	}
+}
+
+func TestRenameCollectionMovesLock(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+	old := d.getOrCreateMutex("bands")
+
+	if err := d.RenameCollection("bands", "artists"); err != nil {
+		t.Fatalf("RenameCollection() error = %v", err)
+	}
+
+	d.mutex.Lock()
+	moved, stale := d.mutexes["artists"], d.mutexes["bands"]
+	d.mutex.Unlock()
+	if moved != old {
+		t.Error("the lock of bands did not move to artists")
+	}
+	if stale != nil {
+		t.Error("the lock of bands is still in the lock map")
+	}
+}
+
+func TestRenameCollectionConcurrentWrites(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				d.Write("bands", "camel", map[string]interface{}{"name": "Camel"})
+				d.Write("artists", "yes", map[string]interface{}{"name": "Yes"})
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		d.RenameCollection("bands", "artists")
+		d.RenameCollection("artists", "bands")
+	}
+	wg.Wait()
+
+	// Every lock left in the map must be free
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for name, m := range d.mutexes {
+		select {
+		case m.ch <- struct{}{}:
+			<-m.ch
+		default:
+			t.Errorf("lock of %s is still held", name)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package db
+
+import "encoding/json"
+
+// toDocument converts any JSON-serializable value into its generic
+// document form
+func toDocument(data interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to marshal data", Err: err}
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, &DbError{Code: ErrCodeInvalidInput, Message: "data is not a JSON object", Err: err}
+	}
+	return doc, nil
+}
+
+// cloneDocument returns a deep copy of a document
+func cloneDocument(doc map[string]interface{}) map[string]interface{} {
+	if doc == nil {
+		return nil
+	}
+	return cloneValue(doc).(map[string]interface{})
+}
+
+func cloneValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[k] = cloneValue(val)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, val := range t {
+			s[i] = cloneValue(val)
+		}
+		return s
+	}
+	return v
+}
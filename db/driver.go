@@ -1,8 +1,11 @@
 package db
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -11,6 +14,8 @@ import (
 	"time"
 
 	"github.com/jcelliott/lumber"
+
+	"music-database/pkg/lru"
 )
 
 const Version = "1.0.1"
@@ -29,29 +34,73 @@ type (
 	ValidationFunc func(interface{}) error
 
 	Driver struct {
-		mutex      sync.Mutex
-		mutexes    map[string]*sync.Mutex
-		dir        string
-		log        Logger
-		validators map[string]ValidationFunc
-		stats      *CollectionStats
+		mutex         sync.Mutex
+		mutexes       map[string]*collectionLock
+		dir           string
+		log           Logger
+		validators    map[string]ValidationFunc
+		hooks         map[string]map[HookType][]HookFunc
+		references    map[string][]Reference // Declared references by referencing collection
+		uniques       map[string][]*uniqueIndex
+		search        map[string]*textIndex
+		changes       *ChangeLog
+		cache         *lru.Cache[cacheKey, *cachedDocument]
+		stats         *CollectionStats
+		slowQuery     time.Duration
+		limits        map[string]Limits
+		defaultLimits Limits
+		trash         bool
+		usage         map[string]*collectionUsage // Counted usage of collections with limits
+		gate          writeGate                   // Read-only and maintenance modes
 	}
 
 	// CollectionStats tracks database statistics
 	CollectionStats struct {
 		mutex       sync.Mutex
-		Operations  map[string]int    // Count of operations by type
-		AccessTime  map[string]int64  // Last access time by collection
-		RecordCount map[string]int    // Number of records by collection
+		Operations  map[string]int                 // Count of operations by collection
+		AccessTime  map[string]int64               // Last access time by collection
+		RecordCount map[string]int                 // Number of records by collection
+		OpStats     map[string]map[string]*OpStats // Count and latency by collection and operation type
+		CacheHits   map[string]int                 // Document cache hits by collection
+		CacheMisses map[string]int                 // Document cache misses by collection
+	}
+
+	// OpStats aggregates the count and latency of one operation type
+	OpStats struct {
+		Count int
+		Total time.Duration
+		Max   time.Duration
 	}
 )
 
 type Options struct {
 	Logger
 	Validators map[string]ValidationFunc
+	CacheSize  int // Maximum number of parsed documents kept in memory; zero disables the cache
+	// AutoMigrate runs the registered migrations of every collection
+	// when the database is opened
+	AutoMigrate bool
+	// Replication records every committed change in a change log that
+	// followers replay, see AddFollower and ChangeLog
+	Replication bool
+	// SlowQueryThreshold, if positive, logs a warning with the plan of
+	// every Query and Find taking at least this long
+	SlowQueryThreshold time.Duration
+	// Limits bounds the size of each collection named, and DefaultLimits
+	// that of every other collection; see SetLimits
+	Limits        map[string]Limits
+	DefaultLimits Limits
+	// Trash makes deletes, including those of DeleteWhere and cascades,
+	// move documents to the trash of their collection, see Restore
+	Trash bool
+	// ReadOnly opens an existing database without ever writing to it:
+	// every mutating call fails with ErrCodeReadOnly
+	ReadOnly bool
 }
 
-// Query represents a simple query structure
+// Query represents a simple query structure. Operator is one of eq, ne,
+// gt, gte, lt, lte, in (Value is a []interface{}) or contains (substring
+// match ignoring case).
 type Query struct {
 	Field    string
 	Operator string
@@ -69,30 +118,65 @@ func New(dir string, options *Options) (*Driver, error) {
 	if opts.Logger == nil {
 		opts.Logger = lumber.NewConsoleLogger(lumber.INFO)
 	}
+	if err := checkOpenOptions(opts); err != nil {
+		return nil, err
+	}
 
 	driver := &Driver{
-		dir:        dir,
-		mutexes:    make(map[string]*sync.Mutex),
-		log:        opts.Logger,
-		validators: opts.Validators,
-		stats: &CollectionStats{
-			Operations:  make(map[string]int),
-			AccessTime:  make(map[string]int64),
-			RecordCount: make(map[string]int),
-		},
+		dir:           dir,
+		mutexes:       make(map[string]*collectionLock),
+		log:           opts.Logger,
+		validators:    opts.Validators,
+		stats:         NewCollectionStats(),
+		slowQuery:     opts.SlowQueryThreshold,
+		limits:        make(map[string]Limits),
+		defaultLimits: opts.DefaultLimits,
+		trash:         opts.Trash,
+	}
+	for collection, limits := range opts.Limits {
+		driver.limits[collection] = limits
+	}
+	driver.gate.readOnly = opts.ReadOnly
+
+	if opts.CacheSize > 0 {
+		driver.cache = lru.New[cacheKey, *cachedDocument](opts.CacheSize)
 	}
 
 	if _, err := os.Stat(dir); err == nil {
 		opts.Logger.Debug("Using existing database at '%s'\n", dir)
-		return driver, nil
+	} else if opts.ReadOnly {
+		if os.IsNotExist(err) {
+			return driver, &DbError{Code: ErrCodeNotFound, Message: fmt.Sprintf("database '%s' not found", dir), Err: err}
+		}
+		return driver, &DbError{Code: ErrCodeInternal, Message: "failed to open database", Err: err}
+	} else {
+		opts.Logger.Debug("Creating database at '%s'\n", dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return driver, err
+		}
 	}
 
-	opts.Logger.Debug("Creating database at '%s'\n", dir)
-	return driver, os.MkdirAll(dir, 0755)
+	if opts.Replication {
+		changes, err := OpenChangeLog(dir)
+		if err != nil {
+			return driver, err
+		}
+		driver.changes = changes
+	}
+
+	if opts.AutoMigrate {
+		if _, err := driver.Migrate(nil); err != nil {
+			return driver, err
+		}
+	}
+	return driver, nil
 }
 
 // AddValidator adds a validation function for a specific collection
 func (d *Driver) AddValidator(collection string, validator ValidationFunc) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
 	if d.validators == nil {
 		d.validators = make(map[string]ValidationFunc)
 	}
@@ -100,285 +184,820 @@ func (d *Driver) AddValidator(collection string, validator ValidationFunc) {
 }
 
 func (d *Driver) Write(collection, resource string, data interface{}) error {
-	if collection == "" {
-		return &DbError{Code: ErrCodeInvalidInput, Message: "collection cannot be empty"}
-	}
-	if resource == "" {
-		return &DbError{Code: ErrCodeInvalidInput, Message: "resource cannot be empty"}
-	}
+	return d.WriteContext(context.Background(), collection, resource, data)
+}
 
-	// Run validator if exists
-	if validator, exists := d.validators[collection]; exists {
-		if err := validator(data); err != nil {
-			return &DbError{Code: ErrCodeInvalidInput, Message: "validation failed", Err: err}
-		}
+// WriteContext is Write giving up when ctx is done before the collection
+// lock is acquired
+func (d *Driver) WriteContext(ctx context.Context, collection, resource string, data interface{}) (err error) {
+	start := time.Now()
+	defer d.done("write", collection, resource, start, &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return err
 	}
+	defer end()
 
-	mutex := d.getOrCreateMutex(collection)
-	mutex.Lock()
-	defer mutex.Unlock()
-
-	dir := filepath.Join(d.dir, collection)
-	finalPath := filepath.Join(dir, resource+".json")
-	tmpPath := finalPath + ".tmp"
-
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return &DbError{Code: ErrCodeInternal, Message: "failed to create directory", Err: err}
+	if err := checkNames(collection, resource); err != nil {
+		return err
 	}
 
-	b, err := json.MarshalIndent(data, "", "\t")
+	mutex, err := d.lockContext(ctx, collection)
 	if err != nil {
-		return &DbError{Code: ErrCodeInternal, Message: "failed to marshal data", Err: err}
-	}
-
-	b = append(b, byte('\n'))
-	if err := os.WriteFile(tmpPath, b, 0644); err != nil {
-		return &DbError{Code: ErrCodeInternal, Message: "failed to write file", Err: err}
+		return err
 	}
-
-	err = os.Rename(tmpPath, finalPath)
+	event, err := d.writeLocked(collection, resource, data)
+	mutex.Unlock()
 	if err != nil {
-		return &DbError{Code: ErrCodeInternal, Message: "failed to rename file", Err: err}
+		return err
 	}
 
+	d.runAfterHooks(AfterWrite, event)
+
 	// Update stats
-	d.updateStats(collection, "write")
+	d.updateStats(collection, "write", start)
 	return nil
 }
 
 // Update updates an existing resource in the collection
 func (d *Driver) Update(collection, resource string, updates map[string]interface{}) error {
-	if collection == "" {
-		return &DbError{Code: ErrCodeInvalidInput, Message: "collection cannot be empty"}
-	}
-	if resource == "" {
-		return &DbError{Code: ErrCodeInvalidInput, Message: "resource cannot be empty"}
-	}
-
-	mutex := d.getOrCreateMutex(collection)
-	mutex.Lock()
-	defer mutex.Unlock()
+	return d.UpdateContext(context.Background(), collection, resource, updates)
+}
 
-	filePath := filepath.Join(d.dir, collection, resource+".json")
-	file, err := os.ReadFile(filePath)
+// UpdateContext is Update giving up when ctx is done before the
+// collection lock is acquired
+func (d *Driver) UpdateContext(ctx context.Context, collection, resource string, updates map[string]interface{}) (err error) {
+	start := time.Now()
+	defer d.done("update", collection, resource, start, &err)
+	end, err := d.BeginWrite()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return &DbError{Code: ErrCodeNotFound, Message: fmt.Sprintf("resource '%s' not found in collection '%s'", resource, collection)}
-		}
-		return &DbError{Code: ErrCodeInternal, Message: "failed to read file", Err: err}
+		return err
 	}
+	defer end()
 
-	var data map[string]interface{}
-	if err := json.Unmarshal(file, &data); err != nil {
-		return &DbError{Code: ErrCodeInternal, Message: "failed to unmarshal data", Err: err}
+	if err := checkNames(collection, resource); err != nil {
+		return err
 	}
 
-	// Apply updates
-	for key, value := range updates {
-		data[key] = value
+	mutex, err := d.lockContext(ctx, collection)
+	if err != nil {
+		return err
 	}
-
-	// Validate updated data
-	if validator, exists := d.validators[collection]; exists {
-		if err := validator(data); err != nil {
-			return &DbError{Code: ErrCodeInvalidInput, Message: "validation failed", Err: err}
+	event, err := d.updateLocked(collection, resource, func(data map[string]interface{}) (map[string]interface{}, error) {
+		// Apply updates
+		for key, value := range updates {
+			data[key] = value
 		}
-	}
-
-	// Write updated data
-	b, err := json.MarshalIndent(data, "", "\t")
+		return data, nil
+	})
+	mutex.Unlock()
 	if err != nil {
-		return &DbError{Code: ErrCodeInternal, Message: "failed to marshal data", Err: err}
+		return err
 	}
 
-	b = append(b, byte('\n'))
-	tmpPath := filePath + ".tmp"
-	if err := os.WriteFile(tmpPath, b, 0644); err != nil {
-		return &DbError{Code: ErrCodeInternal, Message: "failed to write file", Err: err}
-	}
-
-	if err := os.Rename(tmpPath, filePath); err != nil {
-		return &DbError{Code: ErrCodeInternal, Message: "failed to rename file", Err: err}
-	}
+	d.runAfterHooks(AfterWrite, event)
+	d.runAfterHooks(AfterUpdate, event)
 
 	// Update stats
-	d.updateStats(collection, "update")
+	d.updateStats(collection, "update", start)
 	return nil
 }
 
 // BatchWrite performs multiple write operations in a single transaction
 func (d *Driver) BatchWrite(collection string, items map[string]interface{}) error {
-	if collection == "" {
-		return &DbError{Code: ErrCodeInvalidInput, Message: "collection cannot be empty"}
+	return d.BatchWriteContext(context.Background(), collection, items)
+}
+
+// BatchWriteContext is BatchWrite stopping before the next item once ctx
+// is done. Items written before that are kept. The batch is checked
+// against the limits of the collection as a whole, so a batch over them
+// writes nothing.
+func (d *Driver) BatchWriteContext(ctx context.Context, collection string, items map[string]interface{}) (err error) {
+	start := time.Now()
+	defer d.done("batch_write", collection, "", start, &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+	if err := checkCollection(collection); err != nil {
+		return err
 	}
 
+	docs := make(map[string][]byte, len(items))
 	for resource, data := range items {
-		if err := d.Write(collection, resource, data); err != nil {
-			return &DbError{Code: ErrCodeInternal, Message: "batch write failed", Err: err}
+		if err := checkNames(collection, resource); err != nil {
+			return err
 		}
+		b, err := marshalDocument(data)
+		if err != nil {
+			return err
+		}
+		docs[resource] = b
 	}
 
-	return nil
+	mutex, err := d.lockContext(ctx, collection)
+	if err != nil {
+		return err
+	}
+	events, err := d.batchWriteLocked(ctx, collection, items, docs)
+	mutex.Unlock()
+	for _, event := range events {
+		d.runAfterHooks(AfterWrite, event)
+	}
+	if len(events) > 0 {
+		d.updateStats(collection, "batch_write", start)
+	}
+	return err
+}
+
+// batchWriteLocked writes the items of a batch after checking them against
+// the limits of the collection, and returns the events of those written.
+// The caller holds the collection mutex.
+func (d *Driver) batchWriteLocked(ctx context.Context, collection string, items map[string]interface{}, docs map[string][]byte) ([]*HookEvent, error) {
+	if err := d.checkBatchLimits(collection, docs); err != nil {
+		return nil, err
+	}
+
+	var events []*HookEvent
+	for resource, data := range items {
+		if err := ctx.Err(); err != nil {
+			return events, canceled(err)
+		}
+		event, err := d.writeLocked(collection, resource, data)
+		if err != nil {
+			// Keep the codes callers act on, such as retrying later or
+			// splitting the batch
+			var dbErr *DbError
+			if errors.As(err, &dbErr) {
+				switch dbErr.Code {
+				case ErrCodeCanceled, ErrCodeUnavailable, ErrCodeReadOnly, ErrCodeTooLarge, ErrCodeQuotaExceeded:
+					return events, err
+				}
+			}
+			return events, &DbError{Code: ErrCodeInternal, Message: "batch write failed", Err: err}
+		}
+		events = append(events, event)
+	}
+	return events, nil
 }
 
 func (d *Driver) Read(collection, resource string, data interface{}) error {
-	if collection == "" {
-		return &DbError{Code: ErrCodeInvalidInput, Message: "collection cannot be empty"}
+	return d.ReadContext(context.Background(), collection, resource, data)
+}
+
+// ReadContext is Read returning a cancellation error when ctx is already
+// done
+func (d *Driver) ReadContext(ctx context.Context, collection, resource string, data interface{}) (err error) {
+	start := time.Now()
+	defer d.done("read", collection, resource, start, &err)
+
+	if err := checkNames(collection, resource); err != nil {
+		return err
 	}
-	if resource == "" {
-		return &DbError{Code: ErrCodeInvalidInput, Message: "resource cannot be empty"}
+	if err := ctx.Err(); err != nil {
+		return canceled(err)
 	}
 
-	filePath := filepath.Join(d.dir, collection, resource+".json")
-	file, err := os.ReadFile(filePath)
+	entry, err := d.load(collection, resource)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return &DbError{Code: ErrCodeNotFound, Message: fmt.Sprintf("resource '%s' not found in collection '%s'", resource, collection)}
-		}
-		return &DbError{Code: ErrCodeInternal, Message: "failed to read file", Err: err}
+		return err
 	}
 
-	if err := json.Unmarshal(file, data); err != nil {
+	// Cached documents are copied instead of parsed again, into the
+	// existing map if any as json.Unmarshal does
+	if target, ok := data.(*map[string]interface{}); ok && entry.doc != nil {
+		if *target == nil {
+			*target = cloneDocument(entry.doc)
+		} else {
+			for k, v := range entry.doc {
+				(*target)[k] = cloneValue(v)
+			}
+		}
+	} else if err := json.Unmarshal(entry.raw, data); err != nil {
 		return &DbError{Code: ErrCodeInternal, Message: "failed to unmarshal data", Err: err}
 	}
 
-	// Update stats
-	d.updateStats(collection, "read")
+	// A read leaves the record count as is, so cache hits never walk the
+	// collection
+	d.stats.Record(collection, "read", time.Since(start))
 	return nil
 }
 
-// Delete removes a resource from the collection
+// Delete removes a resource from the collection. The delete actions of
+// the references to it run afterwards and are not atomic with it: when
+// cascading or setting references to null fails, the resource stays
+// deleted and the error is returned.
 func (d *Driver) Delete(collection, resource string) error {
-	if collection == "" {
-		return &DbError{Code: ErrCodeInvalidInput, Message: "collection cannot be empty"}
-	}
-	if resource == "" {
-		return &DbError{Code: ErrCodeInvalidInput, Message: "resource cannot be empty"}
+	return d.DeleteContext(context.Background(), collection, resource)
+}
+
+// DeleteContext is Delete giving up when ctx is done before the
+// collection lock is acquired
+func (d *Driver) DeleteContext(ctx context.Context, collection, resource string) (err error) {
+	start := time.Now()
+	defer d.done("delete", collection, resource, start, &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return err
 	}
+	defer end()
 
-	mutex := d.getOrCreateMutex(collection)
-	mutex.Lock()
-	defer mutex.Unlock()
+	if err := checkNames(collection, resource); err != nil {
+		return err
+	}
 
-	filePath := filepath.Join(d.dir, collection, resource+".json")
-	if err := os.Remove(filePath); err != nil {
-		if os.IsNotExist(err) {
-			return &DbError{Code: ErrCodeNotFound, Message: fmt.Sprintf("resource '%s' not found in collection '%s'", resource, collection)}
-		}
-		return &DbError{Code: ErrCodeInternal, Message: "failed to delete file", Err: err}
+	unlock, err := d.lockForDelete(ctx, collection)
+	if err != nil {
+		return err
+	}
+	err = d.checkRestrict(collection, resource)
+	var event *HookEvent
+	if err == nil {
+		event, err = d.deleteLocked(collection, resource)
+	}
+	unlock()
+	if err != nil {
+		return err
 	}
 
-	// Update stats
-	d.updateStats(collection, "delete")
-	return nil
+	d.runAfterHooks(AfterDelete, event)
+	err = d.applyDeleteActions(collection, resource)
+
+	// Update stats; the resource is gone even if its delete actions failed
+	d.updateStats(collection, "delete", start)
+	return err
 }
 
 // Query performs a simple query operation on a collection
 func (d *Driver) Query(collection string, query Query) ([]interface{}, error) {
-	records, err := d.ReadAll(collection)
+	return d.QueryContext(context.Background(), collection, query)
+}
+
+// QueryContext is Query stopping the collection scan once ctx is done
+func (d *Driver) QueryContext(ctx context.Context, collection string, query Query) (_ []interface{}, err error) {
+	start := time.Now()
+	defer d.done("query", collection, "", start, &err)
+	docs, _, err := d.runStatement(ctx, collection, &Statement{Where: &Filter{Query: &query}})
 	if err != nil {
 		return nil, err
 	}
 
 	var results []interface{}
-	for _, record := range records {
-		var data map[string]interface{}
-		if err := json.Unmarshal([]byte(record), &data); err != nil {
-			continue
-		}
-
-		if value, exists := data[query.Field]; exists {
-			matches := false
-			switch query.Operator {
-			case "eq":
-				matches = reflect.DeepEqual(value, query.Value)
-			case "gt":
-				matches = compareValues(value, query.Value) > 0
-			case "lt":
-				matches = compareValues(value, query.Value) < 0
-			}
-			if matches {
-				results = append(results, data)
-			}
-		}
+	for _, data := range docs {
+		results = append(results, data)
 	}
 
+	d.updateStats(collection, "query", start)
 	return results, nil
 }
 
 func (d *Driver) ReadAll(collection string) ([]string, error) {
-	dir := filepath.Join(d.dir, collection)
-	files, err := os.ReadDir(dir)
-	if err != nil {
+	return d.ReadAllContext(context.Background(), collection)
+}
+
+// ReadAllContext is ReadAll stopping the collection scan once ctx is done
+func (d *Driver) ReadAllContext(ctx context.Context, collection string) (_ []string, err error) {
+	defer d.done("read_all", collection, "", time.Now(), &err)
+	if err := checkCollection(collection); err != nil {
 		return nil, err
 	}
 
 	var records []string
-	for _, file := range files {
-		if filepath.Ext(file.Name()) == ".json" {
-			data, err := os.ReadFile(filepath.Join(dir, file.Name()))
-			if err != nil {
-				return nil, err
+	err = WalkResources(d.collectionDir(collection), func(resource, path string, _ fs.DirEntry) error {
+		if err := ctx.Err(); err != nil {
+			return canceled(err)
+		}
+
+		entry, err := d.loadFound(collection, resource, path)
+		if err != nil {
+			if isNotFound(err) {
+				return nil
 			}
-			records = append(records, string(data))
+			return err
 		}
+		records = append(records, string(entry.raw))
+		return nil
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, collectionNotFound(collection)
+		}
+		return nil, walkError(err)
 	}
 
 	return records, nil
 }
 
-// GetStats returns the current statistics for a collection
+// Dir returns the database directory
+func (d *Driver) Dir() string {
+	return d.dir
+}
+
+// GetStats returns the current statistics for a collection, counting its
+// documents. Those of a collection with a document or byte limit are
+// counted once and then kept up to date by writes and deletes. A
+// collection with limits also reports them, with the bytes it uses.
 func (d *Driver) GetStats(collection string) map[string]interface{} {
-	d.stats.mutex.Lock()
-	defer d.stats.mutex.Unlock()
+	if checkCollection(collection) != nil {
+		return d.stats.Get(collection)
+	}
+
+	mutex := d.lockCollection(collection)
+	usage, err := d.usageLocked(collection)
+	mutex.Unlock()
+	if err == nil {
+		d.stats.SetRecordCount(collection, usage.documents)
+	}
+
+	stats := d.stats.Get(collection)
+	if limits := d.Limits(collection); !limits.IsZero() && err == nil {
+		stats["limits"] = limits
+		stats["size_bytes"] = usage.bytes
+	}
+	return stats
+}
+
+// Stats returns the statistics shared by every collection of the driver
+func (d *Driver) Stats() *CollectionStats {
+	return d.stats
+}
 
-	return map[string]interface{}{
-		"operations":   d.stats.Operations[collection],
-		"access_time":  time.Unix(d.stats.AccessTime[collection], 0),
-		"record_count": d.stats.RecordCount[collection],
+func (d *Driver) updateStats(collection, operation string, start time.Time) {
+	d.stats.Record(collection, operation, time.Since(start))
+
+	// Writes and deletes keep the usage of a limited collection up to
+	// date; that of others is counted by GetStats
+	if usage, ok := d.knownUsage(collection); ok {
+		d.stats.SetRecordCount(collection, usage.documents)
 	}
 }
 
-func (d *Driver) updateStats(collection, operation string) {
-	d.stats.mutex.Lock()
-	defer d.stats.mutex.Unlock()
+// writeLocked stores data as the resource. The caller holds the
+// collection mutex.
+func (d *Driver) writeLocked(collection, resource string, data interface{}) (*HookEvent, error) {
+	event := &HookEvent{Collection: collection, Resource: resource}
+
+	if d.hasHooks(collection) {
+		old, err := d.readDocument(collection, resource)
+		if err != nil && !isNotFound(err) {
+			return nil, err
+		}
+
+		doc, err := toDocument(data)
+		if err != nil {
+			return nil, err
+		}
+
+		event.Old, event.New = old, doc
+		if err := d.runBeforeHooks(BeforeWrite, event); err != nil {
+			return nil, err
+		}
+		data = event.New
+	}
+
+	if err := d.validate(collection, data); err != nil {
+		return nil, err
+	}
+	if err := d.checkUnique(collection, resource, data); err != nil {
+		return nil, err
+	}
+
+	raw, err := d.writeFile(collection, resource, data)
+	if err != nil {
+		return nil, err
+	}
 
-	d.stats.Operations[collection]++
-	d.stats.AccessTime[collection] = time.Now().Unix()
-	
-	// Update record count
-	files, _ := os.ReadDir(filepath.Join(d.dir, collection))
-	d.stats.RecordCount[collection] = len(files)
+	d.committed(collection, resource, raw)
+	return event, nil
 }
 
-func (d *Driver) getOrCreateMutex(collection string) *sync.Mutex {
+// updateLocked reads the resource, lets apply modify it and stores the
+// document apply returns. The caller holds the collection mutex.
+func (d *Driver) updateLocked(collection, resource string, apply func(map[string]interface{}) (map[string]interface{}, error)) (*HookEvent, error) {
+	data, err := d.readDocument(collection, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &HookEvent{Collection: collection, Resource: resource}
+	hooks := d.hasHooks(collection)
+	if hooks {
+		event.Old = cloneDocument(data)
+	}
+
+	if data, err = apply(data); err != nil {
+		return nil, err
+	}
+	event.New = data
+
+	if hooks {
+		if event.New, err = toDocument(data); err != nil {
+			return nil, err
+		}
+		if err := d.runBeforeHooks(BeforeWrite, event); err != nil {
+			return nil, err
+		}
+	}
+
+	// Validate updated data
+	if err := d.validate(collection, event.New); err != nil {
+		return nil, err
+	}
+	if err := d.checkUnique(collection, resource, event.New); err != nil {
+		return nil, err
+	}
+
+	raw, err := d.writeFile(collection, resource, event.New)
+	if err != nil {
+		return nil, err
+	}
+
+	d.committed(collection, resource, raw)
+	return event, nil
+}
+
+// deleteLocked removes the resource. The caller holds the locks taken by
+// lockForDelete and checked the Restrict references with checkRestrict.
+func (d *Driver) deleteLocked(collection, resource string) (*HookEvent, error) {
+	event := &HookEvent{Collection: collection, Resource: resource}
+
+	if d.hasHooks(collection) {
+		old, err := d.readDocument(collection, resource)
+		if err != nil {
+			return nil, err
+		}
+
+		event.Old = old
+		if err := d.runBeforeHooks(BeforeDelete, event); err != nil {
+			return nil, err
+		}
+	}
+
+	remove := d.removeResource
+	if d.trash {
+		remove = d.trashResource
+	}
+	if err := remove(collection, resource); err != nil {
+		if os.IsNotExist(err) {
+			return nil, notFound(collection, resource)
+		}
+		var dbErr *DbError
+		if errors.As(err, &dbErr) {
+			return nil, err
+		}
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to delete file", Err: err}
+	}
+
+	d.removed(collection, resource)
+
+	if err := d.removeSlugsLocked(collection, resource); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func (d *Driver) validate(collection string, data interface{}) error {
+	d.mutex.Lock()
+	validator, exists := d.validators[collection]
+	d.mutex.Unlock()
+
+	if exists {
+		if err := validator(data); err != nil {
+			return &DbError{Code: ErrCodeInvalidInput, Message: "validation failed", Err: err}
+		}
+	}
+	return d.checkReferences(collection, data)
+}
+
+// collectionDir returns the directory of a collection. Names are escaped
+// with escapeName, so they must have been checked by the caller.
+func (d *Driver) collectionDir(collection string) string {
+	return filepath.Join(d.dir, escapeName(collection))
+}
+
+// resourcePath returns the file of a resource under the layout of its
+// collection, or wherever it still is while the collection is sharded.
+// The layout is read on every call, not cached, so that a shard run by
+// another process such as colddb shard is followed as soon as it records
+// the new layout.
+func (d *Driver) resourcePath(collection, resource string) (string, error) {
+	dir := d.collectionDir(collection)
+	layout, err := ReadLayout(dir)
+	if err != nil {
+		return "", err
+	}
+	return layout.Locate(dir, resource), nil
+}
+
+func resourceFile(resource string) string {
+	return escapeName(resource) + ".json"
+}
+
+func (d *Driver) readFile(collection, resource string) ([]byte, error) {
+	entry, err := d.load(collection, resource)
+	if err != nil {
+		return nil, err
+	}
+	return entry.raw, nil
+}
+
+func (d *Driver) readDocument(collection, resource string) (map[string]interface{}, error) {
+	entry, err := d.load(collection, resource)
+	if err != nil {
+		return nil, err
+	}
+	return entry.document()
+}
+
+// writeFile stores data as the resource file and returns the bytes
+// written
+func (d *Driver) writeFile(collection, resource string, data interface{}) ([]byte, error) {
+	b, err := marshalDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkLimits(collection, resource, b); err != nil {
+		return nil, err
+	}
+	if err := d.writeResource(collection, resource, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// marshalDocument returns the bytes stored for a document
+func marshalDocument(data interface{}) ([]byte, error) {
+	b, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to marshal data", Err: err}
+	}
+	return append(b, byte('\n')), nil
+}
+
+// writeResource replaces the file of a resource at its place in the
+// collection layout and removes copies left under other layouts
+func (d *Driver) writeResource(collection, resource string, b []byte) error {
+	dir := d.collectionDir(collection)
+	layout, err := ReadLayout(dir)
+	if err != nil {
+		return err
+	}
+
+	// The usage counts a resource once, as the file Locate finds, so the
+	// write replaces that one whichever copies it removes
+	track, err := d.trackWrite(collection, layout.Locate(dir, resource), int64(len(b)))
+	if err != nil {
+		return err
+	}
+	if err := writeAtomic(layout.Path(dir, resource), b); err != nil {
+		return err
+	}
+	track()
+	if layout.Levels > 0 {
+		return removeCopies(layout, dir, resource)
+	}
+	return nil
+}
+
+// removeCopies removes the files of a resource under the layouts other
+// than the current one
+func removeCopies(layout Layout, dir, resource string) error {
+	for _, path := range layout.Others(dir, resource) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return &DbError{Code: ErrCodeInternal, Message: "failed to remove moved file", Err: err}
+		}
+	}
+	return nil
+}
+
+// writeRaw replaces a metadata file of a collection directory
+func (d *Driver) writeRaw(collection, name string, b []byte) error {
+	return writeAtomic(filepath.Join(d.collectionDir(collection), name), b)
+}
+
+// writeAtomic replaces a file through a temporary file, so readers never
+// see a partial write
+func writeAtomic(finalPath string, b []byte) error {
+	tmpPath := finalPath + ".tmp"
+
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+		return &DbError{Code: ErrCodeInternal, Message: "failed to create directory", Err: err}
+	}
+
+	if err := os.WriteFile(tmpPath, b, 0644); err != nil {
+		return &DbError{Code: ErrCodeInternal, Message: "failed to write file", Err: err}
+	}
+
+	if err := os.Rename(tmpPath, finalPath); err != nil {
+		return &DbError{Code: ErrCodeInternal, Message: "failed to rename file", Err: err}
+	}
+	return nil
+}
+
+// committed is called with the collection mutex held once a resource was
+// stored, so that derived state follows the data
+func (d *Driver) committed(collection, resource string, raw []byte) {
+	d.uncache(collection, resource)
+	d.indexDocument(collection, resource, raw)
+	d.indexUnique(collection, resource, raw)
+	d.replicate(Change{Op: OpPut, Collection: collection, Resource: resource, Data: raw})
+}
+
+// removed is called with the collection mutex held once a resource was
+// deleted
+func (d *Driver) removed(collection, resource string) {
+	d.uncache(collection, resource)
+	d.unindexDocument(collection, resource)
+	d.unindexUnique(collection, resource)
+	d.replicate(Change{Op: OpDelete, Collection: collection, Resource: resource})
+}
+
+// reset is called with the collection mutex held when a collection was
+// dropped or renamed, discarding every derived state
+func (d *Driver) reset(collection string) {
+	d.uncacheCollection(collection)
+	d.dropSearchIndex(collection)
+	d.resetUnique(collection)
+	d.forgetUsage(collection)
+}
+
+// listResources returns the names of every resource in a collection
+func (d *Driver) listResources(collection string) ([]string, error) {
+	var resources []string
+	err := WalkResources(d.collectionDir(collection), func(resource, _ string, _ fs.DirEntry) error {
+		resources = append(resources, resource)
+		return nil
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, walkError(err)
+	}
+	return resources, nil
+}
+
+func (d *Driver) exists(collection, resource string) (bool, error) {
+	path, err := d.resourcePath(collection, resource)
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, &DbError{Code: ErrCodeInternal, Message: "failed to stat file", Err: err}
+	}
+	return true, nil
+}
+
+func notFound(collection, resource string) *DbError {
+	return &DbError{Code: ErrCodeNotFound, Message: fmt.Sprintf("resource '%s' not found in collection '%s'", resource, collection)}
+}
+
+func alreadyExists(collection, resource string) *DbError {
+	return &DbError{Code: ErrCodeAlreadyExists, Message: fmt.Sprintf("resource '%s' already exists in collection '%s'", resource, collection)}
+}
+
+func isNotFound(err error) bool {
+	var dbErr *DbError
+	return errors.As(err, &dbErr) && dbErr.Code == ErrCodeNotFound
+}
+
+func (d *Driver) getOrCreateMutex(collection string) *collectionLock {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
 	m, ok := d.mutexes[collection]
 	if !ok {
-		m = &sync.Mutex{}
+		m = newCollectionLock()
 		d.mutexes[collection] = m
 	}
 	return m
 }
 
-// Helper function to compare values
-func compareValues(a, b interface{}) int {
-	switch v1 := a.(type) {
-	case float64:
-		if v2, ok := b.(float64); ok {
+// matchQuery reports whether a document satisfies a query. Dotted fields
+// such as "albums.year" reach into nested objects; when they cross an
+// array the query matches if any element does.
+func matchQuery(data map[string]interface{}, query Query) bool {
+	for _, value := range lookupPath(data, query.Field) {
+		if matchValue(value, query.Operator, query.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchValue(value interface{}, operator string, target interface{}) bool {
+	switch operator {
+	case "eq":
+		return valuesEqual(value, target)
+	case "ne":
+		return !valuesEqual(value, target)
+	case "gt", "gte", "lt", "lte":
+		c, ok := compareValues(value, target)
+		if !ok {
+			return false
+		}
+		switch operator {
+		case "gt":
+			return c > 0
+		case "gte":
+			return c >= 0
+		case "lt":
+			return c < 0
+		}
+		return c <= 0
+	case "in":
+		list, ok := target.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, item := range list {
+			if valuesEqual(value, item) {
+				return true
+			}
+		}
+	case "contains":
+		v, ok1 := value.(string)
+		t, ok2 := target.(string)
+		return ok1 && ok2 && strings.Contains(foldText(v), foldText(t))
+	}
+	return false
+}
+
+// lookupPath returns every value found at a dotted path, flattening the
+// arrays met on the way
+func lookupPath(data interface{}, path string) []interface{} {
+	values := []interface{}{data}
+	for _, key := range strings.Split(path, ".") {
+		var next []interface{}
+		for _, v := range values {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			child, exists := m[key]
+			if !exists {
+				continue
+			}
+			if arr, ok := child.([]interface{}); ok {
+				next = append(next, arr...)
+			} else {
+				next = append(next, child)
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+func valuesEqual(a, b interface{}) bool {
+	if c, ok := compareValues(a, b); ok {
+		return c == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Helper function to compare values. The second result is false when the
+// values are not both numbers or both strings.
+func compareValues(a, b interface{}) (int, bool) {
+	if v1, ok := toFloat(a); ok {
+		if v2, ok := toFloat(b); ok {
 			if v1 < v2 {
-				return -1
+				return -1, true
 			} else if v1 > v2 {
-				return 1
+				return 1, true
 			}
-			return 0
+			return 0, true
 		}
-	case string:
+		return 0, false
+	}
+
+	if v1, ok := a.(string); ok {
 		if v2, ok := b.(string); ok {
-			return strings.Compare(v1, v2)
+			return strings.Compare(v1, v2), true
 		}
 	}
-	return 0
+	return 0, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
 }
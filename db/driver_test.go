@@ -0,0 +1,25 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/jcelliott/lumber"
+)
+
+// newTestDriver opens a driver on a fresh directory, logging errors only
+func newTestDriver(t *testing.T, options *Options) *Driver {
+	t.Helper()
+	opts := Options{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.Logger == nil {
+		opts.Logger = lumber.NewConsoleLogger(lumber.ERROR)
+	}
+
+	d, err := New(t.TempDir(), &opts)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return d
+}
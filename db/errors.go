@@ -1,12 +1,26 @@
 package db
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
 
 // Custom error types
 type DbError struct {
 	Code    int
 	Message string
-	Err     error
+	Err     error // Underlying error, if any
+
+	// Operation, collection and resource the error happened in, set by
+	// the public methods of Driver; empty when unknown or not relevant
+	Op         string
+	Collection string
+	Resource   string
+
+	sentinel bool
 }
 
 func (e *DbError) Error() string {
@@ -16,8 +30,130 @@ func (e *DbError) Error() string {
 	return e.Message
 }
 
+// Unwrap returns the underlying error
+func (e *DbError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error matches target: the sentinel of its code,
+// such as ErrNotFound, or os.ErrNotExist and os.ErrExist for the not found
+// and already exists codes
+func (e *DbError) Is(target error) bool {
+	switch target {
+	case os.ErrNotExist:
+		return e.Code == ErrCodeNotFound
+	case os.ErrExist:
+		return e.Code == ErrCodeAlreadyExists
+	}
+	t, ok := target.(*DbError)
+	return ok && t.sentinel && t.Code == e.Code
+}
+
 const (
-	ErrCodeNotFound     = 404
-	ErrCodeInvalidInput = 400
-	ErrCodeInternal     = 500
+	ErrCodeNotFound           = 404
+	ErrCodeInvalidInput       = 400
+	ErrCodeAlreadyExists      = 409
+	ErrCodeInternal           = 500
+	ErrCodeCanceled           = 499 // The context was canceled or its deadline passed
+	ErrCodeReferenceViolation = 424 // A reference names a missing resource, or a delete is restricted
+	ErrCodeUniqueViolation    = 422 // Another resource holds the same values for a unique constraint
+	ErrCodeReadOnly           = 403 // The database was opened read-only
+	ErrCodeUnavailable        = 503 // The database is in maintenance; retry later
+	ErrCodeTooLarge           = 413 // A document exceeds the size limit of its collection
+	ErrCodeQuotaExceeded      = 507 // A collection would exceed its document or byte limit
+)
+
+// Sentinel errors, one per code, for use with errors.Is:
+//
+//	if errors.Is(err, db.ErrNotFound) { ... }
+var (
+	ErrNotFound           = &DbError{Code: ErrCodeNotFound, Message: "not found", sentinel: true}
+	ErrInvalidInput       = &DbError{Code: ErrCodeInvalidInput, Message: "invalid input", sentinel: true}
+	ErrConflict           = &DbError{Code: ErrCodeAlreadyExists, Message: "already exists", sentinel: true}
+	ErrInternal           = &DbError{Code: ErrCodeInternal, Message: "internal error", sentinel: true}
+	ErrCanceled           = &DbError{Code: ErrCodeCanceled, Message: "operation canceled", sentinel: true}
+	ErrReferenceViolation = &DbError{Code: ErrCodeReferenceViolation, Message: "reference violation", sentinel: true}
+	ErrUniqueViolation    = &DbError{Code: ErrCodeUniqueViolation, Message: "unique violation", sentinel: true}
+	ErrReadOnly           = &DbError{Code: ErrCodeReadOnly, Message: "read-only", sentinel: true}
+	ErrUnavailable        = &DbError{Code: ErrCodeUnavailable, Message: "unavailable", sentinel: true}
+	ErrTooLarge           = &DbError{Code: ErrCodeTooLarge, Message: "document too large", sentinel: true}
+	ErrQuotaExceeded      = &DbError{Code: ErrCodeQuotaExceeded, Message: "quota exceeded", sentinel: true}
 )
+
+// ErrorCode returns the code of a DbError in err's chain. Other errors
+// get ErrCodeNotFound or ErrCodeAlreadyExists when they match os.ErrNotExist
+// or os.ErrExist, ErrCodeCanceled for context errors and ErrCodeInternal
+// otherwise.
+func ErrorCode(err error) int {
+	var dbErr *DbError
+	switch {
+	case errors.As(err, &dbErr):
+		return dbErr.Code
+	case errors.Is(err, os.ErrNotExist):
+		return ErrCodeNotFound
+	case errors.Is(err, os.ErrExist):
+		return ErrCodeAlreadyExists
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ErrCodeCanceled
+	}
+	return ErrCodeInternal
+}
+
+// HTTPStatus returns the HTTP status matching an error, for handlers
+// replying with a database error. A passed deadline is a gateway timeout;
+// other cancellations use the non-standard 499 "client closed request".
+func HTTPStatus(err error) int {
+	switch code := ErrorCode(err); code {
+	case ErrCodeNotFound:
+		return http.StatusNotFound
+	case ErrCodeInvalidInput:
+		return http.StatusBadRequest
+	case ErrCodeAlreadyExists:
+		return http.StatusConflict
+	case ErrCodeUniqueViolation:
+		return http.StatusUnprocessableEntity
+	case ErrCodeReferenceViolation:
+		return http.StatusFailedDependency
+	case ErrCodeReadOnly:
+		return http.StatusForbidden
+	case ErrCodeUnavailable:
+		return http.StatusServiceUnavailable
+	case ErrCodeTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case ErrCodeQuotaExceeded:
+		return http.StatusInsufficientStorage
+	case ErrCodeCanceled:
+		if errors.Is(err, context.DeadlineExceeded) {
+			return http.StatusGatewayTimeout
+		}
+		return code
+	}
+	return http.StatusInternalServerError
+}
+
+// opError attaches the operation, collection and resource to an error
+// returned by a public method, as a copy: the error may be shared, e.g.
+// returned by a hook. Errors that are not a DbError, or wrap one, are
+// wrapped in one whose code follows ErrorCode. The operation replaces the
+// one of a nested call; collection and resource are only set when missing.
+func opError(err error, op, collection, resource string) error {
+	if err == nil {
+		return nil
+	}
+
+	var dbErr *DbError
+	if !errors.As(err, &dbErr) || dbErr.sentinel || err != error(dbErr) {
+		dbErr = &DbError{Code: ErrorCode(err), Message: op + " failed", Err: err}
+	} else {
+		cp := *dbErr
+		dbErr = &cp
+	}
+	dbErr.Op = op
+	if dbErr.Collection == "" {
+		dbErr.Collection = collection
+	}
+	if dbErr.Resource == "" {
+		dbErr.Resource = resource
+	}
+	return dbErr
+}
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"DbError", &DbError{Code: ErrCodeUniqueViolation}, ErrCodeUniqueViolation},
+		{"wrapped DbError", fmt.Errorf("hook: %w", &DbError{Code: ErrCodeTooLarge}), ErrCodeTooLarge},
+		{"sentinel", ErrUnavailable, ErrCodeUnavailable},
+		{"not exist", os.ErrNotExist, ErrCodeNotFound},
+		{"exist", fmt.Errorf("create: %w", os.ErrExist), ErrCodeAlreadyExists},
+		{"canceled", context.Canceled, ErrCodeCanceled},
+		{"deadline", context.DeadlineExceeded, ErrCodeCanceled},
+		{"other", errors.New("boom"), ErrCodeInternal},
+	}
+
+	for _, tt := range tests {
+		if got := ErrorCode(tt.err); got != tt.want {
+			t.Errorf("%s: ErrorCode() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{ErrNotFound, http.StatusNotFound},
+		{ErrInvalidInput, http.StatusBadRequest},
+		{ErrConflict, http.StatusConflict},
+		{ErrUniqueViolation, http.StatusUnprocessableEntity},
+		{ErrReferenceViolation, http.StatusFailedDependency},
+		{ErrReadOnly, http.StatusForbidden},
+		{ErrUnavailable, http.StatusServiceUnavailable},
+		{ErrTooLarge, http.StatusRequestEntityTooLarge},
+		{ErrQuotaExceeded, http.StatusInsufficientStorage},
+		{canceled(context.Canceled), ErrCodeCanceled},
+		{canceled(context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{errors.New("boom"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		if got := HTTPStatus(tt.err); got != tt.want {
+			t.Errorf("HTTPStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDbErrorIs(t *testing.T) {
+	err := notFound("bands", "opeth")
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("%v does not match ErrNotFound and os.ErrNotExist", err)
+	}
+	if errors.Is(err, ErrConflict) {
+		t.Errorf("%v matches ErrConflict", err)
+	}
+	// Only sentinels match by code
+	if errors.Is(err, &DbError{Code: ErrCodeNotFound}) {
+		t.Errorf("%v matches a DbError that is not a sentinel", err)
+	}
+}
+
+func TestPublicErrorsNameTheOperation(t *testing.T) {
+	d := newTestDriver(t, nil)
+	var doc map[string]interface{}
+	err := d.Read("bands", "opeth", &doc)
+
+	var dbErr *DbError
+	if !errors.As(err, &dbErr) {
+		t.Fatalf("Read() error = %v, want a *DbError", err)
+	}
+	if dbErr.Op != "read" || dbErr.Collection != "bands" || dbErr.Resource != "opeth" || !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read() error = %+v, want the read of bands/opeth not found", dbErr)
+	}
+}
+
+func TestOpErrorCopies(t *testing.T) {
+	shared := &DbError{Code: ErrCodeInvalidInput, Message: "rejected by hook"}
+
+	first := opError(shared, "write", "bands", "opeth")
+	second := opError(shared, "delete", "albums", "orchid")
+
+	if shared.Op != "" || shared.Collection != "" || shared.Resource != "" {
+		t.Errorf("opError() changed the caller's error: %+v", shared)
+	}
+	var a, b *DbError
+	if !errors.As(first, &a) || !errors.As(second, &b) {
+		t.Fatal("opError() did not return a DbError")
+	}
+	if a.Op != "write" || a.Collection != "bands" || a.Resource != "opeth" {
+		t.Errorf("first = %+v", a)
+	}
+	if b.Op != "delete" || b.Collection != "albums" || b.Resource != "orchid" {
+		t.Errorf("second = %+v", b)
+	}
+}
+
+func TestOpErrorWraps(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"sentinel", ErrNotFound, ErrCodeNotFound},
+		{"plain", errors.New("boom"), ErrCodeInternal},
+		{"wrapped DbError", fmt.Errorf("hook: %w", &DbError{Code: ErrCodeUniqueViolation}), ErrCodeUniqueViolation},
+	}
+
+	for _, tt := range tests {
+		err := opError(tt.err, "write", "bands", "opeth")
+		var dbErr *DbError
+		if !errors.As(err, &dbErr) || dbErr.sentinel {
+			t.Errorf("%s: opError() = %#v, want a new DbError", tt.name, err)
+			continue
+		}
+		if dbErr.Code != tt.code || dbErr.Op != "write" || !errors.Is(err, tt.err) {
+			t.Errorf("%s: opError() = %+v, want code %d wrapping the error", tt.name, dbErr, tt.code)
+		}
+	}
+	if opError(nil, "write", "bands", "opeth") != nil {
+		t.Error("opError(nil) != nil")
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{notFound("bands", "opeth"), true},
+		{fmt.Errorf("read: %w", notFound("bands", "opeth")), true},
+		{&DbError{Code: ErrCodeInternal}, false},
+		{os.ErrNotExist, false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := isNotFound(tt.err); got != tt.want {
+			t.Errorf("isNotFound(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestBatchWriteKeepsWrappedCodes(t *testing.T) {
+	d := newTestDriver(t, nil)
+	d.AddHook("bands", BeforeWrite, func(e *HookEvent) error {
+		return fmt.Errorf("quota hook: %w", &DbError{Code: ErrCodeQuotaExceeded, Message: "full"})
+	})
+
+	err := d.BatchWrite("bands", map[string]interface{}{"opeth": map[string]interface{}{"name": "Opeth"}})
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("BatchWrite() error = %v, want ErrQuotaExceeded", err)
+	}
+}
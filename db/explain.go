@@ -0,0 +1,258 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// QueryPlan describes how a query read a collection
+type QueryPlan struct {
+	Collection string
+	Index      string        // Index used, e.g. "unique(name)"; empty for a full scan
+	Estimated  int           // Documents expected to be examined
+	Examined   int           // Documents actually examined
+	Returned   int           // Documents returned
+	Duration   time.Duration // Time spent running the query
+}
+
+// FullScan reports whether the query examined every document of the
+// collection
+func (p *QueryPlan) FullScan() bool {
+	return p.Index == ""
+}
+
+func (p *QueryPlan) String() string {
+	access := "full scan"
+	if !p.FullScan() {
+		access = "index " + p.Index
+	}
+	return fmt.Sprintf("%s on '%s': examined %d of %d estimated documents, returned %d in %s",
+		access, p.Collection, p.Examined, p.Estimated, p.Returned, p.Duration)
+}
+
+// Explain runs a statement like Find and reports how it was executed. An
+// equality on the field of a single-field unique constraint is answered
+// from the constraint's index; every other statement scans the
+// collection.
+func (d *Driver) Explain(collection string, st *Statement) (*QueryPlan, error) {
+	return d.ExplainContext(context.Background(), collection, st)
+}
+
+// ExplainContext is Explain stopping the query once ctx is done
+func (d *Driver) ExplainContext(ctx context.Context, collection string, st *Statement) (_ *QueryPlan, err error) {
+	defer d.done("explain", collection, "", time.Now(), &err)
+	_, plan, err := d.runStatement(ctx, collection, st)
+	return plan, err
+}
+
+// runStatement executes a statement, through an index when one applies,
+// and logs it if it was slow
+func (d *Driver) runStatement(ctx context.Context, collection string, st *Statement) ([]map[string]interface{}, *QueryPlan, error) {
+	start := time.Now()
+	if err := checkCollection(collection); err != nil {
+		return nil, nil, err
+	}
+	if st == nil {
+		st = &Statement{}
+	}
+
+	plan := &QueryPlan{Collection: collection}
+	docs, err := d.indexedDocuments(ctx, collection, st.Where, plan)
+	if err != nil {
+		return nil, nil, err
+	}
+	if plan.FullScan() {
+		// A full scan examines every file; count them on the same walk
+		if docs, plan.Estimated, err = d.readAllDocuments(ctx, collection); err != nil {
+			return nil, nil, err
+		}
+		plan.Examined = len(docs)
+	}
+
+	results := st.Apply(docs)
+	plan.Returned = len(results)
+	plan.Duration = time.Since(start)
+
+	if d.slowQuery > 0 && plan.Duration >= d.slowQuery {
+		d.logEvent(slog.LevelWarn, "Slow query", slog.String("op", "query"), slog.String("collection", collection),
+			slog.String("query", st.String()), slog.String("plan", plan.String()),
+			slog.Int("examined", plan.Examined), slog.Int("returned", plan.Returned),
+			slog.Duration("duration", plan.Duration))
+	}
+	return results, plan, nil
+}
+
+// indexedDocuments returns the candidate documents of a filter found
+// through a unique index and records the index in plan. It leaves plan
+// untouched when no index applies.
+func (d *Driver) indexedDocuments(ctx context.Context, collection string, f *Filter, plan *QueryPlan) ([]map[string]interface{}, error) {
+	indexes := d.uniqueIndexes(collection)
+	if len(indexes) == 0 || f == nil {
+		return nil, nil
+	}
+
+	for _, q := range equalities(f) {
+		for _, idx := range indexes {
+			if len(idx.Fields) != 1 || idx.Fields[0] != q.Field {
+				continue
+			}
+
+			resource, ok, err := d.lookupUnique(ctx, collection, idx, q.Value)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+
+			plan.Index = fmt.Sprintf("unique(%s)", idx)
+			plan.Estimated = 1
+			if resource == "" {
+				return nil, nil
+			}
+
+			plan.Examined = 1
+			doc, err := d.readDocument(collection, resource)
+			if err != nil {
+				if isNotFound(err) {
+					return nil, nil
+				}
+				return nil, err
+			}
+			return []map[string]interface{}{doc}, nil
+		}
+	}
+	return nil, nil
+}
+
+// lookupUnique returns the resource holding value in a single-field
+// index, "" if none does. The second result is false when the index cannot
+// answer the lookup, e.g. because some documents hold several values in
+// the field.
+func (d *Driver) lookupUnique(ctx context.Context, collection string, idx *uniqueIndex, value interface{}) (string, bool, error) {
+	if value == nil {
+		return "", false, nil
+	}
+	if f, ok := toFloat(value); ok {
+		// Stored numbers are decoded as float64
+		value = f
+	}
+	doc := make(map[string]interface{})
+	setPath(doc, strings.Split(idx.Fields[0], "."), value)
+	key, ok := idx.Key(doc)
+	if !ok {
+		return "", false, nil
+	}
+
+	mutex, err := d.lockContext(ctx, collection)
+	if err != nil {
+		return "", false, err
+	}
+	defer mutex.Unlock()
+
+	if idx.owners == nil {
+		if err := d.buildUniqueIndex(collection, idx); err != nil {
+			return "", false, err
+		}
+	}
+	if idx.lists > 0 {
+		return "", false, nil
+	}
+	return idx.owners[key], true, nil
+}
+
+// equalities returns the eq conditions that every document matching f
+// must satisfy
+func equalities(f *Filter) []Query {
+	switch {
+	case f.Query != nil:
+		if f.Query.Operator == "eq" {
+			return []Query{*f.Query}
+		}
+	case f.And != nil:
+		var queries []Query
+		for i := range f.And {
+			queries = append(queries, equalities(&f.And[i])...)
+		}
+		return queries
+	}
+	return nil
+}
+
+// Operators of Query in the syntax of ParseStatement
+var operatorSymbols = map[string]string{
+	"eq": "=", "ne": "!=", "gt": ">", "gte": ">=", "lt": "<", "lte": "<=",
+	"in": "in", "contains": "contains",
+}
+
+func (st *Statement) String() string {
+	var parts []string
+	if st.Where != nil {
+		parts = append(parts, st.Where.String())
+	}
+	if len(st.OrderBy) > 0 {
+		var sorts []string
+		for _, s := range st.OrderBy {
+			if s.Desc {
+				sorts = append(sorts, s.Field+" desc")
+			} else {
+				sorts = append(sorts, s.Field)
+			}
+		}
+		parts = append(parts, "order by "+strings.Join(sorts, ", "))
+	}
+	if st.Limit > 0 {
+		parts = append(parts, fmt.Sprintf("limit %d", st.Limit))
+	}
+	if len(parts) == 0 {
+		return "all"
+	}
+	return strings.Join(parts, " ")
+}
+
+func (f *Filter) String() string {
+	switch {
+	case f == nil:
+		return ""
+	case f.Query != nil:
+		op, ok := operatorSymbols[f.Query.Operator]
+		if !ok {
+			op = f.Query.Operator
+		}
+		return fmt.Sprintf("%s %s %s", f.Query.Field, op, formatValue(f.Query.Value))
+	case f.And != nil:
+		return joinFilters(f.And, " and ")
+	case f.Or != nil:
+		return joinFilters(f.Or, " or ")
+	case f.Not != nil:
+		return "not " + f.Not.String()
+	}
+	return ""
+}
+
+func joinFilters(filters []Filter, sep string) string {
+	parts := make([]string, len(filters))
+	for i := range filters {
+		parts[i] = filters[i].String()
+	}
+	return "(" + strings.Join(parts, sep) + ")"
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, e := range v {
+			parts[i] = formatValue(e)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return fmt.Sprintf("%v", v)
+}
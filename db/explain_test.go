@@ -0,0 +1,81 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	d := newTestDriver(t, nil)
+	for name, year := range map[string]float64{"opeth": 1990, "camel": 1971, "yes": 1968} {
+		if err := d.Write("bands", name, map[string]interface{}{"name": name, "year": year}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.AddUnique("bands", UniqueConstraint{Fields: []string{"name"}}); err != nil {
+		t.Fatal(err)
+	}
+	// A file that is not a document is walked but never examined
+	path, err := d.resourcePath("bands", "broken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query                         string
+		index                         string
+		estimated, examined, returned int
+	}{
+		{"year < 1980", "", 4, 3, 2},
+		{"", "", 4, 3, 3},
+		{`name = "camel"`, "unique(name)", 1, 1, 1},
+		{`name = "camel" and year > 2000`, "unique(name)", 1, 1, 0},
+		{`name = "genesis"`, "unique(name)", 1, 0, 0},
+		{`name != "camel"`, "", 4, 3, 2},
+	}
+
+	for _, tt := range tests {
+		st, err := ParseStatement(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := d.Explain("bands", st)
+		if err != nil {
+			t.Errorf("Explain(%s) error = %v", tt.query, err)
+			continue
+		}
+		if plan.Index != tt.index || plan.Estimated != tt.estimated || plan.Examined != tt.examined || plan.Returned != tt.returned {
+			t.Errorf("Explain(%s) = %s, want index %q, %d estimated, %d examined, %d returned",
+				tt.query, plan, tt.index, tt.estimated, tt.examined, tt.returned)
+		}
+	}
+}
+
+func TestStatementString(t *testing.T) {
+	tests := []string{
+		"",
+		`(genre = "Rock" and not year >= 1980)`,
+		`(name in ["Opeth", "Camel"] or tags contains "prog") order by year desc, name limit 5`,
+		"country = null",
+	}
+	for _, src := range tests {
+		st, err := ParseStatement(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := src
+		if want == "" {
+			want = "all"
+		}
+		if got := st.String(); got != want {
+			t.Errorf("String() = %s, want %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Filter combines query conditions. Exactly one of Query, And, Or and Not
+// is set; an empty Filter matches every document.
+type Filter struct {
+	Query *Query
+	And   []Filter
+	Or    []Filter
+	Not   *Filter
+}
+
+// Sort orders results by a (possibly dotted) field
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+// Statement is a complete query: a filter, an ordering and a limit. It is
+// usually obtained from ParseStatement.
+type Statement struct {
+	Where   *Filter
+	OrderBy []Sort
+	Limit   int // Zero means no limit
+}
+
+// Match reports whether a document satisfies the filter
+func (f *Filter) Match(doc map[string]interface{}) bool {
+	switch {
+	case f == nil:
+		return true
+	case f.Query != nil:
+		return matchQuery(doc, *f.Query)
+	case f.And != nil:
+		for i := range f.And {
+			if !f.And[i].Match(doc) {
+				return false
+			}
+		}
+		return true
+	case f.Or != nil:
+		for i := range f.Or {
+			if f.Or[i].Match(doc) {
+				return true
+			}
+		}
+		return false
+	case f.Not != nil:
+		return !f.Not.Match(doc)
+	}
+	return true
+}
+
+// Apply filters, sorts and limits documents according to the statement
+func (st *Statement) Apply(docs []map[string]interface{}) []map[string]interface{} {
+	var results []map[string]interface{}
+	for _, doc := range docs {
+		if st.Where.Match(doc) {
+			results = append(results, doc)
+		}
+	}
+
+	if len(st.OrderBy) > 0 {
+		sort.SliceStable(results, func(i, j int) bool {
+			for _, s := range st.OrderBy {
+				a, b := firstValue(results[i], s.Field), firstValue(results[j], s.Field)
+				if (a == nil) != (b == nil) {
+					// Missing values always sort last
+					return b == nil
+				}
+
+				c := compareForSort(a, b)
+				if c == 0 {
+					continue
+				}
+				if s.Desc {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+	}
+
+	if st.Limit > 0 && len(results) > st.Limit {
+		results = results[:st.Limit]
+	}
+	return results
+}
+
+// Find runs a statement against a collection
+func (d *Driver) Find(collection string, st *Statement) ([]interface{}, error) {
+	return d.FindContext(context.Background(), collection, st)
+}
+
+// FindContext is Find stopping the collection scan once ctx is done
+func (d *Driver) FindContext(ctx context.Context, collection string, st *Statement) (_ []interface{}, err error) {
+	start := time.Now()
+	defer d.done("find", collection, "", start, &err)
+	docs, _, err := d.runStatement(ctx, collection, st)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []interface{}
+	for _, doc := range docs {
+		results = append(results, doc)
+	}
+
+	d.updateStats(collection, "find", start)
+	return results, nil
+}
+
+func firstValue(doc map[string]interface{}, field string) interface{} {
+	if values := lookupPath(doc, field); len(values) > 0 {
+		return values[0]
+	}
+	return nil
+}
+
+// compareForSort orders values of different types numbers first, then
+// strings, then anything else
+func compareForSort(a, b interface{}) int {
+	if c, ok := compareValues(a, b); ok {
+		return c
+	}
+
+	ra, rb := sortRank(a), sortRank(b)
+	switch {
+	case ra < rb:
+		return -1
+	case ra > rb:
+		return 1
+	}
+	return 0
+}
+
+func sortRank(v interface{}) int {
+	if _, ok := toFloat(v); ok {
+		return 0
+	}
+	if _, ok := v.(string); ok {
+		return 1
+	}
+	return 2
+}
@@ -0,0 +1,181 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// offsetFile stores the sequence number of the last change a follower
+// directory applied
+const offsetFile = ".replica"
+
+// Follower receives the changes of a database in order
+type Follower interface {
+	// Offset returns the sequence number of the last applied change
+	Offset() uint64
+	// Apply applies the change following Offset
+	Apply(Change) error
+}
+
+// DirFollower replays changes into a database directory of its own. The
+// directory can be read through Driver as a replica; it should not be
+// written to by anything else.
+type DirFollower struct {
+	mutex  sync.Mutex
+	driver *Driver
+	offset uint64
+}
+
+// NewDirFollower opens or creates a follower directory, resuming from the
+// offset it stored
+func NewDirFollower(dir string, options *Options) (*DirFollower, error) {
+	driver, err := New(dir, options)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &DirFollower{driver: driver}
+	b, err := os.ReadFile(filepath.Join(driver.dir, offsetFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to read replication offset", Err: err}
+	}
+	if err == nil {
+		if f.offset, err = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64); err != nil {
+			return nil, &DbError{Code: ErrCodeInternal, Message: "invalid replication offset", Err: err}
+		}
+	}
+	return f, nil
+}
+
+// Driver returns the driver of the follower directory
+func (f *DirFollower) Driver() *Driver {
+	return f.driver
+}
+
+func (f *DirFollower) Offset() uint64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.offset
+}
+
+// Apply applies a change and stores its sequence number. Changes at or
+// below the offset were already applied and are skipped.
+func (f *DirFollower) Apply(c Change) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if c.Seq <= f.offset {
+		return nil
+	}
+	if f.offset != 0 && c.Seq != f.offset+1 {
+		return &DbError{Code: ErrCodeInternal, Message: fmt.Sprintf("replication gap: expected change %d, got %d", f.offset+1, c.Seq)}
+	}
+
+	if err := f.driver.applyChange(c); err != nil {
+		return err
+	}
+
+	path := filepath.Join(f.driver.dir, offsetFile)
+	if err := os.WriteFile(path+".tmp", []byte(strconv.FormatUint(c.Seq, 10)+"\n"), 0644); err != nil {
+		return &DbError{Code: ErrCodeInternal, Message: "failed to write replication offset", Err: err}
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return &DbError{Code: ErrCodeInternal, Message: "failed to write replication offset", Err: err}
+	}
+	f.offset = c.Seq
+	return nil
+}
+
+// FollowOptions configures Follow
+type FollowOptions struct {
+	// Token is sent as a bearer token, for a leader serving its change
+	// log behind RequireToken
+	Token string
+}
+
+// Follow streams the changes served by ChangeLog.Handler at leaderURL into
+// f, reconnecting after failures, until ctx is done
+func Follow(ctx context.Context, leaderURL string, f Follower, opts *FollowOptions) error {
+	u, err := url.Parse(leaderURL)
+	if err != nil {
+		return &DbError{Code: ErrCodeInvalidInput, Message: "invalid leader URL", Err: err}
+	}
+	if opts == nil {
+		opts = &FollowOptions{}
+	}
+
+	for {
+		err := followOnce(ctx, u, f, opts)
+		if ctx.Err() != nil {
+			return canceled(ctx.Err())
+		}
+		var dbErr *DbError
+		if errors.As(err, &dbErr) && dbErr.Code != ErrCodeInternal && !IsRetryable(err) {
+			// Changes the follower cannot apply will not succeed later,
+			// unless it is only in maintenance
+			return err
+		}
+
+		select {
+		case <-time.After(changeLogPollInterval):
+		case <-ctx.Done():
+			return canceled(ctx.Err())
+		}
+	}
+}
+
+func followOnce(ctx context.Context, u *url.URL, f Follower, opts *FollowOptions) error {
+	q := u.Query()
+	q.Set("after", strconv.FormatUint(f.Offset(), 10))
+	streamURL := *u
+	streamURL.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	if opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		// Retrying with the same token cannot succeed
+		return &DbError{Code: ErrCodeInvalidInput, Message: "leader rejected the replication token"}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("leader responded with %s", resp.Status)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+
+		var c Change
+		if err := json.Unmarshal(line, &c); err != nil {
+			return err
+		}
+		if err := f.Apply(c); err != nil {
+			return err
+		}
+	}
+}
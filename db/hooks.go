@@ -0,0 +1,117 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// HookType identifies the point of an operation a hook runs at
+type HookType int
+
+const (
+	// BeforeWrite runs before Write and Update store a document. It may
+	// mutate HookEvent.New or reject the operation by returning an error.
+	BeforeWrite HookType = iota
+	// AfterWrite runs after Write and Update stored a document
+	AfterWrite
+	// BeforeDelete runs before Delete removes a document and may reject it
+	BeforeDelete
+	// AfterDelete runs after Delete removed a document
+	AfterDelete
+	// AfterUpdate runs after Update stored a document
+	AfterUpdate
+)
+
+func (t HookType) String() string {
+	switch t {
+	case BeforeWrite:
+		return "before-write"
+	case AfterWrite:
+		return "after-write"
+	case BeforeDelete:
+		return "before-delete"
+	case AfterDelete:
+		return "after-delete"
+	case AfterUpdate:
+		return "after-update"
+	}
+	return fmt.Sprintf("hook(%d)", int(t))
+}
+
+// HookEvent describes the change a hook is called for. Documents are
+// passed in their generic JSON form; Old is nil when the resource did not
+// exist and New is nil for deletes.
+type HookEvent struct {
+	Type       HookType
+	Collection string
+	Resource   string
+	Old        map[string]interface{}
+	New        map[string]interface{}
+}
+
+// HookFunc is called around driver operations on a collection.
+//
+// Before-hooks run while the collection is locked, so they must not call
+// back into the driver for the same collection. After-hooks run once the
+// lock is released; their errors are logged and do not undo the change.
+type HookFunc func(event *HookEvent) error
+
+// AddHook registers a hook for a collection. Hooks of the same type run in
+// registration order.
+func (d *Driver) AddHook(collection string, hookType HookType, hook HookFunc) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.hooks == nil {
+		d.hooks = make(map[string]map[HookType][]HookFunc)
+	}
+	if d.hooks[collection] == nil {
+		d.hooks[collection] = make(map[HookType][]HookFunc)
+	}
+	d.hooks[collection][hookType] = append(d.hooks[collection][hookType], hook)
+}
+
+func (d *Driver) hooksFor(collection string, hookType HookType) []HookFunc {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.hooks[collection][hookType]
+}
+
+func (d *Driver) hasHooks(collection string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return len(d.hooks[collection]) > 0
+}
+
+func (d *Driver) runBeforeHooks(hookType HookType, event *HookEvent) error {
+	event.Type = hookType
+	for _, hook := range d.hooksFor(event.Collection, hookType) {
+		if err := hook(event); err != nil {
+			var dbErr *DbError
+			if errors.As(err, &dbErr) {
+				return err
+			}
+			return &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("rejected by %s hook", hookType), Err: err}
+		}
+	}
+	return nil
+}
+
+func (d *Driver) runAfterHooks(hookType HookType, event *HookEvent) {
+	hooks := d.hooksFor(event.Collection, hookType)
+	if len(hooks) == 0 {
+		return
+	}
+
+	e := *event
+	e.Type = hookType
+	for _, hook := range hooks {
+		if err := hook(&e); err != nil {
+			d.logEvent(slog.LevelError, "Hook failed", slog.String("hook", hookType.String()),
+				slog.String("collection", e.Collection), slog.String("resource", e.Resource), slog.String("error", err.Error()))
+		}
+	}
+}
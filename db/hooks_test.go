@@ -0,0 +1,114 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBeforeWriteHook(t *testing.T) {
+	d := newTestDriver(t, nil)
+	d.AddHook("bands", BeforeWrite, func(e *HookEvent) error {
+		if e.New["name"] == "" {
+			return errors.New("name is required")
+		}
+		e.New["slug"] = Slugify(e.New["name"].(string))
+		return nil
+	})
+
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := d.Read("bands", "opeth", &doc); err != nil || doc["slug"] != "opeth" {
+		t.Errorf("Read() = %v, %v; want the field the hook set", doc, err)
+	}
+
+	err := d.Write("bands", "camel", map[string]interface{}{"name": ""})
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Write() rejected by a hook error = %v, want ErrInvalidInput", err)
+	}
+	if err := d.Read("bands", "camel", &doc); !errors.Is(err, ErrNotFound) {
+		t.Errorf("rejected document was stored: %v", err)
+	}
+}
+
+func TestBeforeDeleteHook(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth", "locked": true}); err != nil {
+		t.Fatal(err)
+	}
+	d.AddHook("bands", BeforeDelete, func(e *HookEvent) error {
+		if e.Old["locked"] == true {
+			return &DbError{Code: ErrCodeReferenceViolation, Message: "locked"}
+		}
+		return nil
+	})
+
+	// A DbError keeps its code
+	if err := d.Delete("bands", "opeth"); !errors.Is(err, ErrReferenceViolation) {
+		t.Errorf("Delete() error = %v, want ErrReferenceViolation", err)
+	}
+	var doc map[string]interface{}
+	if err := d.Read("bands", "opeth", &doc); err != nil {
+		t.Errorf("Read() after a rejected delete error = %v", err)
+	}
+}
+
+func TestAfterHooks(t *testing.T) {
+	d := newTestDriver(t, nil)
+	var calls []string
+	record := func(hookType HookType) HookFunc {
+		return func(e *HookEvent) error {
+			if e.Type != hookType {
+				t.Errorf("%s hook called with type %s", hookType, e.Type)
+			}
+			calls = append(calls, hookType.String()+" "+e.Resource)
+			return errors.New("ignored")
+		}
+	}
+	for _, hookType := range []HookType{AfterWrite, AfterUpdate, AfterDelete} {
+		d.AddHook("bands", hookType, record(hookType))
+	}
+
+	var updated *HookEvent
+	d.AddHook("bands", AfterUpdate, func(e *HookEvent) error {
+		updated = e
+		return nil
+	})
+
+	steps := []func() error{
+		func() error { return d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}) },
+		func() error { return d.Update("bands", "opeth", map[string]interface{}{"year": 1990}) },
+		func() error { return d.Delete("bands", "opeth") },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("a failing after-hook undid the change: %v", err)
+		}
+	}
+
+	want := []string{"after-write opeth", "after-write opeth", "after-update opeth", "after-delete opeth"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("hooks called = %v, want %v", calls, want)
+	}
+	if updated == nil || updated.Old["year"] != nil || updated.New["year"] != float64(1990) {
+		t.Errorf("after-update event = %+v, want the old and new documents", updated)
+	}
+}
+
+func TestCloneDocument(t *testing.T) {
+	doc := map[string]interface{}{
+		"name":   "Opeth",
+		"albums": []interface{}{map[string]interface{}{"name": "Orchid"}},
+	}
+	clone := cloneDocument(doc)
+	clone["albums"].([]interface{})[0].(map[string]interface{})["name"] = "Morningrise"
+
+	if got := doc["albums"].([]interface{})[0].(map[string]interface{})["name"]; got != "Orchid" {
+		t.Errorf("changing the clone changed the document: %v", got)
+	}
+	if cloneDocument(nil) != nil {
+		t.Error("cloneDocument(nil) != nil")
+	}
+}
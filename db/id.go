@@ -0,0 +1,64 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// Crockford's base32 alphabet, as used by ULIDs
+const idAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var idGen struct {
+	mutex   sync.Mutex
+	lastMs  uint64
+	lastRnd [10]byte
+}
+
+// NewID returns a 26-character ULID-style identifier made of a 48-bit
+// millisecond timestamp and 80 random bits. IDs sort lexicographically by
+// creation time and are strictly increasing within a process.
+func NewID() string {
+	idGen.mutex.Lock()
+	defer idGen.mutex.Unlock()
+
+	ms := uint64(time.Now().UnixMilli())
+	if ms <= idGen.lastMs {
+		// Same millisecond (or clock went back): increment the random
+		// part to keep IDs monotonic
+		ms = idGen.lastMs
+		for i := len(idGen.lastRnd) - 1; i >= 0; i-- {
+			idGen.lastRnd[i]++
+			if idGen.lastRnd[i] != 0 {
+				break
+			}
+		}
+	} else {
+		if _, err := rand.Read(idGen.lastRnd[:]); err != nil {
+			panic(err)
+		}
+		idGen.lastMs = ms
+	}
+
+	var b [16]byte
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(b[:6], ts[2:])
+	copy(b[6:], idGen.lastRnd[:])
+	return encodeID(b)
+}
+
+// encodeID encodes 128 bits as 26 base32 characters
+func encodeID(b [16]byte) string {
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = idAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
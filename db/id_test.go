@@ -0,0 +1,44 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewID(t *testing.T) {
+	prev := NewID()
+	for i := 0; i < 1000; i++ {
+		id := NewID()
+		if len(id) != 26 {
+			t.Fatalf("NewID() = %q, want 26 characters", id)
+		}
+		for _, r := range id {
+			if !strings.ContainsRune(idAlphabet, r) {
+				t.Fatalf("NewID() = %q, %q is not in the alphabet", id, r)
+			}
+		}
+		// Strictly increasing, even within the same millisecond
+		if id <= prev {
+			t.Fatalf("NewID() = %s after %s", id, prev)
+		}
+		prev = id
+	}
+}
+
+func TestEncodeID(t *testing.T) {
+	tests := []struct {
+		b    [16]byte
+		want string
+	}{
+		{[16]byte{}, "00000000000000000000000000"},
+		{[16]byte{15: 1}, "00000000000000000000000001"},
+		{[16]byte{15: 32}, "00000000000000000000000010"},
+		{[16]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, "7ZZZZZZZZZZZZZZZZZZZZZZZZZ"},
+	}
+
+	for _, tt := range tests {
+		if got := encodeID(tt.b); got != tt.want {
+			t.Errorf("encodeID(%x) = %s, want %s", tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// shardFile stores the number of hash prefix levels of a sharded
+// collection; flat collections have none
+const shardFile = ".shards"
+
+// MaxShardLevels is the deepest sharded layout. Each level is a directory
+// named after two hex digits of the resource name's hash, so two levels
+// spread a collection over up to 65536 directories.
+const MaxShardLevels = 3
+
+// Layout is the arrangement of the resource files of a collection
+// directory
+type Layout struct {
+	// Levels is the number of hash prefix directories above each
+	// resource file, 0 for the flat layout
+	Levels int
+}
+
+// ReadLayout returns the layout of a collection directory
+func ReadLayout(collectionDir string) (Layout, error) {
+	b, err := os.ReadFile(filepath.Join(collectionDir, shardFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Layout{}, nil
+		}
+		return Layout{}, &DbError{Code: ErrCodeInternal, Message: "failed to read collection layout", Err: err}
+	}
+
+	levels, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || levels < 0 || levels > MaxShardLevels {
+		return Layout{}, &DbError{Code: ErrCodeInternal, Message: fmt.Sprintf("invalid collection layout '%s'", strings.TrimSpace(string(b)))}
+	}
+	return Layout{Levels: levels}, nil
+}
+
+// Path returns where the file of a resource belongs. The resource name
+// must be valid.
+func (l Layout) Path(collectionDir, resource string) string {
+	if l.Levels == 0 {
+		return filepath.Join(collectionDir, resourceFile(resource))
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(resource))
+	sum := fmt.Sprintf("%08x", h.Sum32())
+
+	parts := []string{collectionDir}
+	for i := 0; i < l.Levels; i++ {
+		parts = append(parts, sum[2*i:2*i+2])
+	}
+	return filepath.Join(append(parts, resourceFile(resource))...)
+}
+
+// Others returns the paths a resource file has under every other layout.
+// While a collection is being sharded its files move from one to the
+// other, so readers look there after a miss and writers remove them.
+func (l Layout) Others(collectionDir, resource string) []string {
+	var paths []string
+	for levels := 0; levels <= MaxShardLevels; levels++ {
+		if levels != l.Levels {
+			paths = append(paths, Layout{levels}.Path(collectionDir, resource))
+		}
+	}
+	return paths
+}
+
+// Locate returns the path of the existing file of a resource, looking
+// under the other layouts when it is not at Path. It returns Path when the
+// resource does not exist.
+func (l Layout) Locate(collectionDir, resource string) string {
+	path := l.Path(collectionDir, resource)
+	if _, err := os.Lstat(path); err == nil || !os.IsNotExist(err) {
+		return path
+	}
+
+	for _, other := range l.Others(collectionDir, resource) {
+		if _, err := os.Lstat(other); err == nil {
+			return other
+		}
+	}
+	return path
+}
+
+// WalkResources calls fn for every resource file of a collection
+// directory, in the order of their file names, whatever their layout.
+// Files that were not written through EncodeName are skipped. A resource
+// found under two layouts while the collection is being sharded is
+// reported once, at its current place. Errors reading the collection
+// directory itself are returned as is.
+func WalkResources(collectionDir string, fn func(resource, path string, entry fs.DirEntry) error) error {
+	layout, err := ReadLayout(collectionDir)
+	if err != nil {
+		return err
+	}
+
+	type file struct {
+		path  string
+		entry fs.DirEntry
+	}
+	found := make(map[string][]file)
+
+	var walk func(dir string, depth int) error
+	walk = func(dir string, depth int) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if depth > 0 && os.IsNotExist(err) {
+				// Removed since its parent was read
+				return nil
+			}
+			return err
+		}
+
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() {
+				if depth < MaxShardLevels && isShardDir(name) {
+					if err := walk(filepath.Join(dir, name), depth+1); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if filepath.Ext(name) == ".json" {
+				found[name] = append(found[name], file{filepath.Join(dir, name), entry})
+			}
+		}
+		return nil
+	}
+	if err := walk(collectionDir, 0); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		resource, err := DecodeName(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+
+		files := found[name]
+		f := files[0]
+		if len(files) > 1 {
+			want := layout.Path(collectionDir, resource)
+			for _, other := range files {
+				if other.path == want {
+					f = other
+				}
+			}
+		}
+		if err := fn(resource, f.path, f.entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkError wraps an error of WalkResources that did not come from its
+// callback
+func walkError(err error) error {
+	var dbErr *DbError
+	if errors.As(err, &dbErr) {
+		return err
+	}
+	return &DbError{Code: ErrCodeInternal, Message: "failed to read collection", Err: err}
+}
+
+// isShardDir reports whether a directory name is a hash prefix
+func isShardDir(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
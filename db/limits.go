@@ -0,0 +1,258 @@
+package db
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// Limits bounds the size of a collection. Zero fields are unlimited.
+//
+// The documents and bytes of a collection with MaxDocuments or MaxBytes
+// are counted once and then kept up to date by the writes and deletes of
+// the driver, which must be the only writer of the collection: files
+// added, changed or removed by another driver or process are only seen
+// once the collection is counted again, after SetLimits, a rename or a
+// drop, or in a new driver.
+type Limits struct {
+	MaxDocumentSize int64 // Bytes of a single stored document
+	MaxDocuments    int   // Number of documents
+	MaxBytes        int64 // Bytes of every document together
+}
+
+// IsZero reports whether no limit is set
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// tracksUsage reports whether the limits need the number of documents or
+// bytes of the collection
+func (l Limits) tracksUsage() bool {
+	return l.MaxDocuments > 0 || l.MaxBytes > 0
+}
+
+// collectionUsage is the number of documents and bytes of a collection.
+// That of a collection with a document or byte limit is counted on its
+// first write or GetStats and then kept up to date by the writes and
+// deletes of the driver.
+type collectionUsage struct {
+	documents int
+	bytes     int64
+}
+
+// SetLimits sets the limits of a collection, replacing those given in
+// Options. Documents already stored are kept when they exceed them. The
+// usage of the collection is counted again when next needed.
+func (d *Driver) SetLimits(collection string, limits Limits) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.limits == nil {
+		d.limits = make(map[string]Limits)
+	}
+	d.limits[collection] = limits
+	delete(d.usage, collection)
+}
+
+// Limits returns the limits of a collection
+func (d *Driver) Limits(collection string) Limits {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if limits, ok := d.limits[collection]; ok {
+		return limits
+	}
+	return d.defaultLimits
+}
+
+// checkLimits rejects storing b as the resource when the document or the
+// collection would exceed its limits. The caller holds the collection
+// mutex.
+func (d *Driver) checkLimits(collection, resource string, b []byte) error {
+	return d.checkBatchLimits(collection, map[string][]byte{resource: b})
+}
+
+// checkBatchLimits rejects storing every document of docs, keyed by
+// resource, when one of them or the collection once all are stored would
+// exceed its limits. The caller holds the collection mutex.
+func (d *Driver) checkBatchLimits(collection string, docs map[string][]byte) error {
+	limits := d.Limits(collection)
+	if limits.IsZero() {
+		return nil
+	}
+
+	if limits.MaxDocumentSize > 0 {
+		for resource, b := range docs {
+			if size := int64(len(b)); size > limits.MaxDocumentSize {
+				return &DbError{Code: ErrCodeTooLarge, Message: fmt.Sprintf("document '%s' of %d bytes exceeds the limit of %d bytes of collection '%s'", resource, size, limits.MaxDocumentSize, collection)}
+			}
+		}
+	}
+	if !limits.tracksUsage() {
+		return nil
+	}
+
+	usage, err := d.usageLocked(collection)
+	if err != nil {
+		return err
+	}
+	documents, bytes := usage.documents, usage.bytes
+	for resource, b := range docs {
+		old, err := d.resourceSize(collection, resource)
+		if err != nil {
+			return err
+		}
+		if old < 0 {
+			documents, bytes = documents+1, bytes+int64(len(b))
+		} else {
+			bytes += int64(len(b)) - old
+		}
+	}
+
+	if limits.MaxDocuments > 0 && documents > limits.MaxDocuments {
+		return &DbError{Code: ErrCodeQuotaExceeded, Message: fmt.Sprintf("collection '%s' is limited to %d documents", collection, limits.MaxDocuments)}
+	}
+	// Shrinking documents is allowed even over the limit
+	if limits.MaxBytes > 0 && bytes > limits.MaxBytes && bytes > usage.bytes {
+		return &DbError{Code: ErrCodeQuotaExceeded, Message: fmt.Sprintf("collection '%s' would grow to %d bytes, over its limit of %d", collection, bytes, limits.MaxBytes)}
+	}
+	return nil
+}
+
+// usageLocked returns the usage of a collection. That of a collection
+// tracking its usage is counted on first use and kept; others are counted
+// on every call. The caller holds the collection mutex.
+func (d *Driver) usageLocked(collection string) (collectionUsage, error) {
+	tracked := d.Limits(collection).tracksUsage()
+	if usage, ok := d.knownUsage(collection); ok && tracked {
+		return usage, nil
+	}
+
+	usage := &collectionUsage{}
+	err := WalkResources(d.collectionDir(collection), func(_, _ string, entry fs.DirEntry) error {
+		fi, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return &DbError{Code: ErrCodeInternal, Message: "failed to stat file", Err: err}
+		}
+		usage.documents++
+		usage.bytes += fi.Size()
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return collectionUsage{}, walkError(err)
+	}
+	if !tracked {
+		return *usage, nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.usage == nil {
+		d.usage = make(map[string]*collectionUsage)
+	}
+	d.usage[collection] = usage
+	return *usage, nil
+}
+
+// trackWrite prepares accounting a write of size bytes to the file at path
+// in the usage of its collection, if it was counted, and returns the
+// function to call once the file is written. The caller holds the
+// collection mutex.
+func (d *Driver) trackWrite(collection, path string, size int64) (func(), error) {
+	if !d.counted(collection) {
+		return func() {}, nil
+	}
+
+	old, err := fileSize(path)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		if old < 0 {
+			d.account(collection, 1, size)
+		} else {
+			d.account(collection, 0, size-old)
+		}
+	}, nil
+}
+
+// counted reports whether the usage of a collection was counted
+func (d *Driver) counted(collection string) bool {
+	_, ok := d.knownUsage(collection)
+	return ok
+}
+
+// knownUsage returns the usage of a collection if it was counted
+func (d *Driver) knownUsage(collection string) (collectionUsage, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if usage, ok := d.usage[collection]; ok {
+		return *usage, true
+	}
+	return collectionUsage{}, false
+}
+
+// account adds to the usage of a collection, if it was counted
+func (d *Driver) account(collection string, documents int, bytes int64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if usage, ok := d.usage[collection]; ok {
+		usage.documents += documents
+		usage.bytes += bytes
+	}
+}
+
+// forgetUsage discards the usage of a dropped or renamed collection
+func (d *Driver) forgetUsage(collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.usage, collection)
+}
+
+// resourceSize returns the size of the file of a resource, or -1 when it
+// does not exist
+func (d *Driver) resourceSize(collection, resource string) (int64, error) {
+	path, err := d.resourcePath(collection, resource)
+	if err != nil {
+		return 0, err
+	}
+	return fileSize(path)
+}
+
+// fileSize returns the size of a file, or -1 when it does not exist
+func fileSize(path string) (int64, error) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return -1, nil
+		}
+		return 0, &DbError{Code: ErrCodeInternal, Message: "failed to stat file", Err: err}
+	}
+	return fi.Size(), nil
+}
+
+// removeResource deletes the file of a resource, and any copy left under
+// another layout so that none takes its place, and accounts for it in the
+// usage of its collection. Errors removing the file are returned as is.
+// The caller holds the collection mutex.
+func (d *Driver) removeResource(collection, resource string) error {
+	dir := d.collectionDir(collection)
+	layout, err := ReadLayout(dir)
+	if err != nil {
+		return err
+	}
+	path := layout.Locate(dir, resource)
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	d.account(collection, -1, -fi.Size())
+	return removeCopies(layout, dir, resource)
+}
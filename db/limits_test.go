@@ -0,0 +1,195 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jcelliott/lumber"
+)
+
+func TestCheckLimits(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+	size, err := d.resourceSize("bands", "opeth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		limits   Limits
+		resource string
+		doc      string
+		want     error
+	}{
+		{"unlimited", Limits{}, "camel", `{"name": "Camel"}`, nil},
+		{"too large", Limits{MaxDocumentSize: 8}, "camel", `{"name": "Camel"}`, ErrTooLarge},
+		{"document limit", Limits{MaxDocuments: 1}, "camel", "{}", ErrQuotaExceeded},
+		{"replacing at the document limit", Limits{MaxDocuments: 1}, "opeth", "{}", nil},
+		{"byte limit", Limits{MaxBytes: size + 1}, "camel", "{}", ErrQuotaExceeded},
+		{"growing over the byte limit", Limits{MaxBytes: size}, "opeth", `{"name": "Opeth", "year": 1990}`, ErrQuotaExceeded},
+		{"shrinking over the byte limit", Limits{MaxBytes: 1}, "opeth", "{}", nil},
+	}
+
+	for _, tt := range tests {
+		d.SetLimits("bands", tt.limits)
+		if err := d.checkLimits("bands", tt.resource, []byte(tt.doc)); !errors.Is(err, tt.want) && (err != nil || tt.want != nil) {
+			t.Errorf("%s: checkLimits() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestWriteLimits(t *testing.T) {
+	d := newTestDriver(t, nil)
+	d.SetLimits("bands", Limits{MaxDocuments: 2})
+
+	steps := []struct {
+		name string
+		op   func() error
+		want error
+	}{
+		{"first", func() error { return d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}) }, nil},
+		{"second", func() error { return d.Write("bands", "camel", map[string]interface{}{"name": "Camel"}) }, nil},
+		{"over the limit", func() error { return d.Write("bands", "yes", map[string]interface{}{"name": "Yes"}) }, ErrQuotaExceeded},
+		{"replacing", func() error { return d.Write("bands", "camel", map[string]interface{}{"name": "Camel", "year": 1971}) }, nil},
+		{"delete", func() error { return d.Delete("bands", "opeth") }, nil},
+		{"freed", func() error { return d.Write("bands", "yes", map[string]interface{}{"name": "Yes"}) }, nil},
+	}
+	for _, step := range steps {
+		if err := step.op(); !errors.Is(err, step.want) && (err != nil || step.want != nil) {
+			t.Errorf("%s: error = %v, want %v", step.name, err, step.want)
+		}
+	}
+
+	stats := d.GetStats("bands")
+	if stats["limits"] != (Limits{MaxDocuments: 2}) || stats["size_bytes"] == int64(0) {
+		t.Errorf("GetStats() = %v, want the limits and bytes used", stats)
+	}
+}
+
+func TestBatchWriteLimits(t *testing.T) {
+	d := newTestDriver(t, nil)
+	d.SetLimits("bands", Limits{MaxDocuments: 3})
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := make(map[string]interface{})
+	for _, name := range []string{"camel", "yes", "genesis"} {
+		batch[name] = map[string]interface{}{"name": name}
+	}
+	// Every item fits on its own, the batch does not
+	if err := d.BatchWrite("bands", batch); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("BatchWrite() error = %v, want ErrQuotaExceeded", err)
+	}
+	if all, _ := d.ReadAll("bands"); len(all) != 1 {
+		t.Errorf("BatchWrite() over the limit wrote %d documents, want none", len(all)-1)
+	}
+
+	delete(batch, "genesis")
+	batch["opeth"] = map[string]interface{}{"name": "Opeth", "year": 1990}
+	if err := d.BatchWrite("bands", batch); err != nil {
+		t.Fatalf("BatchWrite() within the limit error = %v", err)
+	}
+	if got := d.GetStats("bands")["record_count"]; got != 3 {
+		t.Errorf("record_count = %v, want 3", got)
+	}
+
+	d.SetLimits("bands", Limits{MaxDocumentSize: 64})
+	large := map[string]interface{}{
+		"rush":      map[string]interface{}{"name": "Rush"},
+		"marillion": map[string]interface{}{"name": fmt.Sprintf("%080d", 0)},
+	}
+	if err := d.BatchWrite("bands", large); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("BatchWrite() with a large document error = %v, want ErrTooLarge", err)
+	}
+	if err := d.Read("bands", "rush", &map[string]interface{}{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("BatchWrite() with a large document wrote another item: %v", err)
+	}
+}
+
+func TestUsageWithMovedCopies(t *testing.T) {
+	d := newTestDriver(t, nil)
+	for _, name := range []string{"opeth", "camel", "yes"} {
+		if err := d.Write("bands", name, map[string]interface{}{"name": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Shard by hand, leaving the flat files behind as a move cut short
+	// would, with a newer copy of two of them at their new place
+	dir := d.collectionDir("bands")
+	if err := d.writeLayout("bands", Layout{Levels: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"opeth", "camel"} {
+		if err := writeAtomic(Layout{Levels: 1}.Path(dir, name), []byte("{}\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Kept up to date from now on
+	d.SetLimits("bands", Limits{MaxBytes: 1 << 20})
+	if _, err := d.usageLocked("bands"); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name string
+		op   func() error
+	}{
+		{"write over two copies", func() error { return d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}) }},
+		{"write of an unmoved file", func() error { return d.Write("bands", "yes", map[string]interface{}{"name": "Yes", "year": 1968}) }},
+		{"delete of two copies", func() error { return d.Delete("bands", "camel") }},
+	}
+	for _, step := range steps {
+		if err := step.op(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		got, _ := d.knownUsage("bands")
+		d.forgetUsage("bands")
+		want, err := d.usageLocked("bands")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: usage = %+v, a new count gives %+v", step.name, got, want)
+		}
+	}
+
+	if err := d.Read("bands", "camel", &map[string]interface{}{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read() of a deleted resource with a stale copy error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLimitsCountOwnWrites(t *testing.T) {
+	d := newTestDriver(t, nil)
+	d.SetLimits("bands", Limits{MaxDocuments: 2})
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := New(d.Dir(), &Options{Logger: lumber.NewConsoleLogger(lumber.ERROR)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Write("bands", "camel", map[string]interface{}{"name": "Camel"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The driver is assumed to be the only writer of a limited collection
+	if got := d.GetStats("bands")["record_count"]; got != 1 {
+		t.Errorf("record_count after a write of another driver = %v, want the count of 1 kept", got)
+	}
+
+	// Setting the limits again counts the collection
+	d.SetLimits("bands", Limits{MaxDocuments: 2})
+	if got := d.GetStats("bands")["record_count"]; got != 2 {
+		t.Errorf("record_count after SetLimits() = %v, want 2", got)
+	}
+	if err := d.Write("bands", "yes", map[string]interface{}{"name": "Yes"}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Write() over the limit error = %v, want ErrQuotaExceeded", err)
+	}
+}
@@ -0,0 +1,76 @@
+package db
+
+import "context"
+
+// collectionLock is a mutex whose acquisition can be abandoned when a
+// context is done
+type collectionLock struct {
+	ch chan struct{}
+}
+
+func newCollectionLock() *collectionLock {
+	return &collectionLock{ch: make(chan struct{}, 1)}
+}
+
+func (l *collectionLock) Lock() {
+	l.ch <- struct{}{}
+}
+
+// LockContext waits for the lock until ctx is done
+func (l *collectionLock) LockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case l.ch <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *collectionLock) Unlock() {
+	<-l.ch
+}
+
+// lockCollection acquires the lock of a collection. A lock taken while a
+// rename moved it to another name is released and the current one taken
+// instead.
+func (d *Driver) lockCollection(collection string) *collectionLock {
+	for {
+		mutex := d.getOrCreateMutex(collection)
+		mutex.Lock()
+		if d.currentMutex(collection, mutex) {
+			return mutex
+		}
+		mutex.Unlock()
+	}
+}
+
+// lockContext acquires the lock of a collection, giving up with a
+// cancellation error when ctx is done
+func (d *Driver) lockContext(ctx context.Context, collection string) (*collectionLock, error) {
+	for {
+		mutex := d.getOrCreateMutex(collection)
+		if err := mutex.LockContext(ctx); err != nil {
+			return nil, canceled(err)
+		}
+		if d.currentMutex(collection, mutex) {
+			return mutex, nil
+		}
+		mutex.Unlock()
+	}
+}
+
+// currentMutex reports whether mutex is still the lock of a collection
+func (d *Driver) currentMutex(collection string, mutex *collectionLock) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.mutexes[collection] == mutex
+}
+
+// canceled wraps the error of a done context
+func canceled(err error) *DbError {
+	return &DbError{Code: ErrCodeCanceled, Message: "operation canceled", Err: err}
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCollectionLockContext(t *testing.T) {
+	l := newCollectionLock()
+	if err := l.LockContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.LockContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("LockContext() on a held lock error = %v, want DeadlineExceeded", err)
+	}
+
+	// A done context fails even when the lock is free
+	l.Unlock()
+	if err := l.LockContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("LockContext() with a done context error = %v, want DeadlineExceeded", err)
+	}
+
+	got := make(chan error, 1)
+	l.Lock()
+	go func() { got <- l.LockContext(context.Background()) }()
+	l.Unlock()
+	if err := <-got; err != nil {
+		t.Errorf("LockContext() after Unlock error = %v", err)
+	}
+}
+
+func TestContextMethodsGiveUp(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+	st, err := ParseStatement(`name = "Opeth"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mutex := d.getOrCreateMutex("bands")
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	doc := map[string]interface{}{"name": "Opeth"}
+	ops := map[string]func(context.Context) error{
+		"WriteContext":  func(ctx context.Context) error { return d.WriteContext(ctx, "bands", "opeth", doc) },
+		"UpdateContext": func(ctx context.Context) error { return d.UpdateContext(ctx, "bands", "opeth", doc) },
+		"DeleteContext": func(ctx context.Context) error { return d.DeleteContext(ctx, "bands", "opeth") },
+		"BatchWriteContext": func(ctx context.Context) error {
+			return d.BatchWriteContext(ctx, "bands", map[string]interface{}{"opeth": doc})
+		},
+		"SearchContext": func(ctx context.Context) error {
+			_, err := d.SearchContext(ctx, "bands", "opeth")
+			return err
+		},
+	}
+	for name, op := range ops {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := op(ctx)
+		cancel()
+		if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s() on a locked collection error = %v, want ErrCanceled", name, err)
+		}
+	}
+
+	// Reads and scans do not wait for the lock, but stop once ctx is done
+	var got map[string]interface{}
+	reads := map[string]func(context.Context) error{
+		"ReadContext": func(ctx context.Context) error { return d.ReadContext(ctx, "bands", "opeth", &got) },
+		"FindContext": func(ctx context.Context) error {
+			_, err := d.FindContext(ctx, "bands", st)
+			return err
+		},
+		"ReadAllContext": func(ctx context.Context) error {
+			_, err := d.ReadAllContext(ctx, "bands")
+			return err
+		},
+	}
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for name, read := range reads {
+		if err := read(context.Background()); err != nil {
+			t.Errorf("%s() on a locked collection error = %v", name, err)
+		}
+		if err := read(canceledCtx); !errors.Is(err, ErrCanceled) {
+			t.Errorf("%s() with a canceled context error = %v, want ErrCanceled", name, err)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package db
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// versionFile stores the schema version of a collection
+const versionFile = ".version"
+
+// MigrationFunc upgrades a document to the schema version it is
+// registered for. It may modify and return doc.
+type MigrationFunc func(doc map[string]interface{}) (map[string]interface{}, error)
+
+type migration struct {
+	version int
+	fn      MigrationFunc
+}
+
+var registry = struct {
+	sync.Mutex
+	migrations map[string][]migration // Sorted by version
+}{migrations: make(map[string][]migration)}
+
+// RegisterMigration registers the migration bringing the documents of a
+// collection to version. Versions start at 1 and need not be contiguous.
+// It is meant to be called from init functions and panics on a
+// non-positive or duplicate version.
+func RegisterMigration(collection string, version int, fn MigrationFunc) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if version < 1 {
+		panic(fmt.Sprintf("db: migration version %d for '%s' is not positive", version, collection))
+	}
+	if fn == nil {
+		panic(fmt.Sprintf("db: migration %d for '%s' is nil", version, collection))
+	}
+
+	list := registry.migrations[collection]
+	for _, m := range list {
+		if m.version == version {
+			panic(fmt.Sprintf("db: migration %d for '%s' registered twice", version, collection))
+		}
+	}
+
+	list = append(list, migration{version, fn})
+	sort.Slice(list, func(i, j int) bool { return list[i].version < list[j].version })
+	registry.migrations[collection] = list
+}
+
+// migrationsFor returns the migrations of a collection above version
+func migrationsFor(collection string, version int) []migration {
+	registry.Lock()
+	defer registry.Unlock()
+
+	var pending []migration
+	for _, m := range registry.migrations[collection] {
+		if m.version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// latestVersion returns the highest registered version of a collection
+func latestVersion(collection string) int {
+	registry.Lock()
+	defer registry.Unlock()
+
+	list := registry.migrations[collection]
+	if len(list) == 0 {
+		return 0
+	}
+	return list[len(list)-1].version
+}
+
+// migratedCollections returns the collections with registered migrations
+func migratedCollections() []string {
+	registry.Lock()
+	defer registry.Unlock()
+
+	names := make([]string, 0, len(registry.migrations))
+	for name := range registry.migrations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MigrateOptions configures Migrate and MigrateCollection
+type MigrateOptions struct {
+	// DryRun applies and validates the migrations without storing the
+	// documents or the new schema version
+	DryRun bool
+	// Progress, if set, is called after each document
+	Progress func(MigrationProgress)
+}
+
+// MigrationProgress reports how far the migration of a collection got
+type MigrationProgress struct {
+	Collection string
+	Done       int
+	Total      int
+}
+
+// MigrationResult summarizes the migration of a collection
+type MigrationResult struct {
+	Collection string
+	From       int      // Schema version before the migration
+	To         int      // Schema version after the migration
+	Documents  int      // Number of documents migrated
+	Changed    []string // Resources whose content changed
+	DryRun     bool
+}
+
+// SchemaVersion returns the schema version stored for a collection, 0 if
+// it was never migrated
+func (d *Driver) SchemaVersion(collection string) (_ int, err error) {
+	defer d.done("schema_version", collection, "", time.Now(), &err)
+	if err := checkCollection(collection); err != nil {
+		return 0, err
+	}
+	return d.readVersion(collection)
+}
+
+// Migrate runs the pending migrations of every collection that has
+// registered migrations
+func (d *Driver) Migrate(opts *MigrateOptions) ([]MigrationResult, error) {
+	var results []MigrationResult
+	for _, collection := range migratedCollections() {
+		result, err := d.MigrateCollection(collection, opts)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+// MigrateCollection applies the pending migrations of a collection to
+// every document, in version order, under the collection lock. Migrated
+// documents are validated but no hooks run. The new schema version is
+// stored once every document is migrated, so a run that fails part-way is
+// retried from the first document and migrations should be idempotent.
+func (d *Driver) MigrateCollection(collection string, opts *MigrateOptions) (_ *MigrationResult, err error) {
+	start := time.Now()
+	defer d.done("migrate", collection, "", start, &err)
+	if err := checkCollection(collection); err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &MigrateOptions{}
+	}
+	if !opts.DryRun {
+		end, err := d.BeginWrite()
+		if err != nil {
+			return nil, err
+		}
+		defer end()
+	}
+
+	mutex := d.lockCollection(collection)
+	defer mutex.Unlock()
+
+	version, err := d.readVersion(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MigrationResult{Collection: collection, From: version, To: version, DryRun: opts.DryRun}
+	if latest := latestVersion(collection); version > latest {
+		return nil, &DbError{Code: ErrCodeInternal, Message: fmt.Sprintf("schema version %d of collection '%s' is newer than the latest migration %d", version, collection, latest)}
+	}
+
+	pending := migrationsFor(collection, version)
+	if len(pending) == 0 {
+		return result, nil
+	}
+	result.To = pending[len(pending)-1].version
+
+	resources, err := d.listResources(collection)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(resources)
+
+	for i, resource := range resources {
+		doc, err := d.readDocument(collection, resource)
+		if err != nil {
+			return nil, err
+		}
+
+		migrated := cloneDocument(doc)
+		for _, m := range pending {
+			if migrated, err = m.fn(migrated); err != nil {
+				return nil, &DbError{Code: ErrCodeInternal, Message: fmt.Sprintf("migration %d failed for resource '%s' in collection '%s'", m.version, resource, collection), Err: err}
+			}
+			if migrated == nil {
+				return nil, &DbError{Code: ErrCodeInternal, Message: fmt.Sprintf("migration %d returned no document for resource '%s' in collection '%s'", m.version, resource, collection)}
+			}
+		}
+
+		if err := d.validate(collection, migrated); err != nil {
+			return nil, err
+		}
+		if err := d.checkUnique(collection, resource, migrated); err != nil {
+			return nil, err
+		}
+
+		result.Documents++
+		if !reflect.DeepEqual(doc, migrated) {
+			result.Changed = append(result.Changed, resource)
+			if !opts.DryRun {
+				raw, err := d.writeFile(collection, resource, migrated)
+				if err != nil {
+					return nil, err
+				}
+				d.committed(collection, resource, raw)
+			}
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(MigrationProgress{Collection: collection, Done: i + 1, Total: len(resources)})
+		}
+	}
+
+	if !opts.DryRun {
+		if err := d.writeVersion(collection, result.To); err != nil {
+			return nil, err
+		}
+		d.logEvent(slog.LevelInfo, "Migrated collection", slog.String("collection", collection),
+			slog.Int("from", result.From), slog.Int("to", result.To),
+			slog.Int("changed", len(result.Changed)), slog.Int("documents", result.Documents),
+			slog.Duration("duration", time.Since(start)))
+	}
+
+	d.updateStats(collection, "migrate", start)
+	return result, nil
+}
+
+func (d *Driver) readVersion(collection string) (int, error) {
+	b, err := os.ReadFile(filepath.Join(d.collectionDir(collection), versionFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, &DbError{Code: ErrCodeInternal, Message: "failed to read schema version", Err: err}
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, &DbError{Code: ErrCodeInternal, Message: "invalid schema version", Err: err}
+	}
+	return version, nil
+}
+
+func (d *Driver) writeVersion(collection string, version int) error {
+	b := []byte(strconv.Itoa(version) + "\n")
+	if err := d.writeRaw(collection, versionFile, b); err != nil {
+		return err
+	}
+	d.replicate(Change{Op: OpFile, Collection: collection, Resource: versionFile, Data: b})
+	return nil
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// The registry is global, so each test registers its migrations once per
+// process, whatever -count
+var migrationsRegistered = make(map[string]*sync.Once)
+
+func registerOnce(collection string, register func()) {
+	once, ok := migrationsRegistered[collection]
+	if !ok {
+		once = &sync.Once{}
+		migrationsRegistered[collection] = once
+	}
+	once.Do(register)
+}
+
+func TestRegisterMigrationPanics(t *testing.T) {
+	registerOnce("migrate_panics", func() {
+		RegisterMigration("migrate_panics", 1, func(doc map[string]interface{}) (map[string]interface{}, error) { return doc, nil })
+	})
+
+	tests := []struct {
+		name    string
+		version int
+		fn      MigrationFunc
+	}{
+		{"zero version", 0, func(doc map[string]interface{}) (map[string]interface{}, error) { return doc, nil }},
+		{"duplicate version", 1, func(doc map[string]interface{}) (map[string]interface{}, error) { return doc, nil }},
+		{"nil function", 2, nil},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: RegisterMigration() did not panic", tt.name)
+				}
+			}()
+			RegisterMigration("migrate_panics", tt.version, tt.fn)
+		}()
+	}
+}
+
+func TestMigrateCollection(t *testing.T) {
+	const collection = "migrate_bands"
+	// Registered out of order, applied by version
+	registerOnce(collection, func() {
+		RegisterMigration(collection, 3, func(doc map[string]interface{}) (map[string]interface{}, error) {
+			doc["origin"] = doc["country"]
+			delete(doc, "country")
+			return doc, nil
+		})
+		RegisterMigration(collection, 1, func(doc map[string]interface{}) (map[string]interface{}, error) {
+			if _, ok := doc["country"]; !ok {
+				doc["country"] = "unknown"
+			}
+			return doc, nil
+		})
+	})
+
+	d := newTestDriver(t, nil)
+	docs := map[string]interface{}{
+		"opeth": map[string]interface{}{"name": "Opeth", "country": "Sweden"},
+		"camel": map[string]interface{}{"name": "Camel"},
+	}
+	for resource, doc := range docs {
+		if err := d.Write(collection, resource, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var progress []MigrationProgress
+	result, err := d.MigrateCollection(collection, &MigrateOptions{DryRun: true, Progress: func(p MigrationProgress) {
+		progress = append(progress, p)
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &MigrationResult{Collection: collection, From: 0, To: 3, Documents: 2, Changed: []string{"camel", "opeth"}, DryRun: true}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("MigrateCollection(dry run) = %+v, want %+v", result, want)
+	}
+	if len(progress) != 2 || progress[1] != (MigrationProgress{Collection: collection, Done: 2, Total: 2}) {
+		t.Errorf("progress = %+v", progress)
+	}
+	if version, _ := d.SchemaVersion(collection); version != 0 {
+		t.Errorf("dry run stored schema version %d", version)
+	}
+
+	if _, err := d.MigrateCollection(collection, nil); err != nil {
+		t.Fatal(err)
+	}
+	wantDocs := map[string]map[string]interface{}{
+		"opeth": {"name": "Opeth", "origin": "Sweden"},
+		"camel": {"name": "Camel", "origin": "unknown"},
+	}
+	for resource, want := range wantDocs {
+		var doc map[string]interface{}
+		if err := d.Read(collection, resource, &doc); err != nil || !reflect.DeepEqual(doc, want) {
+			t.Errorf("Read(%s) = %v, %v; want %v", resource, doc, err, want)
+		}
+	}
+	if version, _ := d.SchemaVersion(collection); version != 3 {
+		t.Errorf("SchemaVersion() = %d, want 3", version)
+	}
+
+	// Nothing is pending any more
+	result, err = d.MigrateCollection(collection, nil)
+	if err != nil || result.From != 3 || result.To != 3 || result.Documents != 0 {
+		t.Errorf("MigrateCollection() again = %+v, %v; want nothing done", result, err)
+	}
+}
+
+func TestMigrateCollectionFails(t *testing.T) {
+	const collection = "migrate_failing"
+	registerOnce(collection, func() {
+		RegisterMigration(collection, 1, func(doc map[string]interface{}) (map[string]interface{}, error) {
+			if doc["name"] == "broken" {
+				return nil, errors.New("cannot migrate")
+			}
+			doc["migrated"] = true
+			return doc, nil
+		})
+	})
+
+	d := newTestDriver(t, nil)
+	for _, name := range []string{"a", "broken"} {
+		if err := d.Write(collection, name, map[string]interface{}{"name": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := d.MigrateCollection(collection, nil); !errors.Is(err, ErrInternal) {
+		t.Errorf("MigrateCollection() error = %v, want ErrInternal", err)
+	}
+	// Retried from the first document on the next run
+	if version, _ := d.SchemaVersion(collection); version != 0 {
+		t.Errorf("failed migration stored schema version %d", version)
+	}
+
+	if err := d.writeVersion(collection, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.MigrateCollection(collection, nil); !errors.Is(err, ErrInternal) {
+		t.Errorf("MigrateCollection() of a newer schema error = %v, want ErrInternal", err)
+	}
+}
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// writeGate admits mutating operations unless the database is read-only
+// or in maintenance, and counts those in flight so that maintenance can
+// wait for them
+type writeGate struct {
+	mu          sync.Mutex
+	readOnly    bool
+	maintenance bool
+	reason      string
+	active      int
+	idle        chan struct{} // Closed once active drops to zero, while maintenance waits
+}
+
+// BeginWrite admits a mutating operation and returns the function ending
+// it. It fails with ErrCodeReadOnly on a read-only database and with the
+// retryable ErrCodeUnavailable during maintenance. The driver calls it
+// itself; code writing to the database directory by other means calls it
+// to honor both modes.
+func (d *Driver) BeginWrite() (func(), error) {
+	g := &d.gate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.readOnly {
+		return nil, &DbError{Code: ErrCodeReadOnly, Message: "database is read-only"}
+	}
+	if g.maintenance {
+		msg := "database is in maintenance"
+		if g.reason != "" {
+			msg += ": " + g.reason
+		}
+		return nil, &DbError{Code: ErrCodeUnavailable, Message: msg}
+	}
+
+	g.active++
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.active--
+		if g.active == 0 && g.idle != nil {
+			close(g.idle)
+			g.idle = nil
+		}
+	}, nil
+}
+
+// ReadOnly reports whether the database was opened read-only
+func (d *Driver) ReadOnly() bool {
+	d.gate.mu.Lock()
+	defer d.gate.mu.Unlock()
+	return d.gate.readOnly
+}
+
+// StartMaintenance rejects every further mutating operation with a
+// retryable ErrCodeUnavailable error and waits for those in flight to
+// finish, so that the files can be backed up or migrated by other means.
+// Reads continue. If ctx is done first, maintenance ends again and the
+// context error is returned.
+func (d *Driver) StartMaintenance(ctx context.Context, reason string) error {
+	g := &d.gate
+	g.mu.Lock()
+	if g.readOnly {
+		g.mu.Unlock()
+		return &DbError{Code: ErrCodeReadOnly, Message: "database is read-only"}
+	}
+	g.maintenance = true
+	g.reason = reason
+	if g.active == 0 {
+		g.mu.Unlock()
+		d.logEvent(slog.LevelInfo, "Maintenance started", slog.String("reason", reason))
+		return nil
+	}
+	if g.idle == nil {
+		g.idle = make(chan struct{})
+	}
+	idle := g.idle
+	g.mu.Unlock()
+
+	select {
+	case <-idle:
+		d.logEvent(slog.LevelInfo, "Maintenance started", slog.String("reason", reason))
+		return nil
+	case <-ctx.Done():
+		d.EndMaintenance()
+		return canceled(ctx.Err())
+	}
+}
+
+// EndMaintenance admits mutating operations again
+func (d *Driver) EndMaintenance() {
+	g := &d.gate
+	g.mu.Lock()
+	was := g.maintenance
+	g.maintenance = false
+	g.reason = ""
+	g.mu.Unlock()
+
+	if was {
+		d.logEvent(slog.LevelInfo, "Maintenance ended")
+	}
+}
+
+// Maintenance reports whether the database is in maintenance, and why
+func (d *Driver) Maintenance() (reason string, ok bool) {
+	d.gate.mu.Lock()
+	defer d.gate.mu.Unlock()
+	return d.gate.reason, d.gate.maintenance
+}
+
+// MaintenanceStatus is the state reported by MaintenanceHandler
+type MaintenanceStatus struct {
+	ReadOnly    bool   `json:"readOnly"`
+	Maintenance bool   `json:"maintenance"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// MaintenanceHandler reports the modes of the database as JSON on GET,
+// starts maintenance on POST with the "reason" form value, replying once
+// the writes in flight are done, and ends it on DELETE
+func (d *Driver) MaintenanceHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if err := d.StartMaintenance(r.Context(), r.FormValue("reason")); err != nil {
+				http.Error(w, err.Error(), HTTPStatus(err))
+				return
+			}
+		case http.MethodDelete:
+			d.EndMaintenance()
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		reason, on := d.Maintenance()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(MaintenanceStatus{ReadOnly: d.ReadOnly(), Maintenance: on, Reason: reason})
+	})
+}
+
+// IsRetryable reports whether an operation failed only for the time being,
+// as during maintenance, and may succeed when repeated later
+func IsRetryable(err error) bool {
+	return ErrorCode(err) == ErrCodeUnavailable
+}
+
+// checkOpenOptions rejects options that would write to a read-only
+// database
+func checkOpenOptions(opts Options) error {
+	if !opts.ReadOnly {
+		return nil
+	}
+	if opts.Replication {
+		return &DbError{Code: ErrCodeInvalidInput, Message: "a read-only database cannot record replication changes"}
+	}
+	if opts.AutoMigrate {
+		return &DbError{Code: ErrCodeInvalidInput, Message: "a read-only database cannot be migrated"}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadOnly(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ro := newTestDriver(t, &Options{ReadOnly: true})
+	ro.dir = d.dir
+	var doc map[string]interface{}
+	if err := ro.Read("bands", "opeth", &doc); err != nil {
+		t.Errorf("Read() on a read-only database error = %v", err)
+	}
+
+	writes := map[string]func() error{
+		"Write":            func() error { return ro.Write("bands", "camel", map[string]interface{}{}) },
+		"Delete":           func() error { return ro.Delete("bands", "opeth") },
+		"DropCollection":   func() error { return ro.DropCollection("bands") },
+		"StartMaintenance": func() error { return ro.StartMaintenance(context.Background(), "") },
+	}
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s() error = %v, want ErrReadOnly", name, err)
+		}
+	}
+
+	for _, opts := range []Options{{ReadOnly: true, Replication: true}, {ReadOnly: true, AutoMigrate: true}} {
+		if _, err := New(t.TempDir(), &opts); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("New(%+v) error = %v, want ErrInvalidInput", opts, err)
+		}
+	}
+}
+
+func TestMaintenanceWaitsForWrites(t *testing.T) {
+	d := newTestDriver(t, nil)
+	end, err := d.BeginWrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan error, 1)
+	go func() { started <- d.StartMaintenance(context.Background(), "backup") }()
+	select {
+	case err := <-started:
+		t.Fatalf("StartMaintenance() returned %v with a write in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	err = d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"})
+	if !errors.Is(err, ErrUnavailable) || !IsRetryable(err) || !strings.Contains(err.Error(), "backup") {
+		t.Errorf("Write() during maintenance error = %v, want a retryable ErrUnavailable", err)
+	}
+
+	end()
+	if err := <-started; err != nil {
+		t.Fatalf("StartMaintenance() error = %v", err)
+	}
+	if reason, ok := d.Maintenance(); !ok || reason != "backup" {
+		t.Errorf("Maintenance() = %q, %v", reason, ok)
+	}
+
+	d.EndMaintenance()
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Errorf("Write() after maintenance error = %v", err)
+	}
+}
+
+func TestStartMaintenanceCanceled(t *testing.T) {
+	d := newTestDriver(t, nil)
+	end, err := d.BeginWrite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer end()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := d.StartMaintenance(ctx, ""); !errors.Is(err, ErrCanceled) {
+		t.Errorf("StartMaintenance() error = %v, want ErrCanceled", err)
+	}
+	if _, ok := d.Maintenance(); ok {
+		t.Error("maintenance still on after StartMaintenance gave up")
+	}
+}
+
+func TestMaintenanceHandler(t *testing.T) {
+	d := newTestDriver(t, nil)
+	srv := httptest.NewServer(RequireToken("secret", d.MaintenanceHandler()))
+	defer srv.Close()
+
+	do := func(method, token string, form url.Values) (int, MaintenanceStatus) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var status MaintenanceStatus
+		json.NewDecoder(resp.Body).Decode(&status)
+		return resp.StatusCode, status
+	}
+
+	if code, _ := do(http.MethodPost, "", url.Values{"reason": {"x"}}); code != http.StatusUnauthorized {
+		t.Errorf("POST without a token = %d, want 401", code)
+	}
+	if _, ok := d.Maintenance(); ok {
+		t.Fatal("maintenance started without a token")
+	}
+
+	steps := []struct {
+		method string
+		want   MaintenanceStatus
+	}{
+		{http.MethodGet, MaintenanceStatus{}},
+		{http.MethodPost, MaintenanceStatus{Maintenance: true, Reason: "backup"}},
+		{http.MethodGet, MaintenanceStatus{Maintenance: true, Reason: "backup"}},
+		{http.MethodDelete, MaintenanceStatus{}},
+	}
+	for _, step := range steps {
+		code, status := do(step.method, "secret", url.Values{"reason": {"backup"}})
+		if code != http.StatusOK || status != step.want {
+			t.Errorf("%s = %d %+v, want 200 %+v", step.method, code, status, step.want)
+		}
+	}
+	if code, _ := do(http.MethodPut, "secret", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT = %d, want 405", code)
+	}
+}
+
+func TestFollowerWaitsOutMaintenance(t *testing.T) {
+	leader := newTestDriver(t, &Options{Replication: true})
+	srv := httptest.NewServer(leader.ChangeLog().Handler())
+	defer srv.Close()
+
+	follower, err := NewDirFollower(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := follower.Driver().StartMaintenance(context.Background(), "backup"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Follow(ctx, srv.URL, follower, nil) }()
+
+	if err := leader.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("Follow() stopped during maintenance: %v", err)
+	default:
+	}
+	if follower.Offset() != 0 {
+		t.Fatalf("follower applied change %d during maintenance", follower.Offset())
+	}
+
+	follower.Driver().EndMaintenance()
+	waitFor(t, "the follower", func() bool { return follower.Offset() == leader.ChangeLog().Seq() })
+	cancel()
+	if err := <-done; !errors.Is(err, ErrCanceled) {
+		t.Errorf("Follow() error = %v, want ErrCanceled", err)
+	}
+}
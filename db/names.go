@@ -0,0 +1,144 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Longest key allowed for a name, leaving room for the ".json.tmp"
+// suffix within the usual 255-byte file name limit
+const maxKeyLength = 240
+
+// EncodeName escapes an arbitrary collection or resource name into a key
+// that is safe to use as a single file name. Lower-case letters, digits,
+// '_', '-' and non-leading '.' are kept as is; every other byte is
+// written as %XX. Upper-case letters are escaped too, so names differing
+// only in case get keys that differ on case-insensitive filesystems as
+// well. Names that are empty, not valid UTF-8, contain a path separator
+// or a NUL byte, or are "." or ".." are rejected with ErrCodeInvalidInput.
+func EncodeName(name string) (string, error) {
+	if err := validName(name); err != nil {
+		return "", err
+	}
+
+	key := escapeName(name)
+	if len(key) > maxKeyLength {
+		return "", &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("name '%s' is too long", name)}
+	}
+	return key, nil
+}
+
+// DecodeName reverses EncodeName
+func DecodeName(key string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c != '%' {
+			if !safeByte(c, i) {
+				return "", &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("invalid key '%s'", key)}
+			}
+			b.WriteByte(c)
+			continue
+		}
+
+		if i+2 >= len(key) || !isUpperHex(key[i+1]) || !isUpperHex(key[i+2]) {
+			return "", &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("invalid escape in key '%s'", key)}
+		}
+		b.WriteByte(unhex(key[i+1])<<4 | unhex(key[i+2]))
+		i += 2
+	}
+
+	name := b.String()
+	if err := validName(name); err != nil {
+		return "", err
+	}
+	if escapeName(name) != key {
+		return "", &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("key '%s' is not canonical", key)}
+	}
+	return name, nil
+}
+
+func validName(name string) error {
+	switch {
+	case name == "":
+		return &DbError{Code: ErrCodeInvalidInput, Message: "name cannot be empty"}
+	case name == "." || name == "..":
+		return &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("name '%s' is reserved", name)}
+	case !utf8.ValidString(name):
+		return &DbError{Code: ErrCodeInvalidInput, Message: "name is not valid UTF-8"}
+	case strings.ContainsAny(name, "/\\\x00"):
+		return &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("name %q contains a path separator or NUL byte", name)}
+	}
+	return nil
+}
+
+// escapeName escapes a name that already passed validName
+func escapeName(name string) string {
+	const hex = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if safeByte(c, i) {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0x0f])
+		}
+	}
+	return b.String()
+}
+
+// safeByte reports whether c may appear unescaped at position i of a key.
+// A leading dot is escaped so keys never clash with the driver's own
+// hidden files.
+func safeByte(c byte, i int) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		return true
+	case c == '_' || c == '-':
+		return true
+	case c == '.':
+		return i > 0
+	}
+	return false
+}
+
+func isUpperHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	if c <= '9' {
+		return c - '0'
+	}
+	return c - 'A' + 10
+}
+
+// checkCollection validates a collection name received by a public method
+func checkCollection(collection string) error {
+	if collection == "" {
+		return &DbError{Code: ErrCodeInvalidInput, Message: "collection cannot be empty"}
+	}
+	if _, err := EncodeName(collection); err != nil {
+		return &DbError{Code: ErrCodeInvalidInput, Message: "invalid collection name", Err: err}
+	}
+	return nil
+}
+
+// checkNames validates the collection and resource names received by a
+// public method
+func checkNames(collection, resource string) error {
+	if err := checkCollection(collection); err != nil {
+		return err
+	}
+	if resource == "" {
+		return &DbError{Code: ErrCodeInvalidInput, Message: "resource cannot be empty"}
+	}
+	if _, err := EncodeName(resource); err != nil {
+		return &DbError{Code: ErrCodeInvalidInput, Message: "invalid resource name", Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestEncodeNameRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"dot", "."},
+		{"dot dot", ".."},
+		{"parent traversal", "../etc"},
+		{"nested traversal", "bands/../../etc"},
+		{"absolute path", "/etc/passwd"},
+		{"windows absolute path", `C:\Windows`},
+		{"backslash traversal", `..\secrets`},
+		{"NUL byte", "opeth\x00.json"},
+		{"invalid UTF-8", "\xff\xfe"},
+		{"too long", strings.Repeat("%", maxKeyLength)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := EncodeName(tt.input)
+			if err == nil {
+				t.Fatalf("EncodeName(%q) = %q, want an error", tt.input, key)
+			}
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("EncodeName(%q) error = %v, want ErrInvalidInput", tt.input, err)
+			}
+		})
+	}
+}
+
+func TestEncodeName(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"opeth", "opeth"},
+		{"pink_floyd-1", "pink_floyd-1"},
+		{"Opeth", "%4Fpeth"},
+		{"a.b", "a.b"},
+		{".hidden", "%2Ehidden"},
+		{"...", "%2E.."},
+		{"ac dc", "ac%20dc"},
+		{"motörhead", "mot%C3%B6rhead"},
+		{"50%", "50%25"},
+	}
+
+	for _, tt := range tests {
+		got, err := EncodeName(tt.input)
+		if err != nil {
+			t.Errorf("EncodeName(%q) error = %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("EncodeName(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeNameRejects(t *testing.T) {
+	for _, key := range []string{
+		"",
+		".",
+		"..",
+		".hidden",   // Leading dots are always escaped
+		"a%2fb",     // Lower-case hex is not canonical
+		"a%2",       // Truncated escape
+		"a%GG",      // Not hex
+		"a b",       // Unescaped unsafe byte
+		"Opeth",     // Unescaped upper-case letter
+		"%61",       // Escaped safe byte is not canonical
+		"a%2Fb",     // Decodes to a path separator
+		"a%00b",     // Decodes to NUL
+		"%2E%2E",    // Decodes to ".."
+		"%FF%FE",    // Decodes to invalid UTF-8
+		"a%5C..%5C", // Decodes to a backslash
+	} {
+		if name, err := DecodeName(key); err == nil {
+			t.Errorf("DecodeName(%q) = %q, want an error", key, name)
+		}
+	}
+}
+
+func TestCheckNames(t *testing.T) {
+	tests := []struct {
+		collection, resource string
+		ok                   bool
+	}{
+		{"bands", "opeth", true},
+		{"bands", "", false},
+		{"", "opeth", false},
+		{"..", "opeth", false},
+		{"bands", "../albums/opeth", false},
+		{"bands/../albums", "opeth", false},
+	}
+
+	for _, tt := range tests {
+		err := checkNames(tt.collection, tt.resource)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkNames(%q, %q) error = %v, want ok %v", tt.collection, tt.resource, err, tt.ok)
+		}
+	}
+}
+
+func FuzzEncodeName(f *testing.F) {
+	for _, seed := range []string{"opeth", "..", "../etc/passwd", "/abs", `a\b`, ".x", "a\x00b", "Motörhead", "%2E", "", "Opeth", "AC/DC"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		key, err := EncodeName(name)
+		if err != nil {
+			return
+		}
+
+		if key == "" || key == "." || key == ".." || strings.HasPrefix(key, ".") {
+			t.Fatalf("EncodeName(%q) = %q, a reserved or hidden name", name, key)
+		}
+		if strings.ContainsAny(key, "/\\\x00") {
+			t.Fatalf("EncodeName(%q) = %q, contains a separator or NUL", name, key)
+		}
+		// Dots are kept inside names but a key made only of them would
+		// traverse; the leading dot is escaped so that can never happen
+		if strings.Trim(key, ".") == "" {
+			t.Fatalf("EncodeName(%q) = %q, made only of dots", name, key)
+		}
+		if !utf8.ValidString(key) {
+			t.Fatalf("EncodeName(%q) = %q, not valid UTF-8", name, key)
+		}
+
+		decoded, err := DecodeName(key)
+		if err != nil {
+			t.Fatalf("DecodeName(EncodeName(%q)) error = %v", name, err)
+		}
+		if decoded != name {
+			t.Fatalf("DecodeName(EncodeName(%q)) = %q", name, decoded)
+		}
+
+		// Distinct names must not share a file on a case-insensitive
+		// filesystem
+		for _, other := range []string{strings.ToUpper(name), strings.ToLower(name)} {
+			if otherKey, err := EncodeName(other); err == nil && other != name && strings.EqualFold(otherKey, key) {
+				t.Fatalf("EncodeName(%q) = %q and EncodeName(%q) = %q differ only in case", name, key, other, otherKey)
+			}
+		}
+	})
+}
@@ -0,0 +1,341 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// patchOperation is a single RFC 6902 operation
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// PatchJSON applies an RFC 6902 JSON Patch document to a resource. All
+// operations are applied under the collection lock and the resource is
+// only stored when every operation succeeds.
+func (d *Driver) PatchJSON(collection, resource string, patch []byte) (err error) {
+	start := time.Now()
+	defer d.done("patch", collection, resource, start, &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+	if err := checkNames(collection, resource); err != nil {
+		return err
+	}
+
+	var ops []patchOperation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return &DbError{Code: ErrCodeInvalidInput, Message: "invalid JSON patch", Err: err}
+	}
+
+	mutex := d.lockCollection(collection)
+	event, err := d.updateLocked(collection, resource, func(data map[string]interface{}) (map[string]interface{}, error) {
+		var doc interface{} = data
+		for i, op := range ops {
+			var err error
+			if doc, err = applyPatchOperation(doc, op); err != nil {
+				return nil, &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("patch operation %d (%s %s) failed", i, op.Op, op.Path), Err: err}
+			}
+		}
+		return asDocument(doc)
+	})
+	mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	d.runAfterHooks(AfterWrite, event)
+	d.runAfterHooks(AfterUpdate, event)
+
+	// Update stats
+	d.updateStats(collection, "patch", start)
+	return nil
+}
+
+// MergePatch applies an RFC 7396 JSON Merge Patch document to a resource
+// under the collection lock
+func (d *Driver) MergePatch(collection, resource string, patch []byte) (err error) {
+	start := time.Now()
+	defer d.done("merge_patch", collection, resource, start, &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+	if err := checkNames(collection, resource); err != nil {
+		return err
+	}
+
+	var p interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return &DbError{Code: ErrCodeInvalidInput, Message: "invalid JSON merge patch", Err: err}
+	}
+
+	mutex := d.lockCollection(collection)
+	event, err := d.updateLocked(collection, resource, func(data map[string]interface{}) (map[string]interface{}, error) {
+		return asDocument(mergePatch(data, p))
+	})
+	mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	d.runAfterHooks(AfterWrite, event)
+	d.runAfterHooks(AfterUpdate, event)
+
+	// Update stats
+	d.updateStats(collection, "merge", start)
+	return nil
+}
+
+func asDocument(v interface{}) (map[string]interface{}, error) {
+	doc, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, &DbError{Code: ErrCodeInvalidInput, Message: "patched document is not a JSON object"}
+	}
+	return doc, nil
+}
+
+// mergePatch implements the MergePatch algorithm of RFC 7396
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+		} else {
+			t[key] = mergePatch(t[key], value)
+		}
+	}
+	return t
+}
+
+func applyPatchOperation(doc interface{}, op patchOperation) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("missing value")
+		}
+		var value interface{}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, err
+		}
+
+		switch op.Op {
+		case "add":
+			return pointerAdd(doc, path, value)
+		case "replace":
+			return pointerReplace(doc, path, value)
+		}
+
+		current, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("test failed: value at '%s' differs", op.Path)
+		}
+		return doc, nil
+	case "remove":
+		return pointerRemove(doc, path)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := pointerGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.Op == "copy" {
+			return pointerAdd(doc, path, cloneValue(value))
+		}
+		if op.Path == op.From {
+			return doc, nil
+		}
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("cannot move '%s' into one of its children", op.From)
+		}
+		if doc, err = pointerRemove(doc, from); err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, value)
+	}
+	return nil, fmt.Errorf("unknown operation '%s'", op.Op)
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("invalid JSON pointer '%s'", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func pointerGet(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		var err error
+		if node, err = childOf(node, token); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+func pointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return modifyParent(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			p[token] = value
+			return p, nil
+		case []interface{}:
+			if token == "-" {
+				return append(p, value), nil
+			}
+			i, err := arrayIndex(token, len(p)+1)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, nil)
+			copy(p[i+1:], p[i:])
+			p[i] = value
+			return p, nil
+		}
+		return nil, fmt.Errorf("cannot add '%s' to a scalar value", token)
+	})
+}
+
+func pointerRemove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("cannot remove the whole document")
+	}
+	return modifyParent(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := p[token]; !ok {
+				return nil, fmt.Errorf("member '%s' does not exist", token)
+			}
+			delete(p, token)
+			return p, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(p))
+			if err != nil {
+				return nil, err
+			}
+			return append(p[:i], p[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("cannot remove '%s' from a scalar value", token)
+	})
+}
+
+func pointerReplace(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return modifyParent(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		if _, err := childOf(parent, token); err != nil {
+			return nil, err
+		}
+		return setChild(parent, token, value)
+	})
+}
+
+// modifyParent walks to the parent of the last token of path, lets fn
+// return its new value and stores it back into the tree
+func modifyParent(node interface{}, path []string, fn func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(node, path[0])
+	}
+
+	child, err := childOf(node, path[0])
+	if err != nil {
+		return nil, err
+	}
+	child, err = modifyParent(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+	return setChild(node, path[0], child)
+}
+
+func childOf(node interface{}, token string) (interface{}, error) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[token]
+		if !ok {
+			return nil, fmt.Errorf("member '%s' does not exist", token)
+		}
+		return child, nil
+	case []interface{}:
+		i, err := arrayIndex(token, len(n))
+		if err != nil {
+			return nil, err
+		}
+		return n[i], nil
+	}
+	return nil, fmt.Errorf("cannot reference '%s' in a scalar value", token)
+}
+
+func setChild(node interface{}, token string, value interface{}) (interface{}, error) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		n[token] = value
+		return n, nil
+	case []interface{}:
+		i, err := arrayIndex(token, len(n))
+		if err != nil {
+			return nil, err
+		}
+		n[i] = value
+		return n, nil
+	}
+	return nil, fmt.Errorf("cannot set '%s' in a scalar value", token)
+}
+
+// arrayIndex parses an array index token that must be lower than limit
+func arrayIndex(token string, limit int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index '%s'", token)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid array index '%s'", token)
+	}
+	if i >= limit {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
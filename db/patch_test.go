@@ -0,0 +1,120 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const patchBase = `{"name": "Opeth", "members": ["Åkerfeldt", "Lindgren"], "label": {"name": "Candlelight"}, "a/b": 1, "m~n": 2}`
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  string // Empty when the patch must fail
+	}{
+		{"add field", `[{"op": "add", "path": "/year", "value": 1990}]`,
+			`{"name": "Opeth", "members": ["Åkerfeldt", "Lindgren"], "label": {"name": "Candlelight"}, "a/b": 1, "m~n": 2, "year": 1990}`},
+		{"add to array", `[{"op": "add", "path": "/members/1", "value": "Åkesson"}]`,
+			`{"name": "Opeth", "members": ["Åkerfeldt", "Åkesson", "Lindgren"], "label": {"name": "Candlelight"}, "a/b": 1, "m~n": 2}`},
+		{"append to array", `[{"op": "add", "path": "/members/-", "value": "Axenrot"}]`,
+			`{"name": "Opeth", "members": ["Åkerfeldt", "Lindgren", "Axenrot"], "label": {"name": "Candlelight"}, "a/b": 1, "m~n": 2}`},
+		{"remove escaped", `[{"op": "remove", "path": "/a~1b"}, {"op": "remove", "path": "/m~0n"}]`,
+			`{"name": "Opeth", "members": ["Åkerfeldt", "Lindgren"], "label": {"name": "Candlelight"}}`},
+		{"replace nested", `[{"op": "replace", "path": "/label/name", "value": "Roadrunner"}]`,
+			`{"name": "Opeth", "members": ["Åkerfeldt", "Lindgren"], "label": {"name": "Roadrunner"}, "a/b": 1, "m~n": 2}`},
+		{"move", `[{"op": "move", "from": "/label/name", "path": "/labelName"}]`,
+			`{"name": "Opeth", "members": ["Åkerfeldt", "Lindgren"], "label": {}, "labelName": "Candlelight", "a/b": 1, "m~n": 2}`},
+		{"copy", `[{"op": "copy", "from": "/members/0", "path": "/founder"}]`,
+			`{"name": "Opeth", "members": ["Åkerfeldt", "Lindgren"], "founder": "Åkerfeldt", "label": {"name": "Candlelight"}, "a/b": 1, "m~n": 2}`},
+		{"test passes", `[{"op": "test", "path": "/members/0", "value": "Åkerfeldt"}]`, patchBase},
+		{"test fails", `[{"op": "test", "path": "/name", "value": "Camel"}]`, ""},
+		{"replace missing", `[{"op": "replace", "path": "/year", "value": 1990}]`, ""},
+		{"remove missing", `[{"op": "remove", "path": "/year"}]`, ""},
+		{"index out of range", `[{"op": "add", "path": "/members/5", "value": "x"}]`, ""},
+		{"leading zero index", `[{"op": "remove", "path": "/members/01"}]`, ""},
+		{"move into child", `[{"op": "move", "from": "/label", "path": "/label/old"}]`, ""},
+		{"missing value", `[{"op": "add", "path": "/year"}]`, ""},
+		{"invalid pointer", `[{"op": "remove", "path": "name"}]`, ""},
+		{"unknown op", `[{"op": "increment", "path": "/year"}]`, ""},
+	}
+
+	for _, tt := range tests {
+		var doc interface{}
+		if err := json.Unmarshal([]byte(patchBase), &doc); err != nil {
+			t.Fatal(err)
+		}
+		var ops []patchOperation
+		if err := json.Unmarshal([]byte(tt.patch), &ops); err != nil {
+			t.Fatal(err)
+		}
+
+		var err error
+		for _, op := range ops {
+			if doc, err = applyPatchOperation(doc, op); err != nil {
+				break
+			}
+		}
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: patch succeeded with %v, want an error", tt.name, doc)
+			}
+			continue
+		}
+		var want interface{}
+		json.Unmarshal([]byte(tt.want), &want)
+		if err != nil || !reflect.DeepEqual(doc, want) {
+			t.Errorf("%s: patched = %v, %v; want %v", tt.name, doc, err, want)
+		}
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		target, patch, want string
+	}{
+		{`{"a": "b"}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "b"}`, `{"b": "c"}`, `{"a": "b", "b": "c"}`},
+		{`{"a": "b"}`, `{"a": null}`, `{}`},
+		{`{"a": {"b": "c"}}`, `{"a": {"b": "d", "c": null}}`, `{"a": {"b": "d"}}`},
+		{`{"a": [{"b": "c"}]}`, `{"a": [1]}`, `{"a": [1]}`},
+		{`{"a": "foo"}`, `{"a": {"b": null}}`, `{"a": {}}`},
+		{`{"a": "b"}`, `["c"]`, `["c"]`},
+	}
+
+	for _, tt := range tests {
+		var target, patch, want interface{}
+		json.Unmarshal([]byte(tt.target), &target)
+		json.Unmarshal([]byte(tt.patch), &patch)
+		json.Unmarshal([]byte(tt.want), &want)
+		if got := mergePatch(target, patch); !reflect.DeepEqual(got, want) {
+			t.Errorf("mergePatch(%s, %s) = %v, want %s", tt.target, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestPatchJSONIsAtomic(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first operation applies, the second fails: nothing is stored
+	err := d.PatchJSON("bands", "opeth", []byte(`[{"op": "add", "path": "/year", "value": 1990}, {"op": "remove", "path": "/genre"}]`))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("PatchJSON() error = %v, want ErrInvalidInput", err)
+	}
+	var doc map[string]interface{}
+	if err := d.Read("bands", "opeth", &doc); err != nil || doc["year"] != nil {
+		t.Errorf("Read() = %v, %v; want the document unchanged", doc, err)
+	}
+
+	if err := d.MergePatch("bands", "opeth", []byte(`["not", "an", "object"]`)); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("MergePatch() to a non-object error = %v, want ErrInvalidInput", err)
+	}
+	if err := d.MergePatch("bands", "camel", []byte(`{}`)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("MergePatch() of a missing resource error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,410 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ParseError reports a syntax error in a textual query
+type ParseError struct {
+	Column int // 1-based position of the offending token
+	Msg    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Column, e.Msg)
+}
+
+// ParseStatement parses a textual query such as
+//
+//	genre = "Progressive Rock" and year < 1970 and albums.year in [1972, 1973] order by year desc limit 10
+//
+// The filter supports =, !=, <, <=, >, >=, in [...], contains, and, or,
+// not and parentheses. Values are double- or single-quoted strings,
+// numbers, true, false and null. The filter, "order by" and "limit"
+// clauses are all optional. Errors are returned as *ParseError.
+func ParseStatement(src string) (*Statement, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	st := &Statement{}
+
+	if !p.atKeyword("order") && !p.atKeyword("limit") && p.peek().kind != tokEOF {
+		if st.Where, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.atKeyword("order") {
+		p.next()
+		if !p.atKeyword("by") {
+			return nil, p.errorf("expected 'by' after 'order'")
+		}
+		p.next()
+
+		for {
+			field := p.next()
+			if field.kind != tokIdent || isKeyword(field.text) {
+				return nil, p.errorAt(field, "expected field name in 'order by'")
+			}
+			s := Sort{Field: field.text}
+			if p.atKeyword("asc") {
+				p.next()
+			} else if p.atKeyword("desc") {
+				p.next()
+				s.Desc = true
+			}
+			st.OrderBy = append(st.OrderBy, s)
+
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if p.atKeyword("limit") {
+		p.next()
+		t := p.next()
+		n, err := strconv.Atoi(t.text)
+		if t.kind != tokNumber || err != nil || n < 1 {
+			return nil, p.errorAt(t, "expected a positive integer after 'limit'")
+		}
+		st.Limit = n
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorAt(t, fmt.Sprintf("unexpected %s", t))
+	}
+	return st, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOperator
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	value  interface{} // Decoded string or number literal
+	column int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokString:
+		return strconv.Quote(t.value.(string))
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+var keywords = map[string]bool{
+	"and": true, "or": true, "not": true, "in": true, "contains": true,
+	"order": true, "by": true, "asc": true, "desc": true, "limit": true,
+	"true": true, "false": true, "null": true,
+}
+
+func isKeyword(s string) bool {
+	return keywords[strings.ToLower(s)]
+}
+
+func lex(src string) ([]token, error) {
+	runes := []rune(src)
+	var tokens []token
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		column := i + 1
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == '[' || r == ']' || r == ',':
+			kind := map[rune]tokenKind{'(': tokLParen, ')': tokRParen, '[': tokLBracket, ']': tokRBracket, ',': tokComma}[r]
+			tokens = append(tokens, token{kind: kind, text: string(r), column: column})
+			i++
+		case r == '=' || r == '!' || r == '<' || r == '>':
+			op := string(r)
+			if i+1 < len(runes) && (runes[i+1] == '=' || (r == '<' && runes[i+1] == '>')) {
+				op += string(runes[i+1])
+			}
+			if op == "!" {
+				return nil, &ParseError{Column: column, Msg: "expected '!='"}
+			}
+			tokens = append(tokens, token{kind: tokOperator, text: op, column: column})
+			i += len(op)
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(runes) && runes[j] != r {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(runes) {
+				return nil, &ParseError{Column: column, Msg: "unterminated string"}
+			}
+
+			value, err := unquote(string(runes[i+1:j]), byte(r))
+			if err != nil {
+				return nil, &ParseError{Column: column, Msg: "invalid escape in string"}
+			}
+			tokens = append(tokens, token{kind: tokString, text: string(runes[i : j+1]), value: value, column: column})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || strings.ContainsRune(".eE+-", runes[j])) {
+				if (runes[j] == '+' || runes[j] == '-') && runes[j-1] != 'e' && runes[j-1] != 'E' {
+					break
+				}
+				j++
+			}
+			text := string(runes[i:j])
+			f, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, &ParseError{Column: column, Msg: fmt.Sprintf("invalid number '%s'", text)}
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: text, value: f, column: column})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '.') {
+				j++
+			}
+			text := string(runes[i:j])
+			if strings.HasSuffix(text, ".") || strings.Contains(text, "..") {
+				return nil, &ParseError{Column: column, Msg: fmt.Sprintf("invalid field name '%s'", text)}
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: text, column: column})
+			i = j
+		default:
+			return nil, &ParseError{Column: column, Msg: fmt.Sprintf("unexpected character '%c'", r)}
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, column: len(runes) + 1}), nil
+}
+
+// unquote interprets the Go escapes of the body of a string quoted with
+// quote. Either quote may be escaped in both kinds of string.
+func unquote(body string, quote byte) (string, error) {
+	var sb strings.Builder
+	for body != "" {
+		if len(body) > 1 && body[0] == '\\' && (body[1] == '"' || body[1] == '\'') {
+			sb.WriteByte(body[1])
+			body = body[2:]
+			continue
+		}
+		r, multibyte, tail, err := strconv.UnquoteChar(body, quote)
+		if err != nil {
+			return "", err
+		}
+		if r < utf8.RuneSelf || !multibyte {
+			sb.WriteByte(byte(r))
+		} else {
+			sb.WriteRune(r)
+		}
+		body = tail
+	}
+	return sb.String(), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) atKeyword(kw string) bool {
+	t := p.peek()
+	return t.kind == tokIdent && strings.EqualFold(t.text, kw)
+}
+
+func (p *parser) errorf(msg string) error {
+	return p.errorAt(p.peek(), msg)
+}
+
+func (p *parser) errorAt(t token, msg string) error {
+	return &ParseError{Column: t.column, Msg: msg}
+}
+
+func (p *parser) parseOr() (*Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	if !p.atKeyword("or") {
+		return left, nil
+	}
+
+	f := &Filter{Or: []Filter{*left}}
+	for p.atKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		f.Or = append(f.Or, *right)
+	}
+	return f, nil
+}
+
+func (p *parser) parseAnd() (*Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if !p.atKeyword("and") {
+		return left, nil
+	}
+
+	f := &Filter{And: []Filter{*left}}
+	for p.atKeyword("and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		f.And = append(f.And, *right)
+	}
+	return f, nil
+}
+
+func (p *parser) parseUnary() (*Filter, error) {
+	if p.atKeyword("not") {
+		p.next()
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Filter{Not: inner}, nil
+	}
+
+	if p.peek().kind == tokLParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokRParen {
+			return nil, p.errorf(fmt.Sprintf("expected ')' but found %s", p.peek()))
+		}
+		p.next()
+		return inner, nil
+	}
+
+	return p.parseComparison()
+}
+
+var comparisonOperators = map[string]string{
+	"=": "eq", "==": "eq", "!=": "ne", "<>": "ne",
+	"<": "lt", "<=": "lte", ">": "gt", ">=": "gte",
+}
+
+func (p *parser) parseComparison() (*Filter, error) {
+	field := p.next()
+	if field.kind != tokIdent || isKeyword(field.text) {
+		return nil, p.errorAt(field, fmt.Sprintf("expected field name but found %s", field))
+	}
+
+	opToken := p.next()
+	var operator string
+	switch {
+	case opToken.kind == tokOperator:
+		operator = comparisonOperators[opToken.text]
+	case opToken.kind == tokIdent && strings.EqualFold(opToken.text, "in"):
+		operator = "in"
+	case opToken.kind == tokIdent && strings.EqualFold(opToken.text, "contains"):
+		operator = "contains"
+	default:
+		return nil, p.errorAt(opToken, fmt.Sprintf("expected comparison operator after '%s' but found %s", field.text, opToken))
+	}
+
+	var value interface{}
+	var err error
+	if operator == "in" {
+		value, err = p.parseList()
+	} else {
+		value, err = p.parseValue()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Filter{Query: &Query{Field: field.text, Operator: operator, Value: value}}, nil
+}
+
+func (p *parser) parseList() ([]interface{}, error) {
+	if t := p.next(); t.kind != tokLBracket {
+		return nil, p.errorAt(t, fmt.Sprintf("expected '[' after 'in' but found %s", t))
+	}
+
+	list := []interface{}{}
+	if p.peek().kind == tokRBracket {
+		p.next()
+		return list, nil
+	}
+	for {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+
+		t := p.next()
+		if t.kind == tokRBracket {
+			return list, nil
+		}
+		if t.kind != tokComma {
+			return nil, p.errorAt(t, fmt.Sprintf("expected ',' or ']' but found %s", t))
+		}
+	}
+}
+
+func (p *parser) parseValue() (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString, tokNumber:
+		return t.value, nil
+	case tokIdent:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+	}
+	return nil, p.errorAt(t, fmt.Sprintf("expected a value but found %s", t))
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseStatementStrings(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`name = "Opeth"`, "Opeth"},
+		{`name = 'Opeth'`, "Opeth"},
+		{`name = 'say "hi"'`, `say "hi"`},
+		{`name = 'say \"hi\"'`, `say "hi"`},
+		{`name = 'it\'s'`, "it's"},
+		{`name = "it's"`, "it's"},
+		{`name = "it\'s"`, "it's"},
+		{`name = "say \"hi\""`, `say "hi"`},
+		{`name = 'back\\slash'`, `back\slash`},
+		{`name = 'tab\there'`, "tab\there"},
+		{`name = 'Motörhead'`, "Motörhead"},
+		{`name = 'Mot\u00f6rhead'`, "Motörhead"},
+		{`name = ''`, ""},
+	}
+
+	for _, tt := range tests {
+		st, err := ParseStatement(tt.src)
+		if err != nil {
+			t.Errorf("ParseStatement(%s) error = %v", tt.src, err)
+			continue
+		}
+		if got := st.Where.Query.Value; got != tt.want {
+			t.Errorf("ParseStatement(%s) value = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseStatement(t *testing.T) {
+	tests := []struct {
+		src  string
+		want *Statement
+	}{
+		{"", &Statement{}},
+		{"year < 1970", &Statement{Where: &Filter{Query: &Query{Field: "year", Operator: "lt", Value: 1970.0}}}},
+		{
+			`genre = "Rock" and not year >= 1980`,
+			&Statement{Where: &Filter{And: []Filter{
+				{Query: &Query{Field: "genre", Operator: "eq", Value: "Rock"}},
+				{Not: &Filter{Query: &Query{Field: "year", Operator: "gte", Value: 1980.0}}},
+			}}},
+		},
+		{
+			"order by year desc, name limit 5",
+			&Statement{OrderBy: []Sort{{Field: "year", Desc: true}, {Field: "name"}}, Limit: 5},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseStatement(tt.src)
+		if err != nil {
+			t.Errorf("ParseStatement(%q) error = %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseStatement(%q) = %+v, want %+v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseStatementErrors(t *testing.T) {
+	tests := []struct {
+		src    string
+		column int
+	}{
+		{`name = "Opeth`, 8},
+		{`name = 'Opeth`, 8},
+		{`name = 'bad \q escape'`, 8},
+		{`name ! "Opeth"`, 6},
+		{"year < 1970 limit -1", 19},
+		{"year < 1970 limit 0", 19}, // Zero would mean no limit
+		{"order year", 7},
+		{"name = 1 2", 10},
+		{"name. = 1", 1},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseStatement(tt.src)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("ParseStatement(%q) error = %v, want a *ParseError", tt.src, err)
+			continue
+		}
+		if parseErr.Column != tt.column {
+			t.Errorf("ParseStatement(%q) error at column %d, want %d (%v)", tt.src, parseErr.Column, tt.column, err)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	d := newTestDriver(t, nil)
+	writeBands(t, d)
+
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"", []string{"Camel", "Katatonia", "Opeth"}},
+		{`country = "Sweden" order by year`, []string{"Opeth", "Katatonia"}},
+		{"year < 1990 or name contains 'ton'", []string{"Camel", "Katatonia"}},
+		{"not country in ['UK'] order by name desc limit 1", []string{"Opeth"}},
+	}
+	for _, tt := range tests {
+		st, err := ParseStatement(tt.src)
+		if err != nil {
+			t.Fatalf("ParseStatement(%q) error = %v", tt.src, err)
+		}
+		docs, err := d.Find("bands", st)
+		if err != nil {
+			t.Errorf("Find(%q) error = %v", tt.src, err)
+			continue
+		}
+		var got []string
+		for _, doc := range docs {
+			got = append(got, doc.(map[string]interface{})["name"].(string))
+		}
+		if st.OrderBy == nil {
+			sort.Strings(got)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Find(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestSavedSearches(t *testing.T) {
+	d := newTestDriver(t, nil)
+	writeBands(t, d)
+
+	if err := d.SaveSearch("old", "bands", "year <"); err == nil {
+		t.Error("SaveSearch() kept an invalid query")
+	}
+	if err := d.SaveSearch("old", "bands", "year < 1980"); err != nil {
+		t.Fatal(err)
+	}
+	docs, err := d.RunSavedSearch("old")
+	if err != nil || len(docs) != 1 {
+		t.Errorf("RunSavedSearch() = %v, %v; want Camel", docs, err)
+	}
+	if searches, err := d.SavedSearches(); err != nil || len(searches) != 1 || searches[0].Name != "old" {
+		t.Errorf("SavedSearches() = %+v, %v", searches, err)
+	}
+
+	if err := d.DeleteSavedSearch("old"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.RunSavedSearch("old"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("RunSavedSearch() after delete error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,252 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DeleteAction decides what happens to referencing documents when the
+// resource they reference is deleted
+type DeleteAction int
+
+const (
+	// Restrict rejects the delete while the resource is referenced
+	Restrict DeleteAction = iota
+	// Cascade deletes the referencing documents
+	Cascade
+	// SetNull sets the referencing field to null. Fields inside arrays
+	// are left as they are.
+	SetNull
+)
+
+func (a DeleteAction) String() string {
+	switch a {
+	case Restrict:
+		return "restrict"
+	case Cascade:
+		return "cascade"
+	case SetNull:
+		return "set-null"
+	}
+	return fmt.Sprintf("DeleteAction(%d)", int(a))
+}
+
+// Reference declares that a field of a collection's documents holds the
+// name of a resource in another collection, e.g. an album's "bandId"
+// pointing at "bands"
+type Reference struct {
+	Field    string // Dotted path of the referencing field
+	Target   string // Referenced collection
+	OnDelete DeleteAction
+}
+
+// AddReference declares a reference from the documents of collection.
+// Write, Update and every other write then reject documents whose field
+// names a missing resource of the target collection; a missing or null
+// field is allowed. Deleting a referenced resource applies OnDelete.
+// Restrict is checked while both collections are locked; Cascade and
+// SetNull run after the delete, so a document written while its target
+// is being deleted may be left dangling.
+func (d *Driver) AddReference(collection string, ref Reference) (err error) {
+	defer d.done("add_reference", collection, "", time.Now(), &err)
+	if err := checkCollection(collection); err != nil {
+		return err
+	}
+	if err := checkCollection(ref.Target); err != nil {
+		return err
+	}
+	if ref.Field == "" {
+		return &DbError{Code: ErrCodeInvalidInput, Message: "reference field is empty"}
+	}
+	if ref.OnDelete < Restrict || ref.OnDelete > SetNull {
+		return &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("unknown delete action %s", ref.OnDelete)}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.references == nil {
+		d.references = make(map[string][]Reference)
+	}
+	d.references[collection] = append(d.references[collection], ref)
+	return nil
+}
+
+// referencesFrom returns the references declared by a collection
+func (d *Driver) referencesFrom(collection string) []Reference {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return append([]Reference(nil), d.references[collection]...)
+}
+
+// referrer is a reference pointing at a collection, with the collection
+// declaring it
+type referrer struct {
+	collection string
+	Reference
+}
+
+// referencesTo returns the references pointing at a collection
+func (d *Driver) referencesTo(target string) []referrer {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var refs []referrer
+	for collection, list := range d.references {
+		for _, ref := range list {
+			if ref.Target == target {
+				refs = append(refs, referrer{collection, ref})
+			}
+		}
+	}
+	return refs
+}
+
+// checkReferences verifies that every reference of a document names an
+// existing resource
+func (d *Driver) checkReferences(collection string, data interface{}) error {
+	refs := d.referencesFrom(collection)
+	if len(refs) == 0 {
+		return nil
+	}
+
+	doc, err := toDocument(data)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		for _, value := range lookupPath(doc, ref.Field) {
+			if value == nil {
+				continue
+			}
+			name, ok := value.(string)
+			if !ok {
+				return &DbError{Code: ErrCodeReferenceViolation, Message: fmt.Sprintf("reference '%s' must be a string", ref.Field)}
+			}
+			if validName(name) != nil {
+				return &DbError{Code: ErrCodeReferenceViolation, Message: fmt.Sprintf("reference '%s' names an invalid resource '%s'", ref.Field, name)}
+			}
+
+			exists, err := d.exists(ref.Target, name)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return &DbError{Code: ErrCodeReferenceViolation, Message: fmt.Sprintf("reference '%s' names missing resource '%s' in collection '%s'", ref.Field, name, ref.Target)}
+			}
+		}
+	}
+	return nil
+}
+
+// referencing returns the resources of a collection whose field names
+// resource
+func (d *Driver) referencing(ref referrer, resource string) ([]string, error) {
+	resources, err := d.listResources(ref.collection)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, id := range resources {
+		doc, err := d.readDocument(ref.collection, id)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, value := range lookupPath(doc, ref.Field) {
+			if value == resource {
+				ids = append(ids, id)
+				break
+			}
+		}
+	}
+	return ids, nil
+}
+
+// lockForDelete locks a collection and the collections referencing it
+// through a Restrict reference, so that no reference to a resource can be
+// written between checkRestrict and its delete
+func (d *Driver) lockForDelete(ctx context.Context, collection string) (func(), error) {
+	names := []string{collection}
+	for _, ref := range d.referencesTo(collection) {
+		if ref.OnDelete == Restrict {
+			names = append(names, ref.collection)
+		}
+	}
+	return d.lockCollectionsContext(ctx, names...)
+}
+
+// checkRestrict rejects the delete of a resource referenced through a
+// Restrict reference. The caller holds the locks taken by lockForDelete.
+func (d *Driver) checkRestrict(collection, resource string) error {
+	for _, ref := range d.referencesTo(collection) {
+		if ref.OnDelete != Restrict {
+			continue
+		}
+
+		ids, err := d.referencing(ref, resource)
+		if err != nil {
+			return err
+		}
+		if len(ids) > 0 {
+			return &DbError{Code: ErrCodeReferenceViolation, Message: fmt.Sprintf("resource '%s' in collection '%s' is referenced by '%s' in collection '%s'", resource, collection, ids[0], ref.collection)}
+		}
+	}
+	return nil
+}
+
+// applyDeleteActions cascades the delete of a resource or sets the
+// references to it to null. It is called without any collection lock
+// held, once the resource is deleted: a failure leaves the references
+// that were not handled yet as they are.
+func (d *Driver) applyDeleteActions(collection, resource string) error {
+	for _, ref := range d.referencesTo(collection) {
+		if ref.OnDelete == Restrict {
+			continue
+		}
+
+		ids, err := d.referencing(ref, resource)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if ref.OnDelete == Cascade {
+				err = d.Delete(ref.collection, id)
+			} else {
+				err = d.setNull(ref.collection, id, ref.Field, resource)
+			}
+			if err != nil && !isNotFound(err) {
+				return &DbError{Code: ErrCodeInternal, Message: fmt.Sprintf("failed to %s references to '%s' in collection '%s'", ref.OnDelete, resource, ref.collection), Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// setNull clears a reference field of a resource if it still names
+// target
+func (d *Driver) setNull(collection, resource, field, target string) error {
+	mutex := d.lockCollection(collection)
+	event, err := d.updateLocked(collection, resource, func(data map[string]interface{}) (map[string]interface{}, error) {
+		path := strings.Split(field, ".")
+		if getPath(data, path) == target {
+			setPath(data, path, nil)
+		}
+		return data, nil
+	})
+	mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	d.runAfterHooks(AfterWrite, event)
+	d.runAfterHooks(AfterUpdate, event)
+	return nil
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAddReferenceRejects(t *testing.T) {
+	d := newTestDriver(t, nil)
+	tests := []struct {
+		collection string
+		ref        Reference
+	}{
+		{"", Reference{Field: "bandId", Target: "bands"}},
+		{"albums", Reference{Field: "bandId", Target: ""}},
+		{"albums", Reference{Field: "", Target: "bands"}},
+		{"albums", Reference{Field: "bandId", Target: "bands", OnDelete: SetNull + 1}},
+	}
+
+	for _, tt := range tests {
+		if err := d.AddReference(tt.collection, tt.ref); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("AddReference(%q, %+v) error = %v, want ErrInvalidInput", tt.collection, tt.ref, err)
+		}
+	}
+}
+
+func TestReferenceChecksWrites(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.AddReference("albums", Reference{Field: "band.id", Target: "bands"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		album map[string]interface{}
+		want  error
+	}{
+		{map[string]interface{}{"band": map[string]interface{}{"id": "opeth"}}, nil},
+		{map[string]interface{}{"band": map[string]interface{}{"id": "camel"}}, ErrReferenceViolation},
+		{map[string]interface{}{"band": map[string]interface{}{"id": nil}}, nil},
+		{map[string]interface{}{"name": "Orchid"}, nil},
+	}
+	for _, tt := range tests {
+		if err := d.Write("albums", "orchid", tt.album); !errors.Is(err, tt.want) && (err != nil || tt.want != nil) {
+			t.Errorf("Write(%v) error = %v, want %v", tt.album, err, tt.want)
+		}
+	}
+}
+
+func TestReferenceDeleteActions(t *testing.T) {
+	tests := []struct {
+		action DeleteAction
+		delete error
+		album  map[string]interface{} // Left afterwards, nil when deleted
+	}{
+		{Restrict, ErrReferenceViolation, map[string]interface{}{"name": "Orchid", "bandId": "opeth"}},
+		{Cascade, nil, nil},
+		{SetNull, nil, map[string]interface{}{"name": "Orchid", "bandId": nil}},
+	}
+
+	for _, tt := range tests {
+		d := newTestDriver(t, nil)
+		if err := d.AddReference("albums", Reference{Field: "bandId", Target: "bands", OnDelete: tt.action}); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Write("albums", "orchid", map[string]interface{}{"name": "Orchid", "bandId": "opeth"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Delete("bands", "opeth"); !errors.Is(err, tt.delete) && (err != nil || tt.delete != nil) {
+			t.Errorf("%s: Delete() error = %v, want %v", tt.action, err, tt.delete)
+		}
+		var album map[string]interface{}
+		err := d.Read("albums", "orchid", &album)
+		switch {
+		case tt.album == nil && !errors.Is(err, ErrNotFound):
+			t.Errorf("%s: Read() = %v, %v; want the album deleted", tt.action, album, err)
+		case tt.album != nil && (err != nil || len(album) != len(tt.album) || album["bandId"] != tt.album["bandId"]):
+			t.Errorf("%s: Read() = %v, %v; want %v", tt.action, album, err, tt.album)
+		}
+	}
+}
+
+func TestRestrictHoldsReferencingLock(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.AddReference("albums", Reference{Field: "bandId", Target: "bands"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// No album can reference the band between the check and the delete
+	mutex := d.lockCollection("albums")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := d.DeleteContext(ctx, "bands", "opeth"); !errors.Is(err, ErrCanceled) {
+		t.Errorf("DeleteContext() while albums is locked error = %v, want ErrCanceled", err)
+	}
+	mutex.Unlock()
+
+	if err := d.Delete("bands", "opeth"); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+}
+
+func TestCascadeFailureKeepsDelete(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.AddReference("albums", Reference{Field: "bandId", Target: "bands", OnDelete: Cascade}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("albums", "orchid", map[string]interface{}{"bandId": "opeth"}); err != nil {
+		t.Fatal(err)
+	}
+	d.AddHook("albums", BeforeDelete, func(*HookEvent) error { return errors.New("albums are forever") })
+
+	if err := d.Delete("bands", "opeth"); !errors.Is(err, ErrInternal) {
+		t.Fatalf("Delete() with a failing cascade error = %v, want ErrInternal", err)
+	}
+	if exists, _ := d.exists("bands", "opeth"); exists {
+		t.Error("the band was kept although only its cascade failed")
+	}
+	if exists, _ := d.exists("albums", "orchid"); !exists {
+		t.Error("the album rejected by the hook was deleted")
+	}
+	if _, ok := d.stats.Get("bands")["latency"].(map[string]interface{})["delete"]; !ok {
+		t.Error("the delete was not recorded in the stats")
+	}
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// searchesFile holds the saved searches of a database. Its leading dot
+// keeps it apart from collection directories.
+const searchesFile = ".searches"
+
+// SavedSearch is a named textual query kept with the database
+type SavedSearch struct {
+	Name       string `json:"name"`
+	Collection string `json:"collection"`
+	Query      string `json:"query"`
+}
+
+// SaveSearch stores a textual query under a name, replacing any search
+// saved under the same name. The query is parsed first so that only valid
+// queries are kept.
+func (d *Driver) SaveSearch(name, collection, query string) (err error) {
+	defer d.done("save_search", collection, name, time.Now(), &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+	if name == "" {
+		return &DbError{Code: ErrCodeInvalidInput, Message: "search name cannot be empty"}
+	}
+	if err := checkCollection(collection); err != nil {
+		return err
+	}
+	if _, err := ParseStatement(query); err != nil {
+		return &DbError{Code: ErrCodeInvalidInput, Message: "invalid query", Err: err}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	searches, err := d.readSearches()
+	if err != nil {
+		return err
+	}
+	searches[name] = SavedSearch{Name: name, Collection: collection, Query: query}
+	return d.writeSearches(searches)
+}
+
+// SavedSearches returns every saved search, sorted by name
+func (d *Driver) SavedSearches() (_ []SavedSearch, err error) {
+	defer d.done("saved_searches", "", "", time.Now(), &err)
+	d.mutex.Lock()
+	searches, err := d.readSearches()
+	d.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]SavedSearch, 0, len(searches))
+	for _, s := range searches {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// DeleteSavedSearch removes a saved search
+func (d *Driver) DeleteSavedSearch(name string) (err error) {
+	defer d.done("delete_saved_search", "", name, time.Now(), &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	searches, err := d.readSearches()
+	if err != nil {
+		return err
+	}
+	if _, ok := searches[name]; !ok {
+		return savedSearchNotFound(name)
+	}
+	delete(searches, name)
+	return d.writeSearches(searches)
+}
+
+// RunSavedSearch runs the query saved under a name against its collection
+func (d *Driver) RunSavedSearch(name string) (_ []interface{}, err error) {
+	defer d.done("run_saved_search", "", name, time.Now(), &err)
+	d.mutex.Lock()
+	searches, err := d.readSearches()
+	d.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	s, ok := searches[name]
+	if !ok {
+		return nil, savedSearchNotFound(name)
+	}
+
+	st, err := ParseStatement(s.Query)
+	if err != nil {
+		return nil, &DbError{Code: ErrCodeInvalidInput, Message: "invalid query", Err: err}
+	}
+	return d.Find(s.Collection, st)
+}
+
+func (d *Driver) readSearches() (map[string]SavedSearch, error) {
+	searches := make(map[string]SavedSearch)
+
+	b, err := os.ReadFile(filepath.Join(d.dir, searchesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return searches, nil
+		}
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to read saved searches", Err: err}
+	}
+
+	if err := json.Unmarshal(b, &searches); err != nil {
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to unmarshal saved searches", Err: err}
+	}
+	return searches, nil
+}
+
+func (d *Driver) writeSearches(searches map[string]SavedSearch) error {
+	path := filepath.Join(d.dir, searchesFile)
+
+	b, err := json.MarshalIndent(searches, "", "\t")
+	if err != nil {
+		return &DbError{Code: ErrCodeInternal, Message: "failed to marshal saved searches", Err: err}
+	}
+
+	if err := os.WriteFile(path+".tmp", append(b, '\n'), 0644); err != nil {
+		return &DbError{Code: ErrCodeInternal, Message: "failed to write saved searches", Err: err}
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return &DbError{Code: ErrCodeInternal, Message: "failed to rename saved searches", Err: err}
+	}
+	return nil
+}
+
+func savedSearchNotFound(name string) *DbError {
+	return &DbError{Code: ErrCodeNotFound, Message: fmt.Sprintf("saved search '%s' not found", name)}
+}
@@ -0,0 +1,311 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BM25 ranking parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// SearchResult is a document matching a full-text search
+type SearchResult struct {
+	ID       string
+	Score    float64
+	Document map[string]interface{}
+}
+
+// textIndex is the inverted full-text index of a collection. It is only
+// accessed with the collection mutex held.
+type textIndex struct {
+	docs     map[string]*indexedDoc
+	postings map[string]map[string]bool // term -> resources containing it
+}
+
+// indexedDoc holds the term frequencies of one resource by field path,
+// e.g. "name" or "albums.name"
+type indexedDoc struct {
+	terms   map[string]map[string]int
+	lengths map[string]int
+}
+
+// Search runs a full-text query against the string fields of a
+// collection's documents and returns them ranked by BM25 score. When
+// fields are given, only those fields (and the fields nested below them)
+// are searched. The index of a collection is built on its first search
+// and kept up to date by every write, update and delete.
+func (d *Driver) Search(collection, text string, fields ...string) ([]SearchResult, error) {
+	return d.SearchContext(context.Background(), collection, text, fields...)
+}
+
+// SearchContext is Search giving up when ctx is done while waiting for
+// the collection lock or building the index
+func (d *Driver) SearchContext(ctx context.Context, collection, text string, fields ...string) (_ []SearchResult, err error) {
+	start := time.Now()
+	defer d.done("search", collection, "", start, &err)
+	if err := checkCollection(collection); err != nil {
+		return nil, err
+	}
+
+	terms := uniqueTerms(tokenize(text))
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	mutex, err := d.lockContext(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := d.ensureSearchIndex(ctx, collection)
+	if err != nil {
+		mutex.Unlock()
+		return nil, err
+	}
+
+	var results []SearchResult
+	for resource, score := range idx.score(terms, fields) {
+		doc, err := d.readDocument(collection, resource)
+		if err != nil {
+			mutex.Unlock()
+			return nil, err
+		}
+		results = append(results, SearchResult{ID: resource, Score: score, Document: doc})
+	}
+	mutex.Unlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+
+	d.updateStats(collection, "search", start)
+	return results, nil
+}
+
+// ensureSearchIndex returns the index of a collection, building it from
+// the stored documents if needed. The caller holds the collection mutex.
+func (d *Driver) ensureSearchIndex(ctx context.Context, collection string) (*textIndex, error) {
+	if idx := d.searchIndex(collection); idx != nil {
+		return idx, nil
+	}
+
+	resources, err := d.listResources(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &textIndex{
+		docs:     make(map[string]*indexedDoc),
+		postings: make(map[string]map[string]bool),
+	}
+	for _, resource := range resources {
+		if err := ctx.Err(); err != nil {
+			return nil, canceled(err)
+		}
+		raw, err := d.readFile(collection, resource)
+		if err != nil {
+			return nil, err
+		}
+		idx.put(resource, raw)
+	}
+
+	d.mutex.Lock()
+	if d.search == nil {
+		d.search = make(map[string]*textIndex)
+	}
+	d.search[collection] = idx
+	d.mutex.Unlock()
+	return idx, nil
+}
+
+func (d *Driver) searchIndex(collection string) *textIndex {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.search[collection]
+}
+
+func (d *Driver) indexDocument(collection, resource string, raw []byte) {
+	if idx := d.searchIndex(collection); idx != nil {
+		idx.put(resource, raw)
+	}
+}
+
+func (d *Driver) unindexDocument(collection, resource string) {
+	if idx := d.searchIndex(collection); idx != nil {
+		idx.remove(resource)
+	}
+}
+
+func (d *Driver) dropSearchIndex(collection string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.search, collection)
+}
+
+func (idx *textIndex) put(resource string, raw []byte) {
+	idx.remove(resource)
+
+	var data interface{}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return
+	}
+
+	doc := &indexedDoc{
+		terms:   make(map[string]map[string]int),
+		lengths: make(map[string]int),
+	}
+	collectText("", data, doc)
+
+	for _, terms := range doc.terms {
+		for term := range terms {
+			if idx.postings[term] == nil {
+				idx.postings[term] = make(map[string]bool)
+			}
+			idx.postings[term][resource] = true
+		}
+	}
+	idx.docs[resource] = doc
+}
+
+func (idx *textIndex) remove(resource string) {
+	doc, ok := idx.docs[resource]
+	if !ok {
+		return
+	}
+
+	for _, terms := range doc.terms {
+		for term := range terms {
+			delete(idx.postings[term], resource)
+			if len(idx.postings[term]) == 0 {
+				delete(idx.postings, term)
+			}
+		}
+	}
+	delete(idx.docs, resource)
+}
+
+// score returns the BM25 score of every resource matching at least one
+// of the terms within the given fields
+func (idx *textIndex) score(terms, fields []string) map[string]float64 {
+	n := float64(len(idx.docs))
+	if n == 0 {
+		return nil
+	}
+
+	var totalLength float64
+	for _, doc := range idx.docs {
+		totalLength += float64(doc.length(fields))
+	}
+	avgLength := totalLength / n
+	if avgLength == 0 {
+		return nil
+	}
+
+	scores := make(map[string]float64)
+	for _, term := range terms {
+		// Term frequencies within the selected fields
+		freqs := make(map[string]int)
+		for resource := range idx.postings[term] {
+			if tf := idx.docs[resource].frequency(term, fields); tf > 0 {
+				freqs[resource] = tf
+			}
+		}
+		if len(freqs) == 0 {
+			continue
+		}
+
+		df := float64(len(freqs))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for resource, tf := range freqs {
+			dl := float64(idx.docs[resource].length(fields))
+			f := float64(tf)
+			scores[resource] += idf * f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*dl/avgLength))
+		}
+	}
+	return scores
+}
+
+func (doc *indexedDoc) frequency(term string, fields []string) int {
+	tf := 0
+	for path, terms := range doc.terms {
+		if fieldSelected(path, fields) {
+			tf += terms[term]
+		}
+	}
+	return tf
+}
+
+func (doc *indexedDoc) length(fields []string) int {
+	l := 0
+	for path, n := range doc.lengths {
+		if fieldSelected(path, fields) {
+			l += n
+		}
+	}
+	return l
+}
+
+func fieldSelected(path string, fields []string) bool {
+	if len(fields) == 0 {
+		return true
+	}
+	for _, f := range fields {
+		if path == f || strings.HasPrefix(path, f+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// collectText indexes every string value of v under its dotted path.
+// Array elements share the path of the array.
+func collectText(path string, v interface{}, doc *indexedDoc) {
+	switch t := v.(type) {
+	case string:
+		terms := tokenize(t)
+		if len(terms) == 0 {
+			return
+		}
+		if doc.terms[path] == nil {
+			doc.terms[path] = make(map[string]int)
+		}
+		for _, term := range terms {
+			doc.terms[path][term]++
+		}
+		doc.lengths[path] += len(terms)
+	case map[string]interface{}:
+		for key, value := range t {
+			if path != "" {
+				key = path + "." + key
+			}
+			collectText(key, value, doc)
+		}
+	case []interface{}:
+		for _, value := range t {
+			collectText(path, value, doc)
+		}
+	}
+}
+
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, t := range terms {
+		if !seen[t] {
+			seen[t] = true
+			unique = append(unique, t)
+		}
+	}
+	return unique
+}
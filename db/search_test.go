@@ -0,0 +1,98 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStem(t *testing.T) {
+	// Examples from Porter's paper
+	tests := map[string]string{
+		"caresses": "caress", "ponies": "poni", "ties": "ti", "cats": "cat",
+		"feed": "feed", "agreed": "agre", "plastered": "plaster", "motoring": "motor",
+		"sing": "sing", "conflated": "conflat", "hopping": "hop", "falling": "fall",
+		"filing": "file", "happy": "happi", "relational": "relat", "rational": "ration",
+		"generalization": "gener", "hopeful": "hope", "goodness": "good",
+		"adjustment": "adjust", "probate": "probat", "controll": "control",
+		// Left as is
+		"ox": "ox", "mötley": "mötley", "1990s": "1990s",
+	}
+
+	for word, want := range tests {
+		if got := stem(word); got != want {
+			t.Errorf("stem(%q) = %q, want %q", word, got, want)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"The Dark Side of the Moon", []string{"dark", "side", "moon"}},
+		{"Mötley Crüe", []string{"motlei", "crue"}},
+		{"Larks' Tongues in Aspic", []string{"lark", "tongu", "aspic"}},
+		{"Emerson, Lake & Palmer", []string{"emerson", "lake", "palmer"}},
+		{"the of and", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	d := newTestDriver(t, nil)
+	bands := map[string]interface{}{
+		"opeth": map[string]interface{}{"name": "Opeth", "genre": "progressive death metal",
+			"albums": []interface{}{map[string]interface{}{"name": "Blackwater Park"}}},
+		"camel":     map[string]interface{}{"name": "Camel", "genre": "progressive rock"},
+		"metallica": map[string]interface{}{"name": "Metallica", "genre": "thrash metal"},
+	}
+	for resource, doc := range bands {
+		if err := d.Write("bands", resource, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := func(results []SearchResult) []string {
+		var ids []string
+		for _, r := range results {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		text   string
+		fields []string
+		want   []string
+	}{
+		{"progressive", nil, []string{"camel", "opeth"}},
+		{"progressive metal", nil, []string{"opeth", "camel", "metallica"}},
+		{"blackwater", nil, []string{"opeth"}},
+		{"blackwater", []string{"name"}, nil},
+		{"blackwater", []string{"albums"}, []string{"opeth"}},
+		{"the", nil, nil},
+	}
+	for _, tt := range tests {
+		results, err := d.Search("bands", tt.text, tt.fields...)
+		if err != nil || !reflect.DeepEqual(ids(results), tt.want) {
+			t.Errorf("Search(%q, %v) = %v, %v; want %v", tt.text, tt.fields, ids(results), err, tt.want)
+		}
+	}
+
+	// The index follows writes and deletes after it was built
+	if err := d.Update("bands", "camel", map[string]interface{}{"genre": "symphonic rock"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete("bands", "metallica"); err != nil {
+		t.Fatal(err)
+	}
+	if results, err := d.Search("bands", "progressive metal"); err != nil || !reflect.DeepEqual(ids(results), []string{"opeth"}) {
+		t.Errorf("Search() after changes = %v, %v; want [opeth]", ids(results), err)
+	}
+}
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ShardOptions configures ShardCollection
+type ShardOptions struct {
+	// Levels is the number of hash prefix directories, from 1 to
+	// MaxShardLevels; 0 means 2
+	Levels int
+	// BatchSize is the number of files moved per acquisition of the
+	// collection lock; 0 means 100
+	BatchSize int
+	// Progress, if set, is called after each file
+	Progress func(MigrationProgress)
+}
+
+// ShardCollection moves the resource files of a collection into a
+// sharded layout of hash prefix directories and returns how many it
+// moved. It runs online: the new layout is recorded first, so writes go to
+// their sharded place right away and reads find files wherever they are,
+// and the files are then moved in batches between which other operations
+// proceed. A run that fails part-way can simply be repeated. It also
+// changes the number of levels of an already sharded collection.
+func (d *Driver) ShardCollection(collection string, opts *ShardOptions) (_ int, err error) {
+	start := time.Now()
+	defer d.done("shard", collection, "", start, &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return 0, err
+	}
+	defer end()
+	if err := checkCollection(collection); err != nil {
+		return 0, err
+	}
+	if opts == nil {
+		opts = &ShardOptions{}
+	}
+
+	layout := Layout{Levels: opts.Levels}
+	if layout.Levels == 0 {
+		layout.Levels = 2
+	}
+	if layout.Levels < 1 || layout.Levels > MaxShardLevels {
+		return 0, &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("shard levels must be between 1 and %d", MaxShardLevels)}
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	dir := d.collectionDir(collection)
+	mutex := d.lockCollection(collection)
+	err = d.writeLayout(collection, layout)
+	mutex.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	var pending []string
+	err = WalkResources(dir, func(resource, path string, _ fs.DirEntry) error {
+		if path != layout.Path(dir, resource) {
+			pending = append(pending, resource)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, walkError(err)
+	}
+
+	moved := 0
+	for i := 0; i < len(pending); i += batchSize {
+		end := i + batchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+
+		mutex.Lock()
+		n, err := d.moveResources(collection, layout, pending[i:end])
+		mutex.Unlock()
+		moved += n
+		if err != nil {
+			return moved, err
+		}
+
+		if opts.Progress != nil {
+			for j := i; j < end; j++ {
+				opts.Progress(MigrationProgress{Collection: collection, Done: j + 1, Total: len(pending)})
+			}
+		}
+	}
+
+	d.logEvent(slog.LevelInfo, "Sharded collection", slog.String("collection", collection),
+		slog.Int("levels", layout.Levels), slog.Int("moved", moved), slog.Duration("duration", time.Since(start)))
+	d.updateStats(collection, "shard", start)
+	return moved, nil
+}
+
+// writeLayout records the layout of a collection. The caller holds the
+// collection mutex.
+func (d *Driver) writeLayout(collection string, layout Layout) error {
+	dir := d.collectionDir(collection)
+	if _, err := os.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return collectionNotFound(collection)
+		}
+		return &DbError{Code: ErrCodeInternal, Message: "failed to stat collection", Err: err}
+	}
+
+	b := []byte(strconv.Itoa(layout.Levels) + "\n")
+	if err := d.writeRaw(collection, shardFile, b); err != nil {
+		return err
+	}
+	d.replicate(Change{Op: OpFile, Collection: collection, Resource: shardFile, Data: b})
+	return nil
+}
+
+// moveResources moves resource files to their place in layout. Files
+// deleted or rewritten since they were listed are left alone. The caller
+// holds the collection mutex, but the server may be writing the same
+// collection from another process: a file is linked to its new place only
+// when nothing is there yet, so a newer document written there is never
+// replaced by the old one.
+func (d *Driver) moveResources(collection string, layout Layout, resources []string) (int, error) {
+	dir := d.collectionDir(collection)
+	moved := 0
+	for _, resource := range resources {
+		target := layout.Path(dir, resource)
+		path := layout.Locate(dir, resource)
+		if path == target {
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return moved, &DbError{Code: ErrCodeInternal, Message: "failed to create directory", Err: err}
+		}
+		ok, err := moveFile(path, target)
+		if err != nil {
+			return moved, &DbError{Code: ErrCodeInternal, Message: fmt.Sprintf("failed to move resource '%s'", resource), Err: err}
+		}
+		if ok {
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// moveFile moves a resource file to target unless a file is already
+// there, and reports whether it moved it
+func moveFile(path, target string) (bool, error) {
+	moved := false
+	err := os.Link(path, target)
+	switch {
+	case err == nil:
+		moved = true
+	case os.IsNotExist(err):
+		// Deleted, or moved by its writer, since it was located
+		return false, nil
+	case os.IsExist(err):
+		// Written at its new place since; the old file is stale
+	default:
+		return false, err
+	}
+	return moved, removeStale(path, target)
+}
+
+// removeStale removes the old file of a moved resource. A file rewritten
+// at the old place since the move, by a writer that read the layout
+// before it changed, is kept for the next run to move.
+func removeStale(path, target string) error {
+	old, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	moved, err := os.Lstat(target)
+	if err == nil && !os.SameFile(old, moved) && old.ModTime().After(moved.ModTime()) {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
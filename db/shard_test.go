@@ -0,0 +1,225 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcelliott/lumber"
+)
+
+func TestLayoutPath(t *testing.T) {
+	dir := filepath.Join("data", "bands")
+	tests := []struct {
+		levels int
+		depth  int
+	}{
+		{0, 0},
+		{1, 1},
+		{2, 2},
+		{3, 3},
+	}
+
+	for _, tt := range tests {
+		path := Layout{Levels: tt.levels}.Path(dir, "opeth")
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts := strings.Split(rel, string(filepath.Separator))
+		if len(parts) != tt.depth+1 || parts[len(parts)-1] != "opeth.json" {
+			t.Errorf("Layout{%d}.Path() = %s, want %d shard directories", tt.levels, path, tt.depth)
+		}
+		for _, p := range parts[:len(parts)-1] {
+			if !isShardDir(p) {
+				t.Errorf("Layout{%d}.Path() = %s, %q is not a shard directory", tt.levels, path, p)
+			}
+		}
+		if others := (Layout{Levels: tt.levels}).Others(dir, "opeth"); len(others) != MaxShardLevels {
+			t.Errorf("Layout{%d}.Others() = %v", tt.levels, others)
+		}
+	}
+}
+
+func TestShardCollection(t *testing.T) {
+	d := newTestDriver(t, nil)
+	names := []string{"opeth", "camel", "yes", "genesis", "rush"}
+	for _, name := range names {
+		if err := d.Write("bands", name, map[string]interface{}{"name": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	moved, err := d.ShardCollection("bands", &ShardOptions{Levels: 2, BatchSize: 2})
+	if err != nil {
+		t.Fatalf("ShardCollection() error = %v", err)
+	}
+	if moved != len(names) {
+		t.Errorf("ShardCollection() moved %d, want %d", moved, len(names))
+	}
+
+	dir := d.collectionDir("bands")
+	for _, name := range names {
+		if _, err := os.Stat(Layout{Levels: 2}.Path(dir, name)); err != nil {
+			t.Errorf("%s not at its sharded place: %v", name, err)
+		}
+		if _, err := os.Stat(Layout{}.Path(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s still at its flat place", name)
+		}
+		var doc map[string]interface{}
+		if err := d.Read("bands", name, &doc); err != nil || doc["name"] != name {
+			t.Errorf("Read(%s) = %v, %v", name, doc, err)
+		}
+	}
+
+	// Repeating it, or going back to one level, is allowed
+	if moved, err := d.ShardCollection("bands", &ShardOptions{Levels: 2}); err != nil || moved != 0 {
+		t.Errorf("ShardCollection() again = %d, %v; want 0 moved", moved, err)
+	}
+	if moved, err := d.ShardCollection("bands", &ShardOptions{Levels: 1}); err != nil || moved != len(names) {
+		t.Errorf("ShardCollection(1 level) = %d, %v; want %d moved", moved, err, len(names))
+	}
+	all, err := d.ReadAll("bands")
+	if err != nil || len(all) != len(names) {
+		t.Errorf("ReadAll() = %d documents, %v; want %d", len(all), err, len(names))
+	}
+
+	if _, err := d.ShardCollection("bands", &ShardOptions{Levels: MaxShardLevels + 1}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("ShardCollection(too deep) error = %v, want ErrInvalidInput", err)
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	dir := t.TempDir()
+	flat := filepath.Join(dir, "opeth.json")
+	target := filepath.Join(dir, "ab", "opeth.json")
+	os.MkdirAll(filepath.Dir(target), 0755)
+
+	// Nothing at the new place: moved
+	os.WriteFile(flat, []byte(`{"name": "old"}`), 0644)
+	if moved, err := moveFile(flat, target); err != nil || !moved {
+		t.Fatalf("moveFile() = %v, %v; want moved", moved, err)
+	}
+	if _, err := os.Stat(flat); !os.IsNotExist(err) {
+		t.Error("moved file is still at its old place")
+	}
+
+	// Another process wrote a newer document at the new place after the
+	// old file was located: the old one must not replace it
+	past := time.Now().Add(-time.Minute)
+	os.WriteFile(flat, []byte(`{"name": "old"}`), 0644)
+	os.Chtimes(flat, past, past)
+	os.WriteFile(target, []byte(`{"name": "new"}`), 0644)
+	if moved, err := moveFile(flat, target); err != nil || moved {
+		t.Fatalf("moveFile() over a newer file = %v, %v; want not moved", moved, err)
+	}
+	if b, _ := os.ReadFile(target); string(b) != `{"name": "new"}` {
+		t.Errorf("file at the new place = %s, want the newer document", b)
+	}
+	if _, err := os.Stat(flat); !os.IsNotExist(err) {
+		t.Error("stale file was kept at its old place")
+	}
+
+	// Deleted since it was located
+	if moved, err := moveFile(flat, target); err != nil || moved {
+		t.Errorf("moveFile() of a deleted file = %v, %v; want not moved", moved, err)
+	}
+}
+
+func TestRemoveStale(t *testing.T) {
+	dir := t.TempDir()
+	path, target := filepath.Join(dir, "old.json"), filepath.Join(dir, "new.json")
+
+	// Linked: the old name goes
+	os.WriteFile(path, []byte("{}"), 0644)
+	os.Link(path, target)
+	if err := removeStale(path, target); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("linked old file was kept")
+	}
+
+	// Rewritten at the old place after the move: kept for the next run
+	past := time.Now().Add(-time.Minute)
+	os.Chtimes(target, past, past)
+	os.WriteFile(path, []byte(`{"newer": true}`), 0644)
+	if err := removeStale(path, target); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error("newer file at the old place was removed")
+	}
+
+	// Missing: nothing to do
+	os.Remove(path)
+	if err := removeStale(path, target); err != nil {
+		t.Errorf("removeStale() of a missing file error = %v", err)
+	}
+}
+
+func TestRecordCountIsIncremental(t *testing.T) {
+	d := newTestDriver(t, nil)
+	d.SetLimits("bands", Limits{MaxDocuments: 100})
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.GetStats("bands")["record_count"]; got != 1 {
+		t.Fatalf("record_count = %v, want 1", got)
+	}
+
+	// Only a walk would see a file added behind the driver's back; the
+	// count of a limited collection is kept instead
+	path, err := d.resourcePath("bands", "ghost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		op   func() error
+		want int
+	}{
+		{func() error { return d.Write("bands", "camel", map[string]interface{}{"name": "Camel"}) }, 2},
+		{func() error { return d.Write("bands", "camel", map[string]interface{}{"name": "Camel", "year": 1971}) }, 2},
+		{func() error { return d.Delete("bands", "opeth") }, 1},
+	}
+	for i, step := range steps {
+		if err := step.op(); err != nil {
+			t.Fatal(err)
+		}
+		if got := d.Stats().RecordCount["bands"]; got != step.want {
+			t.Errorf("step %d: record count = %d, want %d", i, got, step.want)
+		}
+	}
+}
+
+func TestRecordCountOfUnlimitedCollection(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.GetStats("bands")["record_count"]; got != 1 {
+		t.Fatalf("record_count = %v, want 1", got)
+	}
+
+	// Another driver writing to the same directory
+	other, err := New(d.Dir(), &Options{Logger: lumber.NewConsoleLogger(lumber.ERROR)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Write("bands", "camel", map[string]interface{}{"name": "Camel"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.GetStats("bands")["record_count"]; got != 2 {
+		t.Errorf("record_count after a write of another driver = %v, want 2", got)
+	}
+	if _, ok := d.knownUsage("bands"); ok {
+		t.Error("the usage of an unlimited collection was kept")
+	}
+}
@@ -0,0 +1,202 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Levels of Logger's Trace and Fatal, which slog does not define
+const (
+	LevelTrace = slog.LevelDebug - 4
+	LevelFatal = slog.LevelError + 4
+)
+
+// SlogLogger is a Logger writing to a slog.Logger. The driver logs its
+// events through LogAttrs, with the collection, resource, operation,
+// duration and error code as attributes.
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a Logger writing to l, or to slog.Default if l is
+// nil
+func NewSlogLogger(l *slog.Logger) *SlogLogger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &SlogLogger{logger: l}
+}
+
+// Slog returns the underlying slog.Logger
+func (l *SlogLogger) Slog() *slog.Logger {
+	return l.logger
+}
+
+// LogAttrs logs a message with structured attributes
+func (l *SlogLogger) LogAttrs(level slog.Level, msg string, attrs ...slog.Attr) {
+	l.logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+// IsDebug reports whether debug messages are logged
+func (l *SlogLogger) IsDebug() bool {
+	return l.logger.Enabled(context.Background(), slog.LevelDebug)
+}
+
+func (l *SlogLogger) logf(level slog.Level, format string, args ...interface{}) {
+	if !l.logger.Enabled(context.Background(), level) {
+		return
+	}
+	l.logger.LogAttrs(context.Background(), level, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+}
+
+// Fatal logs at LevelFatal. Like the other loggers it does not exit.
+func (l *SlogLogger) Fatal(format string, args ...interface{}) { l.logf(LevelFatal, format, args...) }
+func (l *SlogLogger) Error(format string, args ...interface{}) {
+	l.logf(slog.LevelError, format, args...)
+}
+func (l *SlogLogger) Warn(format string, args ...interface{}) {
+	l.logf(slog.LevelWarn, format, args...)
+}
+func (l *SlogLogger) Info(format string, args ...interface{}) {
+	l.logf(slog.LevelInfo, format, args...)
+}
+func (l *SlogLogger) Debug(format string, args ...interface{}) {
+	l.logf(slog.LevelDebug, format, args...)
+}
+func (l *SlogLogger) Trace(format string, args ...interface{}) { l.logf(LevelTrace, format, args...) }
+
+// attrLogger is implemented by loggers accepting structured attributes
+type attrLogger interface {
+	LogAttrs(level slog.Level, msg string, attrs ...slog.Attr)
+}
+
+// LogEvent logs a message with attributes. Loggers without structured
+// output, such as the default console logger, get the attributes appended
+// to the message as key=value pairs.
+func LogEvent(l Logger, level slog.Level, msg string, attrs ...slog.Attr) {
+	if al, ok := l.(attrLogger); ok {
+		al.LogAttrs(level, msg, attrs...)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, a := range attrs {
+		fmt.Fprintf(&b, " %s=%s", a.Key, a.Value)
+	}
+	b.WriteByte('\n')
+
+	line := strings.ReplaceAll(b.String(), "%", "%%")
+	switch {
+	case level >= LevelFatal:
+		l.Fatal(line)
+	case level >= slog.LevelError:
+		l.Error(line)
+	case level >= slog.LevelWarn:
+		l.Warn(line)
+	case level >= slog.LevelInfo:
+		l.Info(line)
+	case level >= slog.LevelDebug:
+		l.Debug(line)
+	default:
+		l.Trace(line)
+	}
+}
+
+// logEvent logs a driver event through LogEvent
+func (d *Driver) logEvent(level slog.Level, msg string, attrs ...slog.Attr) {
+	LogEvent(d.log, level, msg, attrs...)
+}
+
+// done is deferred by the public methods: it attaches the operation,
+// collection and resource to the error they return and logs the outcome
+func (d *Driver) done(op, collection, resource string, start time.Time, err *error) {
+	*err = opError(*err, op, collection, resource)
+	d.logOperation(op, collection, resource, start, *err)
+}
+
+// logOperation logs the outcome of an operation on a resource: failures
+// other than internal errors at debug level like successes, since they
+// are reported to the caller, and internal errors at error level
+func (d *Driver) logOperation(op, collection, resource string, start time.Time, err error) {
+	level := slog.LevelDebug
+	code := ErrCodeInternal
+	var dbErr *DbError
+	if errors.As(err, &dbErr) {
+		code = dbErr.Code
+	}
+	if err != nil && code == ErrCodeInternal {
+		level = slog.LevelError
+	}
+	// Both lumber's loggers and SlogLogger tell whether debug is enabled,
+	// sparing the attributes of every successful operation
+	if l, ok := d.log.(interface{ IsDebug() bool }); ok && level == slog.LevelDebug && !l.IsDebug() {
+		return
+	}
+
+	attrs := []slog.Attr{slog.String("op", op), slog.String("collection", collection)}
+	if resource != "" {
+		attrs = append(attrs, slog.String("resource", resource))
+	}
+	attrs = append(attrs, slog.Duration("duration", time.Since(start)))
+	if err == nil {
+		d.logEvent(slog.LevelDebug, "Operation completed", attrs...)
+		return
+	}
+
+	d.logEvent(level, "Operation failed", append(attrs, slog.Int("code", code), slog.String("error", err.Error()))...)
+}
+
+// ParseLogLevel parses a level name: trace, debug, info, warn, error or
+// fatal
+func ParseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "trace":
+		return LevelTrace, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	case "fatal":
+		return LevelFatal, nil
+	}
+	return 0, &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("unknown log level '%s'", s)}
+}
+
+// NewLogHandler returns a slog handler writing to w in the "text" or
+// "json" format, naming the trace and fatal levels
+func NewLogHandler(w io.Writer, format string, level slog.Leveler) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key != slog.LevelKey || len(groups) > 0 {
+				return a
+			}
+			level, _ := a.Value.Any().(slog.Level)
+			switch level {
+			case LevelTrace:
+				a.Value = slog.StringValue("TRACE")
+			case LevelFatal:
+				a.Value = slog.StringValue("FATAL")
+			}
+			return a
+		},
+	}
+
+	switch format {
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("unknown log format '%s'", format)}
+}
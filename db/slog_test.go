@@ -0,0 +1,131 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		s    string
+		want slog.Level
+	}{
+		{"trace", LevelTrace},
+		{"DEBUG", slog.LevelDebug},
+		{"info", slog.LevelInfo},
+		{"warning", slog.LevelWarn},
+		{"error", slog.LevelError},
+		{"Fatal", LevelFatal},
+	}
+
+	for _, tt := range tests {
+		if got, err := ParseLogLevel(tt.s); err != nil || got != tt.want {
+			t.Errorf("ParseLogLevel(%q) = %v, %v; want %v", tt.s, got, err, tt.want)
+		}
+	}
+	if _, err := ParseLogLevel("verbose"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("ParseLogLevel(verbose) error = %v, want ErrInvalidInput", err)
+	}
+}
+
+func TestNewLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	h, err := NewLogHandler(&buf, "json", LevelTrace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := NewSlogLogger(slog.New(h))
+	logger.Trace("tracing %d", 1)
+	logger.Fatal("stopping\n")
+
+	var levels, msgs []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", line, err)
+		}
+		levels = append(levels, fmt.Sprint(record["level"]))
+		msgs = append(msgs, fmt.Sprint(record["msg"]))
+	}
+	if strings.Join(levels, ",") != "TRACE,FATAL" || strings.Join(msgs, ",") != "tracing 1,stopping" {
+		t.Errorf("logged levels %v and messages %q", levels, msgs)
+	}
+
+	if _, err := NewLogHandler(&buf, "xml", nil); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("NewLogHandler(xml) error = %v, want ErrInvalidInput", err)
+	}
+}
+
+// lineLogger records the lines of a Logger without structured output
+type lineLogger struct {
+	lines []string
+}
+
+func (l *lineLogger) log(level, format string, args ...interface{}) {
+	l.lines = append(l.lines, level+" "+strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+}
+func (l *lineLogger) Fatal(f string, a ...interface{}) { l.log("fatal", f, a...) }
+func (l *lineLogger) Error(f string, a ...interface{}) { l.log("error", f, a...) }
+func (l *lineLogger) Warn(f string, a ...interface{})  { l.log("warn", f, a...) }
+func (l *lineLogger) Info(f string, a ...interface{})  { l.log("info", f, a...) }
+func (l *lineLogger) Debug(f string, a ...interface{}) { l.log("debug", f, a...) }
+func (l *lineLogger) Trace(f string, a ...interface{}) { l.log("trace", f, a...) }
+
+func TestLogEvent(t *testing.T) {
+	tests := []struct {
+		level slog.Level
+		want  string
+	}{
+		{LevelFatal, "fatal Failed at 100% collection=bands"},
+		{slog.LevelError, "error Failed at 100% collection=bands"},
+		{slog.LevelWarn + 1, "warn Failed at 100% collection=bands"},
+		{slog.LevelInfo, "info Failed at 100% collection=bands"},
+		{slog.LevelDebug, "debug Failed at 100% collection=bands"},
+		{LevelTrace, "trace Failed at 100% collection=bands"},
+	}
+
+	for _, tt := range tests {
+		l := &lineLogger{}
+		LogEvent(l, tt.level, "Failed at 100%", slog.String("collection", "bands"))
+		if len(l.lines) != 1 || l.lines[0] != tt.want {
+			t.Errorf("LogEvent(%v) logged %q, want %q", tt.level, l.lines, tt.want)
+		}
+	}
+}
+
+func TestDriverLogsFailures(t *testing.T) {
+	var buf bytes.Buffer
+	h, err := NewLogHandler(&buf, "json", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newTestDriver(t, &Options{Logger: NewSlogLogger(slog.New(h))})
+
+	// Not found is reported to the caller and only logged at debug level
+	var doc map[string]interface{}
+	d.Read("bands", "opeth", &doc)
+	if buf.Len() != 0 {
+		t.Errorf("logged a not found read: %s", buf.String())
+	}
+
+	d.AddHook("bands", BeforeWrite, func(*HookEvent) error {
+		return &DbError{Code: ErrCodeInternal, Message: "disk on fire"}
+	})
+	d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"})
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("logged %q: %v", buf.String(), err)
+	}
+	want := map[string]interface{}{"level": "ERROR", "op": "write", "collection": "bands", "resource": "opeth", "code": float64(500)}
+	for key, value := range want {
+		if record[key] != value {
+			t.Errorf("logged %s = %v, want %v", key, record[key], value)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// IDField is the document field Insert stores the generated ID in
+const IDField = "id"
+
+// slugFile holds the slug-to-ID mapping of a collection. It has no .json
+// extension so it is never listed as a resource.
+const slugFile = ".slugs"
+
+// Slugify turns a name into a lowercase, URL-friendly slug
+func Slugify(name string) string {
+	var b strings.Builder
+	sep := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if sep && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+			sep = false
+		} else {
+			sep = true
+		}
+	}
+	return b.String()
+}
+
+// Insert stores doc under a newly generated ID and returns it. The ID is
+// also written to the document's "id" field. When the document has a
+// "name", a slug derived from it is mapped to the ID; if the slug is taken
+// a numeric suffix is appended.
+func (d *Driver) Insert(collection string, doc interface{}) (_ string, err error) {
+	start := time.Now()
+	defer d.done("insert", collection, "", start, &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return "", err
+	}
+	defer end()
+	if err := checkCollection(collection); err != nil {
+		return "", err
+	}
+
+	data, err := toDocument(doc)
+	if err != nil {
+		return "", err
+	}
+	id := NewID()
+	data[IDField] = id
+
+	mutex := d.lockCollection(collection)
+	event, err := d.createLocked(collection, id, data)
+	if err == nil {
+		if name, ok := data["name"].(string); ok && Slugify(name) != "" {
+			err = d.addSlugLocked(collection, Slugify(name), id)
+		}
+	}
+	mutex.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	d.runAfterHooks(AfterWrite, event)
+
+	// Update stats
+	d.updateStats(collection, "insert", start)
+	return id, nil
+}
+
+// ResolveSlug returns the ID a slug is mapped to
+func (d *Driver) ResolveSlug(collection, slug string) (_ string, err error) {
+	defer d.done("resolve_slug", collection, slug, time.Now(), &err)
+	if err := checkCollection(collection); err != nil {
+		return "", err
+	}
+
+	mutex := d.lockCollection(collection)
+	defer mutex.Unlock()
+
+	slugs, err := d.readSlugs(collection)
+	if err != nil {
+		return "", err
+	}
+	id, ok := slugs[slug]
+	if !ok {
+		return "", &DbError{Code: ErrCodeNotFound, Message: fmt.Sprintf("slug '%s' not found in collection '%s'", slug, collection)}
+	}
+	return id, nil
+}
+
+// SetSlug maps a slug to an existing resource, e.g. after a band was
+// renamed. Previous slugs of the resource keep resolving to it.
+func (d *Driver) SetSlug(collection, slug, id string) (err error) {
+	defer d.done("set_slug", collection, id, time.Now(), &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+	if err := checkNames(collection, id); err != nil {
+		return err
+	}
+	if slug == "" {
+		return &DbError{Code: ErrCodeInvalidInput, Message: "slug cannot be empty"}
+	}
+
+	mutex := d.lockCollection(collection)
+	defer mutex.Unlock()
+
+	exists, err := d.exists(collection, id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return notFound(collection, id)
+	}
+
+	slugs, err := d.readSlugs(collection)
+	if err != nil {
+		return err
+	}
+	if current, ok := slugs[slug]; ok && current != id {
+		return &DbError{Code: ErrCodeAlreadyExists, Message: fmt.Sprintf("slug '%s' already used in collection '%s'", slug, collection)}
+	}
+	slugs[slug] = id
+	return d.writeSlugs(collection, slugs)
+}
+
+// addSlugLocked maps the first free variant of slug to id. The caller
+// holds the collection mutex.
+func (d *Driver) addSlugLocked(collection, slug, id string) error {
+	slugs, err := d.readSlugs(collection)
+	if err != nil {
+		return err
+	}
+
+	candidate := slug
+	for n := 2; ; n++ {
+		if _, taken := slugs[candidate]; !taken {
+			break
+		}
+		candidate = fmt.Sprintf("%s_%d", slug, n)
+	}
+	slugs[candidate] = id
+	return d.writeSlugs(collection, slugs)
+}
+
+// removeSlugsLocked drops every slug pointing at id. The caller holds the
+// collection mutex.
+func (d *Driver) removeSlugsLocked(collection, id string) error {
+	slugs, err := d.readSlugs(collection)
+	if err != nil || len(slugs) == 0 {
+		return err
+	}
+
+	changed := false
+	for slug, target := range slugs {
+		if target == id {
+			delete(slugs, slug)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return d.writeSlugs(collection, slugs)
+}
+
+// slugsOf returns the slugs pointing at id, sorted
+func (d *Driver) slugsOf(collection, id string) ([]string, error) {
+	slugs, err := d.readSlugs(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	var of []string
+	for slug, target := range slugs {
+		if target == id {
+			of = append(of, slug)
+		}
+	}
+	sort.Strings(of)
+	return of, nil
+}
+
+// restoreSlugsLocked maps the slugs of a restored resource back to it,
+// skipping those another resource took. The caller holds the collection
+// mutex.
+func (d *Driver) restoreSlugsLocked(collection, id string, restored []string) error {
+	if len(restored) == 0 {
+		return nil
+	}
+	slugs, err := d.readSlugs(collection)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for _, slug := range restored {
+		if _, taken := slugs[slug]; !taken {
+			slugs[slug] = id
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return d.writeSlugs(collection, slugs)
+}
+
+func (d *Driver) readSlugs(collection string) (map[string]string, error) {
+	slugs := make(map[string]string)
+
+	b, err := os.ReadFile(filepath.Join(d.collectionDir(collection), slugFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return slugs, nil
+		}
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to read slugs", Err: err}
+	}
+
+	if err := json.Unmarshal(b, &slugs); err != nil {
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to unmarshal slugs", Err: err}
+	}
+	return slugs, nil
+}
+
+func (d *Driver) writeSlugs(collection string, slugs map[string]string) error {
+	b, err := json.MarshalIndent(slugs, "", "\t")
+	if err != nil {
+		return &DbError{Code: ErrCodeInternal, Message: "failed to marshal slugs", Err: err}
+	}
+
+	b = append(b, '\n')
+	if err := d.writeRaw(collection, slugFile, b); err != nil {
+		return err
+	}
+	d.replicate(Change{Op: OpFile, Collection: collection, Resource: slugFile, Data: b})
+	return nil
+}
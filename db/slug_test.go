@@ -0,0 +1,82 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Opeth", "opeth"},
+		{"Emerson, Lake & Palmer", "emerson_lake_palmer"},
+		{"  Black   Sabbath ", "black_sabbath"},
+		{"Mötley Crüe", "mötley_crüe"},
+		{"AC/DC", "ac_dc"},
+		{"!!!", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Slugify(tt.name); got != tt.want {
+			t.Errorf("Slugify(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInsertSlugs(t *testing.T) {
+	d := newTestDriver(t, nil)
+	first, err := d.Insert("bands", map[string]interface{}{"name": "Opeth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := d.Insert("bands", map[string]interface{}{"name": "opeth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Insert("bands", map[string]interface{}{"country": "Sweden"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]interface{}
+	if err := d.Read("bands", first, &doc); err != nil || doc[IDField] != first {
+		t.Errorf("Read(%s) = %v, %v; want the ID stored in the document", first, doc, err)
+	}
+
+	if err := d.SetSlug("bands", "opeth_sweden", first); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		slug string
+		want string
+		err  error
+	}{
+		{"opeth", first, nil},
+		{"opeth_2", second, nil},
+		{"opeth_sweden", first, nil},
+		{"camel", "", ErrNotFound},
+	}
+	for _, tt := range tests {
+		got, err := d.ResolveSlug("bands", tt.slug)
+		if got != tt.want || (!errors.Is(err, tt.err) && (err != nil || tt.err != nil)) {
+			t.Errorf("ResolveSlug(%s) = %s, %v; want %s, %v", tt.slug, got, err, tt.want, tt.err)
+		}
+	}
+
+	setTests := []struct {
+		slug, id string
+		err      error
+	}{
+		{"opeth", second, ErrConflict},
+		{"opeth", first, nil},
+		{"camel", "missing", ErrNotFound},
+		{"", first, ErrInvalidInput},
+	}
+	for _, tt := range setTests {
+		if err := d.SetSlug("bands", tt.slug, tt.id); !errors.Is(err, tt.err) && (err != nil || tt.err != nil) {
+			t.Errorf("SetSlug(%q, %s) error = %v, want %v", tt.slug, tt.id, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+// NewCollectionStats creates an empty statistics tracker
+func NewCollectionStats() *CollectionStats {
+	return &CollectionStats{
+		Operations:  make(map[string]int),
+		AccessTime:  make(map[string]int64),
+		RecordCount: make(map[string]int),
+		OpStats:     make(map[string]map[string]*OpStats),
+		CacheHits:   make(map[string]int),
+		CacheMisses: make(map[string]int),
+	}
+}
+
+// Record registers one operation on a collection and the time it took
+func (s *CollectionStats) Record(collection, operation string, elapsed time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.Operations[collection]++
+	s.AccessTime[collection] = time.Now().Unix()
+
+	ops, ok := s.OpStats[collection]
+	if !ok {
+		ops = make(map[string]*OpStats)
+		s.OpStats[collection] = ops
+	}
+	op, ok := ops[operation]
+	if !ok {
+		op = &OpStats{}
+		ops[operation] = op
+	}
+	op.Count++
+	op.Total += elapsed
+	if elapsed > op.Max {
+		op.Max = elapsed
+	}
+}
+
+// SetRecordCount stores the number of records in a collection
+func (s *CollectionStats) SetRecordCount(collection string, count int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.RecordCount[collection] = count
+}
+
+// RecordCache registers a document cache lookup on a collection
+func (s *CollectionStats) RecordCache(collection string, hit bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if hit {
+		s.CacheHits[collection]++
+	} else {
+		s.CacheMisses[collection]++
+	}
+}
+
+// Get returns the statistics of a single collection
+func (s *CollectionStats) Get(collection string) map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	latency := make(map[string]interface{})
+	for op, st := range s.OpStats[collection] {
+		latency[op] = *st
+	}
+
+	return map[string]interface{}{
+		"operations":   s.Operations[collection],
+		"access_time":  time.Unix(s.AccessTime[collection], 0),
+		"record_count": s.RecordCount[collection],
+		"latency":      latency,
+		"cache_hits":   s.CacheHits[collection],
+		"cache_misses": s.CacheMisses[collection],
+	}
+}
+
+// CacheCounts returns a copy of the document cache hit and miss counters
+// by collection
+func (s *CollectionStats) CacheCounts() (hits, misses map[string]int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	hits = make(map[string]int, len(s.CacheHits))
+	for c, n := range s.CacheHits {
+		hits[c] = n
+	}
+	misses = make(map[string]int, len(s.CacheMisses))
+	for c, n := range s.CacheMisses {
+		misses[c] = n
+	}
+	return hits, misses
+}
+
+// OpSample is a point-in-time copy of the stats of one operation type
+type OpSample struct {
+	Collection string
+	Operation  string
+	OpStats
+}
+
+// Samples returns a copy of the per-operation statistics, sorted by
+// collection and operation
+func (s *CollectionStats) Samples() []OpSample {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var samples []OpSample
+	for collection, ops := range s.OpStats {
+		for op, st := range ops {
+			samples = append(samples, OpSample{Collection: collection, Operation: op, OpStats: *st})
+		}
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].Collection != samples[j].Collection {
+			return samples[i].Collection < samples[j].Collection
+		}
+		return samples[i].Operation < samples[j].Operation
+	})
+	return samples
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCollectionStatsRecord(t *testing.T) {
+	s := NewCollectionStats()
+	records := []struct {
+		collection, operation string
+		elapsed               time.Duration
+	}{
+		{"bands", "write", 3 * time.Millisecond},
+		{"bands", "write", time.Millisecond},
+		{"bands", "read", 2 * time.Millisecond},
+		{"albums", "delete", 5 * time.Millisecond},
+	}
+	for _, r := range records {
+		s.Record(r.collection, r.operation, r.elapsed)
+	}
+
+	want := []OpSample{
+		{"albums", "delete", OpStats{Count: 1, Total: 5 * time.Millisecond, Max: 5 * time.Millisecond}},
+		{"bands", "read", OpStats{Count: 1, Total: 2 * time.Millisecond, Max: 2 * time.Millisecond}},
+		{"bands", "write", OpStats{Count: 2, Total: 4 * time.Millisecond, Max: 3 * time.Millisecond}},
+	}
+	if got := s.Samples(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Samples() = %+v, want %+v", got, want)
+	}
+	if got := s.Get("bands")["operations"]; got != 3 {
+		t.Errorf("operations = %v, want 3", got)
+	}
+}
+
+func TestCollectionStatsCacheCounts(t *testing.T) {
+	s := NewCollectionStats()
+	for _, hit := range []bool{true, true, false} {
+		s.RecordCache("bands", hit)
+	}
+
+	hits, misses := s.CacheCounts()
+	if hits["bands"] != 2 || misses["bands"] != 1 {
+		t.Errorf("CacheCounts() = %v, %v; want 2 hits and 1 miss", hits, misses)
+	}
+
+	// The counters returned are copies
+	hits["bands"] = 0
+	if got := s.Get("bands")["cache_hits"]; got != 2 {
+		t.Errorf("cache_hits = %v after changing the copy, want 2", got)
+	}
+}
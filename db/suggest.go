@@ -0,0 +1,77 @@
+package db
+
+import (
+	"sort"
+	"time"
+
+	"music-database/pkg/fuzzy"
+)
+
+// MinSuggestionScore is the lowest similarity reported as a suggestion
+const MinSuggestionScore = 0.5
+
+// Suggestion is a resource resembling a searched text
+type Suggestion struct {
+	ID    string
+	Value string
+	Score float64
+}
+
+// Suggest returns up to limit resources of the collection whose field
+// value, or resource ID, is most similar to text, best first. It is meant
+// for typo tolerant "did you mean" lookups.
+func (d *Driver) Suggest(collection, field, text string, limit int) (_ []Suggestion, err error) {
+	start := time.Now()
+	defer d.done("suggest", collection, "", start, &err)
+	if err := checkCollection(collection); err != nil {
+		return nil, err
+	}
+
+	resources, err := d.listResources(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make(map[string]string, len(resources))
+	for _, resource := range resources {
+		data, err := d.readDocument(collection, resource)
+		if err != nil {
+			return nil, err
+		}
+		value, _ := data[field].(string)
+		candidates[resource] = value
+	}
+
+	d.updateStats(collection, "suggest", start)
+	return RankSuggestions(text, candidates, limit), nil
+}
+
+// RankSuggestions scores candidates, given as resource ID to field value,
+// against text and returns up to limit of those scoring at least
+// MinSuggestionScore, best first. A limit of zero returns every match.
+func RankSuggestions(text string, candidates map[string]string, limit int) []Suggestion {
+	var suggestions []Suggestion
+	for id, value := range candidates {
+		score := fuzzy.Similarity(text, id)
+		if value != "" {
+			score = max(score, fuzzy.Similarity(text, value))
+		} else {
+			value = id
+		}
+
+		if score >= MinSuggestionScore {
+			suggestions = append(suggestions, Suggestion{ID: id, Value: value, Score: score})
+		}
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].ID < suggestions[j].ID
+	})
+	if limit > 0 && len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
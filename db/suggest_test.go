@@ -0,0 +1,60 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRankSuggestions(t *testing.T) {
+	candidates := map[string]string{
+		"metallica": "Metallica",
+		"megadeth":  "Megadeth",
+		"opeth":     "Opeth",
+		"ac_dc":     "",
+	}
+
+	tests := []struct {
+		text  string
+		limit int
+		want  []string
+	}{
+		{"Metalica", 0, []string{"metallica"}},
+		{"opet", 0, []string{"opeth"}},
+		{"acdc", 0, []string{"ac_dc"}},
+		{"Megadeath", 1, []string{"megadeth"}},
+		{"Camel", 0, nil},
+	}
+
+	for _, tt := range tests {
+		got := RankSuggestions(tt.text, candidates, tt.limit)
+		var ids []string
+		for i, s := range got {
+			ids = append(ids, s.ID)
+			if s.Score < MinSuggestionScore || (i > 0 && s.Score > got[i-1].Score) {
+				t.Errorf("RankSuggestions(%q) = %+v, not ranked best first above the minimum", tt.text, got)
+			}
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("RankSuggestions(%q, %d) = %v, want %v", tt.text, tt.limit, ids, tt.want)
+		}
+	}
+
+	// Without a field value, the ID is reported
+	if got := RankSuggestions("ac_dc", candidates, 1); len(got) != 1 || got[0].Value != "ac_dc" {
+		t.Errorf("RankSuggestions() = %+v, want the ID as value", got)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	d := newTestDriver(t, nil)
+	for resource, name := range map[string]string{"opeth": "Opeth", "camel": "Camel"} {
+		if err := d.Write("bands", resource, map[string]interface{}{"name": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := d.Suggest("bands", "name", "Cammel", 3)
+	if err != nil || len(got) != 1 || got[0].ID != "camel" || got[0].Value != "Camel" {
+		t.Errorf("Suggest() = %+v, %v; want camel", got, err)
+	}
+}
@@ -0,0 +1,669 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format selects the encoding used by Import and Export
+type Format struct {
+	Name string // "ndjson" or "csv"
+	// Columns maps CSV columns to document fields. When nil, Import maps
+	// every header to the field of the same name and Export derives the
+	// columns from the documents.
+	Columns []Column
+}
+
+// Supported formats
+var (
+	NDJSON = Format{Name: "ndjson"}
+	CSV    = Format{Name: "csv"}
+)
+
+// Column maps a CSV column to a document field. Field is a dotted path;
+// a "[]" suffix on one of its segments, as in "albums[].name", marks an
+// array of objects spread over several rows: one row per element, with
+// the other columns repeated. All such columns must share the same array.
+type Column struct {
+	Header string
+	Field  string
+	Type   string // "string", "number", "bool" or "json"; empty keeps the cell as a string
+}
+
+// ImportOptions configures Import
+type ImportOptions struct {
+	// KeyField is the field holding the resource name of each document.
+	// It defaults to IDField.
+	KeyField string
+	// Slug derives the resource name from the key field with Slugify, so
+	// that a band name becomes e.g. "king_crimson"
+	Slug bool
+	// GenerateKeys stores documents without a key under a new ID, written
+	// to their IDField as Insert does
+	GenerateKeys bool
+	// Overwrite replaces existing resources instead of reporting them
+	Overwrite bool
+	// DryRun derives keys and validates the documents without storing them
+	DryRun bool
+}
+
+// ImportError reports a document that could not be imported
+type ImportError struct {
+	Line int    // 1-based line of the document, or of its first CSV row
+	Key  string // Resource name, if it could be derived
+	Err  error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// ImportReport lists the outcome of an import
+type ImportReport struct {
+	Imported []string // Resources stored, in input order
+	Errors   []ImportError
+}
+
+// Export writes every document of a collection to w, sorted by resource
+// name. NDJSON writes one document per line; CSV writes a header row and
+// one row per document, or per element of the array selected by the
+// columns.
+func (d *Driver) Export(collection string, w io.Writer, format Format) (err error) {
+	start := time.Now()
+	defer d.done("export", collection, "", start, &err)
+	if err := checkCollection(collection); err != nil {
+		return err
+	}
+	if format.Name != NDJSON.Name && format.Name != CSV.Name {
+		return unknownFormat(format)
+	}
+
+	resources, err := d.listResources(collection)
+	if err != nil {
+		return err
+	}
+	sort.Strings(resources)
+
+	docs := make([]map[string]interface{}, 0, len(resources))
+	for _, resource := range resources {
+		doc, err := d.readDocument(collection, resource)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, doc)
+	}
+
+	if format.Name == NDJSON.Name {
+		err = exportNDJSON(w, docs)
+	} else {
+		err = exportCSV(w, docs, format.Columns)
+	}
+	if err != nil {
+		return err
+	}
+
+	d.updateStats(collection, "export", start)
+	return nil
+}
+
+// Import reads documents from r and stores them in a collection. Failures
+// of single documents, such as invalid lines, missing keys or rejected
+// validation, are collected in the report and do not stop the import; the
+// error is only set when the input cannot be read at all or the collection
+// reaches its limits. CSV rows sharing
+// a key are merged into one document, each row adding an element to the
+// array selected by the columns.
+func (d *Driver) Import(collection string, r io.Reader, format Format, opts *ImportOptions) (_ *ImportReport, err error) {
+	start := time.Now()
+	defer d.done("import", collection, "", start, &err)
+	if err := checkCollection(collection); err != nil {
+		return nil, err
+	}
+
+	o := ImportOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.KeyField == "" {
+		o.KeyField = IDField
+	}
+	if !o.DryRun {
+		end, err := d.BeginWrite()
+		if err != nil {
+			return nil, err
+		}
+		defer end()
+	}
+
+	var docs []importedDoc
+	report := &ImportReport{}
+	switch format.Name {
+	case NDJSON.Name:
+		docs, err = readNDJSON(r, report)
+	case CSV.Name:
+		docs, err = readCSV(r, format.Columns, &o, report)
+	default:
+		err = unknownFormat(format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var stopped error
+	for _, doc := range docs {
+		key := doc.key
+		if key == "" {
+			if key, err = importKey(doc.data, &o); err != nil {
+				report.Errors = append(report.Errors, ImportError{Line: doc.line, Err: err})
+				continue
+			}
+		}
+		if seen[key] {
+			report.Errors = append(report.Errors, ImportError{Line: doc.line, Key: key, Err: fmt.Errorf("duplicate key '%s'", key)})
+			continue
+		}
+		seen[key] = true
+
+		if err := d.importDocument(collection, key, doc.data, &o); err != nil {
+			report.Errors = append(report.Errors, ImportError{Line: doc.line, Key: key, Err: err})
+			if errors.Is(err, ErrQuotaExceeded) {
+				// Every further document would fail the same way
+				stopped = err
+				break
+			}
+			continue
+		}
+		report.Imported = append(report.Imported, key)
+	}
+
+	sort.SliceStable(report.Errors, func(i, j int) bool { return report.Errors[i].Line < report.Errors[j].Line })
+
+	if !o.DryRun {
+		d.updateStats(collection, "import", start)
+	}
+	return report, stopped
+}
+
+// importedDoc is a document read from the input with the line it starts
+// on. key is set when it was already derived.
+type importedDoc struct {
+	line int
+	key  string
+	data map[string]interface{}
+}
+
+func (d *Driver) importDocument(collection, key string, doc map[string]interface{}, opts *ImportOptions) error {
+	if err := checkNames(collection, key); err != nil {
+		return err
+	}
+
+	if !opts.DryRun {
+		if opts.Overwrite {
+			return d.Write(collection, key, doc)
+		}
+		return d.Create(collection, key, doc)
+	}
+
+	if err := d.validate(collection, doc); err != nil {
+		return err
+	}
+	if err := d.checkUnique(collection, key, doc); err != nil {
+		return err
+	}
+	if !opts.Overwrite {
+		exists, err := d.exists(collection, key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return alreadyExists(collection, key)
+		}
+	}
+	return nil
+}
+
+// importKey derives the resource name of a document
+func importKey(doc map[string]interface{}, opts *ImportOptions) (string, error) {
+	var key string
+	switch v := doc[opts.KeyField].(type) {
+	case string:
+		key = v
+	case float64:
+		key = strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+	default:
+		return "", fmt.Errorf("key field '%s' is not a string or number", opts.KeyField)
+	}
+
+	if opts.Slug {
+		key = Slugify(key)
+	}
+	if key == "" {
+		if !opts.GenerateKeys {
+			return "", fmt.Errorf("missing key field '%s'", opts.KeyField)
+		}
+		key = NewID()
+		if _, ok := doc[IDField]; !ok {
+			doc[IDField] = key
+		}
+	}
+	return key, nil
+}
+
+func readNDJSON(r io.Reader, report *ImportReport) ([]importedDoc, error) {
+	var docs []importedDoc
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		b, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, &DbError{Code: ErrCodeInternal, Message: "failed to read input", Err: err}
+		}
+
+		if b = bytes.TrimSpace(b); len(b) > 0 {
+			var doc map[string]interface{}
+			if jsonErr := json.Unmarshal(b, &doc); jsonErr != nil || doc == nil {
+				if jsonErr == nil {
+					jsonErr = errors.New("not a JSON object")
+				}
+				report.Errors = append(report.Errors, ImportError{Line: line, Err: fmt.Errorf("invalid JSON: %v", jsonErr)})
+			} else {
+				docs = append(docs, importedDoc{line: line, data: doc})
+			}
+		}
+
+		if err == io.EOF {
+			return docs, nil
+		}
+	}
+}
+
+func exportNDJSON(w io.Writer, docs []map[string]interface{}) error {
+	enc := json.NewEncoder(w)
+	for _, doc := range docs {
+		if err := enc.Encode(doc); err != nil {
+			return &DbError{Code: ErrCodeInternal, Message: "failed to write output", Err: err}
+		}
+	}
+	return nil
+}
+
+// columnPlan is a parsed list of CSV columns
+type columnPlan struct {
+	columns []Column
+	paths   [][]string // Path of each column, relative to the array element for spread columns
+	spread  []bool     // Whether each column belongs to the spread array
+	array   []string   // Path of the spread array, nil if none
+}
+
+func planColumns(columns []Column) (*columnPlan, error) {
+	plan := &columnPlan{columns: columns}
+	for _, c := range columns {
+		if c.Field == "" {
+			return nil, &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("column '%s' has no field", c.Header)}
+		}
+		switch c.Type {
+		case "", "string", "number", "bool", "json":
+		default:
+			return nil, &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("column '%s' has unknown type '%s'", c.Header, c.Type)}
+		}
+
+		var array, path []string
+		for _, segment := range strings.Split(c.Field, ".") {
+			if name, ok := strings.CutSuffix(segment, "[]"); ok {
+				if array != nil {
+					return nil, &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("field '%s' spreads more than one array", c.Field)}
+				}
+				array, path = append(path, name), nil
+				continue
+			}
+			path = append(path, segment)
+		}
+		for _, segment := range append(append([]string(nil), array...), path...) {
+			if segment == "" {
+				return nil, &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("invalid field '%s'", c.Field)}
+			}
+		}
+
+		if array != nil {
+			if plan.array != nil && strings.Join(plan.array, ".") != strings.Join(array, ".") {
+				return nil, &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("field '%s' spreads a different array than '%s[]'", c.Field, strings.Join(plan.array, "."))}
+			}
+			plan.array = array
+		}
+		plan.paths = append(plan.paths, path)
+		plan.spread = append(plan.spread, array != nil)
+	}
+	return plan, nil
+}
+
+func readCSV(r io.Reader, columns []Column, opts *ImportOptions, report *ImportReport) ([]importedDoc, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, &DbError{Code: ErrCodeInvalidInput, Message: "failed to read CSV header", Err: err}
+	}
+
+	// Map every used column of the input to its Column
+	var used []Column
+	var index []int
+	if columns == nil {
+		for i, h := range header {
+			used = append(used, Column{Header: h, Field: strings.TrimSpace(h)})
+			index = append(index, i)
+		}
+	} else {
+		for _, c := range columns {
+			i := headerIndex(header, c.Header)
+			if i < 0 {
+				return nil, &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("missing CSV column '%s'", c.Header)}
+			}
+			used = append(used, c)
+			index = append(index, i)
+		}
+	}
+
+	plan, err := planColumns(used)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []importedDoc
+	byKey := make(map[string]int)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, &DbError{Code: ErrCodeInternal, Message: "failed to read input", Err: err}
+			}
+			report.Errors = append(report.Errors, ImportError{Line: parseErr.StartLine, Err: parseErr.Err})
+			continue
+		}
+		line, _ := cr.FieldPos(0)
+
+		doc := make(map[string]interface{})
+		var element map[string]interface{}
+		var cellErr error
+		for i, c := range plan.columns {
+			if index[i] >= len(record) || record[index[i]] == "" {
+				continue
+			}
+			value, err := parseCell(record[index[i]], c.Type)
+			if err != nil {
+				cellErr = fmt.Errorf("column '%s': %v", c.Header, err)
+				break
+			}
+
+			if plan.spread[i] {
+				if element == nil {
+					element = make(map[string]interface{})
+				}
+				setPath(element, plan.paths[i], value)
+			} else {
+				setPath(doc, plan.paths[i], value)
+			}
+		}
+		if cellErr != nil {
+			report.Errors = append(report.Errors, ImportError{Line: line, Err: cellErr})
+			continue
+		}
+
+		key, err := importKey(doc, opts)
+		if err != nil {
+			report.Errors = append(report.Errors, ImportError{Line: line, Err: err})
+			continue
+		}
+
+		i, ok := byKey[key]
+		if !ok {
+			i = len(docs)
+			byKey[key] = i
+			docs = append(docs, importedDoc{line: line, key: key, data: doc})
+		} else {
+			// Rows of the same document repeat its fields; the first
+			// value of each wins
+			mergeMissing(docs[i].data, doc)
+		}
+
+		if element != nil {
+			if err := appendPath(docs[i].data, plan.array, element); err != nil {
+				report.Errors = append(report.Errors, ImportError{Line: line, Key: key, Err: err})
+			}
+		}
+	}
+}
+
+func exportCSV(w io.Writer, docs []map[string]interface{}, columns []Column) error {
+	if columns == nil {
+		columns = deriveColumns(docs)
+	}
+	plan, err := planColumns(columns)
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.Header
+	}
+	if err := cw.Write(header); err != nil {
+		return &DbError{Code: ErrCodeInternal, Message: "failed to write output", Err: err}
+	}
+
+	for _, doc := range docs {
+		// One row per array element, or a single row without any
+		elements := []interface{}{nil}
+		if plan.array != nil {
+			if arr, ok := getPath(doc, plan.array).([]interface{}); ok && len(arr) > 0 {
+				elements = arr
+			}
+		}
+
+		for _, element := range elements {
+			row := make([]string, len(columns))
+			for i := range columns {
+				var value interface{}
+				if !plan.spread[i] {
+					value = getPath(doc, plan.paths[i])
+				} else if element != nil {
+					value = getPath(element, plan.paths[i])
+				}
+				row[i] = formatCell(value)
+			}
+			if err := cw.Write(row); err != nil {
+				return &DbError{Code: ErrCodeInternal, Message: "failed to write output", Err: err}
+			}
+		}
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return &DbError{Code: ErrCodeInternal, Message: "failed to write output", Err: err}
+	}
+	return nil
+}
+
+// deriveColumns returns a column for every scalar field of the documents.
+// The first array of objects found is spread over rows; other arrays are
+// written as JSON.
+func deriveColumns(docs []map[string]interface{}) []Column {
+	var fields, spread []string
+	seen := make(map[string]bool)
+	array := ""
+
+	var walk func(obj map[string]interface{}, prefix string, inArray bool)
+	walk = func(obj map[string]interface{}, prefix string, inArray bool) {
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			field := prefix + k
+			switch v := obj[k].(type) {
+			case map[string]interface{}:
+				walk(v, field+".", inArray)
+				continue
+			case []interface{}:
+				if !inArray && isObjectArray(v) && (array == "" || array == field) {
+					array = field
+					for _, element := range v {
+						walk(element.(map[string]interface{}), field+"[].", true)
+					}
+					continue
+				}
+			}
+
+			if !seen[field] {
+				seen[field] = true
+				if inArray {
+					spread = append(spread, field)
+				} else {
+					fields = append(fields, field)
+				}
+			}
+		}
+	}
+	for _, doc := range docs {
+		walk(doc, "", false)
+	}
+
+	var columns []Column
+	for _, field := range append(fields, spread...) {
+		columns = append(columns, Column{Header: field, Field: field})
+	}
+	return columns
+}
+
+func isObjectArray(arr []interface{}) bool {
+	if len(arr) == 0 {
+		return false
+	}
+	for _, v := range arr {
+		if _, ok := v.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func headerIndex(header []string, name string) int {
+	for i, h := range header {
+		if strings.EqualFold(strings.TrimSpace(h), strings.TrimSpace(name)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseCell converts a CSV cell to a document value. Cells of a column
+// without a type are kept as strings, so "007" or "true" in a name column
+// stay as written.
+func parseCell(cell, typ string) (interface{}, error) {
+	switch typ {
+	case "number":
+		f, err := strconv.ParseFloat(strings.TrimSpace(cell), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s'", cell)
+		}
+		return f, nil
+	case "bool":
+		b, err := strconv.ParseBool(strings.TrimSpace(cell))
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean '%s'", cell)
+		}
+		return b, nil
+	case "json":
+		var v interface{}
+		if err := json.Unmarshal([]byte(cell), &v); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+		return v, nil
+	}
+	return cell, nil
+}
+
+func formatCell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func getPath(v interface{}, path []string) interface{} {
+	for _, segment := range path {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = obj[segment]
+	}
+	return v
+}
+
+func setPath(doc map[string]interface{}, path []string, value interface{}) {
+	for _, segment := range path[:len(path)-1] {
+		child, ok := doc[segment].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			doc[segment] = child
+		}
+		doc = child
+	}
+	doc[path[len(path)-1]] = value
+}
+
+func appendPath(doc map[string]interface{}, path []string, value interface{}) error {
+	arr, ok := getPath(doc, path).([]interface{})
+	if !ok && getPath(doc, path) != nil {
+		return fmt.Errorf("field '%s' is not an array", strings.Join(path, "."))
+	}
+	setPath(doc, path, append(arr, value))
+	return nil
+}
+
+// mergeMissing copies the fields of src that dst lacks, recursing into
+// nested objects
+func mergeMissing(dst, src map[string]interface{}) {
+	for k, v := range src {
+		current, ok := dst[k]
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		if c, ok := current.(map[string]interface{}); ok {
+			if s, ok := v.(map[string]interface{}); ok {
+				mergeMissing(c, s)
+			}
+		}
+	}
+}
+
+func unknownFormat(format Format) *DbError {
+	return &DbError{Code: ErrCodeInvalidInput, Message: fmt.Sprintf("unknown format '%s'", format.Name)}
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImportCSV(t *testing.T) {
+	d := newTestDriver(t, nil)
+	input := "Band,Code,Album,Year\n" +
+		"Opeth,007,Orchid,1995\n" +
+		"Opeth,007,Morningrise,1996\n" +
+		"Camel,true,Mirage,x\n"
+	format := Format{Name: "csv", Columns: []Column{
+		{Header: "Band", Field: "name"},
+		{Header: "Code", Field: "code"},
+		{Header: "Album", Field: "albums[].name"},
+		{Header: "Year", Field: "albums[].year", Type: "number"},
+	}}
+
+	report, err := d.Import("bands", strings.NewReader(input), format, &ImportOptions{KeyField: "name", Slug: true})
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if !reflect.DeepEqual(report.Imported, []string{"opeth"}) {
+		t.Errorf("Imported = %v, want [opeth]", report.Imported)
+	}
+	if len(report.Errors) != 1 || report.Errors[0].Line != 4 {
+		t.Errorf("Errors = %v, want one on line 4", report.Errors)
+	}
+
+	var doc map[string]interface{}
+	if err := d.Read("bands", "opeth", &doc); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"name": "Opeth",
+		"code": "007",
+		"albums": []interface{}{
+			map[string]interface{}{"name": "Orchid", "year": 1995.0},
+			map[string]interface{}{"name": "Morningrise", "year": 1996.0},
+		},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("imported document = %v, want %v", doc, want)
+	}
+}
+
+func TestExportImportNDJSON(t *testing.T) {
+	src := newTestDriver(t, nil)
+	docs := map[string]interface{}{
+		"opeth": map[string]interface{}{"name": "Opeth", "year": 1990.0},
+		"camel": map[string]interface{}{"name": "Camel", "tags": []interface{}{"prog"}},
+	}
+	for name, doc := range docs {
+		if err := src.Write("bands", name, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := src.Export("bands", &buf, NDJSON); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	dst := newTestDriver(t, nil)
+	report, err := dst.Import("bands", &buf, NDJSON, &ImportOptions{KeyField: "name", Slug: true})
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if len(report.Errors) > 0 {
+		t.Fatalf("Import() errors = %v", report.Errors)
+	}
+	for name, want := range docs {
+		var got map[string]interface{}
+		if err := dst.Read("bands", name, &got); err != nil {
+			t.Errorf("Read(%s) error = %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Read(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestParseCell(t *testing.T) {
+	tests := []struct {
+		cell, typ string
+		want      interface{}
+		wantErr   bool
+	}{
+		{"007", "", "007", false},
+		{"true", "", "true", false},
+		{"null", "", "null", false},
+		{"[1, 2]", "", "[1, 2]", false},
+		{"Opeth", "string", "Opeth", false},
+		{" 1971 ", "number", 1971.0, false},
+		{"x", "number", nil, true},
+		{"true", "bool", true, false},
+		{"yes", "bool", nil, true},
+		{`["a"]`, "json", []interface{}{"a"}, false},
+		{"{", "json", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseCell(tt.cell, tt.typ)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCell(%q, %q) error = %v, want error %v", tt.cell, tt.typ, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCell(%q, %q) = %#v, want %#v", tt.cell, tt.typ, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// trashDir holds the deleted documents of a collection. Its name is not a
+// hash prefix, so WalkResources and every read skip it.
+const trashDir = ".trash"
+
+// TrashEntry is a deleted document kept in the trash of its collection.
+// A resource deleted again replaces its earlier entry.
+type TrashEntry struct {
+	Resource  string          `json:"resource"`
+	DeletedAt time.Time       `json:"deleted_at"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Slugs     []string        `json:"slugs,omitempty"` // Slugs that pointed at the resource
+}
+
+// TrashPath returns the file of a resource in the trash of a collection
+// directory. The resource name must be valid.
+func TrashPath(collectionDir, resource string) string {
+	return filepath.Join(collectionDir, trashDir, resourceFile(resource))
+}
+
+// WriteTrash stores an entry in the trash of a collection directory
+func WriteTrash(collectionDir string, entry *TrashEntry) error {
+	b, err := json.MarshalIndent(entry, "", "\t")
+	if err != nil {
+		return &DbError{Code: ErrCodeInternal, Message: "failed to marshal trash entry", Err: err}
+	}
+	return writeAtomic(TrashPath(collectionDir, entry.Resource), append(b, '\n'))
+}
+
+// ReadTrash returns the trash entry of a resource, failing with
+// ErrCodeNotFound when the resource is not in the trash
+func ReadTrash(collectionDir, resource string) (*TrashEntry, error) {
+	b, err := os.ReadFile(TrashPath(collectionDir, resource))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &DbError{Code: ErrCodeNotFound, Message: fmt.Sprintf("resource '%s' not found in the trash", resource)}
+		}
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to read trash entry", Err: err}
+	}
+
+	var entry TrashEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return nil, &DbError{Code: ErrCodeInternal, Message: fmt.Sprintf("corrupt trash entry '%s'", resource), Err: err}
+	}
+	return &entry, nil
+}
+
+// ReadTrashDir returns the entries in the trash of a collection directory,
+// most recently deleted first, without their data
+func ReadTrashDir(collectionDir string) ([]TrashEntry, error) {
+	files, err := os.ReadDir(filepath.Join(collectionDir, trashDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to read trash", Err: err}
+	}
+
+	var entries []TrashEntry
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || filepath.Ext(name) != ".json" {
+			continue
+		}
+		resource, err := DecodeName(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+
+		entry, err := ReadTrash(collectionDir, resource)
+		if err != nil {
+			if isNotFound(err) {
+				// Restored or purged since the directory was read
+				continue
+			}
+			return nil, err
+		}
+		entry.Data = nil
+		entries = append(entries, *entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].DeletedAt.After(entries[j].DeletedAt) })
+	return entries, nil
+}
+
+// PurgeTrashDir removes the entries deleted at or before a time from the
+// trash of a collection directory and returns their resources
+func PurgeTrashDir(collectionDir string, before time.Time) ([]string, error) {
+	entries, err := ReadTrashDir(collectionDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var purged []string
+	for _, entry := range entries {
+		if entry.DeletedAt.After(before) {
+			continue
+		}
+		if err := os.Remove(TrashPath(collectionDir, entry.Resource)); err != nil && !os.IsNotExist(err) {
+			return purged, &DbError{Code: ErrCodeInternal, Message: "failed to purge trash entry", Err: err}
+		}
+		purged = append(purged, entry.Resource)
+	}
+	sort.Strings(purged)
+	return purged, nil
+}
+
+// trashResource moves the file of a resource to the trash of its
+// collection. Errors reading or removing the file are returned as is. The
+// caller holds the collection mutex.
+func (d *Driver) trashResource(collection, resource string) error {
+	path, err := d.resourcePath(collection, resource)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	slugs, err := d.slugsOf(collection, resource)
+	if err != nil {
+		return err
+	}
+
+	entry := &TrashEntry{Resource: resource, DeletedAt: time.Now().UTC(), Data: b, Slugs: slugs}
+	if err := WriteTrash(d.collectionDir(collection), entry); err != nil {
+		return err
+	}
+	return d.removeResource(collection, resource)
+}
+
+// Restore brings a deleted resource back from the trash of its collection,
+// with the slugs it had unless another resource took them since.
+// It fails with ErrCodeNotFound when the resource is not in the trash and
+// with ErrCodeAlreadyExists when a resource of the same name was written
+// since. The document goes through the hooks, validation and constraints
+// of a Create.
+func (d *Driver) Restore(collection, resource string) (err error) {
+	start := time.Now()
+	defer d.done("restore", collection, resource, start, &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+	if err := checkNames(collection, resource); err != nil {
+		return err
+	}
+
+	mutex := d.lockCollection(collection)
+	event, err := d.restoreLocked(collection, resource)
+	mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	d.runAfterHooks(AfterWrite, event)
+	d.updateStats(collection, "restore", start)
+	return nil
+}
+
+// restoreLocked creates a resource from its trash entry, with the slugs
+// that pointed at it and are still free, and removes the entry. The caller
+// holds the collection mutex.
+func (d *Driver) restoreLocked(collection, resource string) (*HookEvent, error) {
+	dir := d.collectionDir(collection)
+	entry, err := ReadTrash(dir, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(entry.Data, &doc); err != nil {
+		return nil, &DbError{Code: ErrCodeInternal, Message: fmt.Sprintf("corrupt trash entry '%s'", resource), Err: err}
+	}
+	event, err := d.createLocked(collection, resource, doc)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.restoreSlugsLocked(collection, resource, entry.Slugs); err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(TrashPath(dir, resource)); err != nil && !os.IsNotExist(err) {
+		return nil, &DbError{Code: ErrCodeInternal, Message: "failed to remove trash entry", Err: err}
+	}
+	return event, nil
+}
+
+// ListTrash returns the resources in the trash of a collection, most
+// recently deleted first, without their data
+func (d *Driver) ListTrash(collection string) (_ []TrashEntry, err error) {
+	defer d.done("list_trash", collection, "", time.Now(), &err)
+	if err := checkCollection(collection); err != nil {
+		return nil, err
+	}
+	return ReadTrashDir(d.collectionDir(collection))
+}
+
+// PurgeTrash permanently removes the resources deleted more than
+// olderThan ago from the trash of a collection, all of them when olderThan
+// is zero, and returns their names
+func (d *Driver) PurgeTrash(collection string, olderThan time.Duration) (_ []string, err error) {
+	start := time.Now()
+	defer d.done("purge_trash", collection, "", start, &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+	if err := checkCollection(collection); err != nil {
+		return nil, err
+	}
+
+	mutex := d.lockCollection(collection)
+	purged, err := PurgeTrashDir(d.collectionDir(collection), start.Add(-olderThan))
+	mutex.Unlock()
+	if err != nil {
+		return purged, err
+	}
+
+	d.updateStats(collection, "purge_trash", start)
+	return purged, nil
+}
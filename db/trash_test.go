@@ -0,0 +1,143 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDeleteRestore(t *testing.T) {
+	d := newTestDriver(t, &Options{Trash: true})
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete("bands", "opeth"); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]interface{}
+	if err := d.Read("bands", "opeth", &doc); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Read() of a deleted resource error = %v, want ErrNotFound", err)
+	}
+	if all, err := d.ReadAll("bands"); err != nil || len(all) != 0 {
+		t.Errorf("ReadAll() = %v, %v; want the trash skipped", all, err)
+	}
+	if entries, err := d.ListTrash("bands"); err != nil || len(entries) != 1 || entries[0].Resource != "opeth" {
+		t.Errorf("ListTrash() = %+v, %v", entries, err)
+	}
+
+	if err := d.Restore("bands", "opeth"); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if err := d.Read("bands", "opeth", &doc); err != nil || doc["name"] != "Opeth" {
+		t.Errorf("Read() after Restore() = %v, %v", doc, err)
+	}
+	if err := d.Restore("bands", "opeth"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Restore() again error = %v, want ErrNotFound", err)
+	}
+
+	// A resource written again since its delete is not overwritten
+	if err := d.Delete("bands", "opeth"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth", "year": 1990}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Restore("bands", "opeth"); !errors.Is(err, ErrConflict) {
+		t.Errorf("Restore() over a new resource error = %v, want ErrConflict", err)
+	}
+
+	if purged, err := d.PurgeTrash("bands", 0); err != nil || !reflect.DeepEqual(purged, []string{"opeth"}) {
+		t.Errorf("PurgeTrash() = %v, %v; want [opeth]", purged, err)
+	}
+}
+
+func TestTrashDir(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UTC()
+	entries := []*TrashEntry{
+		{Resource: "opeth", DeletedAt: now.Add(-2 * time.Hour), Data: []byte(`{"name": "Opeth"}`)},
+		{Resource: "camel", DeletedAt: now.Add(-time.Hour), Data: []byte(`{"name": "Camel"}`), Slugs: []string{"camel"}},
+		{Resource: "yes", DeletedAt: now, Data: []byte(`{}`)},
+	}
+	for _, entry := range entries {
+		if err := WriteTrash(dir, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listed, err := ReadTrashDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range listed {
+		if entry.Data != nil {
+			t.Errorf("ReadTrashDir() kept the data of %s", entry.Resource)
+		}
+		names = append(names, entry.Resource)
+	}
+	if want := []string{"yes", "camel", "opeth"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ReadTrashDir() = %v, want %v", names, want)
+	}
+
+	purged, err := PurgeTrashDir(dir, now.Add(-30*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"camel", "opeth"}; !reflect.DeepEqual(purged, want) {
+		t.Errorf("PurgeTrashDir() = %v, want %v", purged, want)
+	}
+	if _, err := ReadTrash(dir, "opeth"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ReadTrash() of a purged entry error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestTrashKeepsSlugs(t *testing.T) {
+	d := newTestDriver(t, &Options{Trash: true})
+	id, err := d.Insert("bands", map[string]interface{}{"name": "Opeth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetSlug("bands", "opeth_band", id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Delete("bands", id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ResolveSlug("bands", "opeth"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ResolveSlug() of a deleted resource error = %v, want ErrNotFound", err)
+	}
+	entry, err := ReadTrash(d.collectionDir("bands"), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"opeth", "opeth_band"}; !reflect.DeepEqual(entry.Slugs, want) {
+		t.Errorf("trash entry slugs = %v, want %v", entry.Slugs, want)
+	}
+
+	// A slug taken while the resource was in the trash stays with its new
+	// resource
+	other, err := d.Insert("bands", map[string]interface{}{"name": "Opeth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Restore("bands", id); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	tests := []struct {
+		slug string
+		want string
+	}{
+		{"opeth", other},
+		{"opeth_band", id},
+	}
+	for _, tt := range tests {
+		if got, err := d.ResolveSlug("bands", tt.slug); err != nil || got != tt.want {
+			t.Errorf("ResolveSlug(%s) = %s, %v; want %s", tt.slug, got, err, tt.want)
+		}
+	}
+}
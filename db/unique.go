@@ -0,0 +1,231 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// UniqueConstraint declares that no two documents of a collection may
+// have the same values for a combination of fields
+type UniqueConstraint struct {
+	Fields []string // Dotted paths, e.g. "name" and "country"
+	// IgnoreCase compares strings ignoring case and diacritics, so that
+	// "Motörhead" and "motorhead" collide
+	IgnoreCase bool
+}
+
+func (c UniqueConstraint) String() string {
+	return strings.Join(c.Fields, ", ")
+}
+
+// uniqueIndex maps the values of a constraint to the resource holding
+// them. It is only accessed with the collection mutex held.
+type uniqueIndex struct {
+	UniqueConstraint
+	owners map[string]string // Key -> resource; nil until built
+	keys   map[string]string // Resource -> key
+	lists  int               // Resources whose single field holds several values
+}
+
+// AddUnique declares a unique constraint on a collection. Documents
+// lacking one of the fields, or having it set to null, are not
+// constrained. The constraint is checked by every write under the
+// collection lock, so concurrent writers cannot both insert the same
+// values. It fails with ErrCodeUniqueViolation if stored documents
+// already violate it.
+func (d *Driver) AddUnique(collection string, c UniqueConstraint) (err error) {
+	defer d.done("add_unique", collection, "", time.Now(), &err)
+	if err := checkCollection(collection); err != nil {
+		return err
+	}
+	if len(c.Fields) == 0 {
+		return &DbError{Code: ErrCodeInvalidInput, Message: "unique constraint has no fields"}
+	}
+	for _, field := range c.Fields {
+		if field == "" {
+			return &DbError{Code: ErrCodeInvalidInput, Message: "unique constraint has an empty field"}
+		}
+	}
+
+	mutex := d.lockCollection(collection)
+	defer mutex.Unlock()
+
+	idx := &uniqueIndex{UniqueConstraint: UniqueConstraint{Fields: append([]string(nil), c.Fields...), IgnoreCase: c.IgnoreCase}}
+	if err := d.buildUniqueIndex(collection, idx); err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	if d.uniques == nil {
+		d.uniques = make(map[string][]*uniqueIndex)
+	}
+	d.uniques[collection] = append(d.uniques[collection], idx)
+	d.mutex.Unlock()
+	return nil
+}
+
+func (d *Driver) uniqueIndexes(collection string) []*uniqueIndex {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.uniques[collection]
+}
+
+// buildUniqueIndex fills an index from the stored documents. The caller
+// holds the collection mutex.
+func (d *Driver) buildUniqueIndex(collection string, idx *uniqueIndex) error {
+	resources, err := d.listResources(collection)
+	if err != nil {
+		return err
+	}
+
+	idx.owners = make(map[string]string)
+	idx.keys = make(map[string]string)
+	idx.lists = 0
+	for _, resource := range resources {
+		doc, err := d.readDocument(collection, resource)
+		if err != nil {
+			idx.owners = nil
+			return err
+		}
+
+		key, ok := idx.Key(doc)
+		if !ok {
+			continue
+		}
+		if owner, taken := idx.owners[key]; taken {
+			idx.owners = nil
+			return uniqueViolation(collection, idx, owner, resource)
+		}
+		idx.add(resource, key)
+	}
+	return nil
+}
+
+// Key returns the values of the constraint's fields in doc, encoded so
+// that equal keys violate the constraint, and false if one of them is
+// missing
+func (c UniqueConstraint) Key(doc map[string]interface{}) (string, bool) {
+	values := make([]interface{}, len(c.Fields))
+	for i, field := range c.Fields {
+		found := lookupPath(doc, field)
+		if len(found) == 0 || found[0] == nil {
+			return "", false
+		}
+
+		value := interface{}(found)
+		if len(found) == 1 {
+			value = found[0]
+		}
+		if s, ok := value.(string); ok && c.IgnoreCase {
+			value = foldText(s)
+		}
+		values[i] = value
+	}
+
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+// checkUnique rejects data if another resource holds the same values for
+// a unique constraint. The caller holds the collection mutex.
+func (d *Driver) checkUnique(collection, resource string, data interface{}) error {
+	indexes := d.uniqueIndexes(collection)
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	doc, err := toDocument(data)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range indexes {
+		if idx.owners == nil {
+			if err := d.buildUniqueIndex(collection, idx); err != nil {
+				return err
+			}
+		}
+
+		key, ok := idx.Key(doc)
+		if !ok {
+			continue
+		}
+		if owner, taken := idx.owners[key]; taken && owner != resource {
+			return uniqueViolation(collection, idx, owner, resource)
+		}
+	}
+	return nil
+}
+
+// indexUnique records the values of a stored resource. The caller holds
+// the collection mutex.
+func (d *Driver) indexUnique(collection, resource string, raw []byte) {
+	indexes := d.uniqueIndexes(collection)
+	if len(indexes) == 0 {
+		return
+	}
+
+	var doc map[string]interface{}
+	json.Unmarshal(raw, &doc)
+	for _, idx := range indexes {
+		if idx.owners == nil {
+			continue
+		}
+		idx.remove(resource)
+		if key, ok := idx.Key(doc); ok {
+			idx.add(resource, key)
+		}
+	}
+}
+
+func (d *Driver) unindexUnique(collection, resource string) {
+	for _, idx := range d.uniqueIndexes(collection) {
+		if idx.owners != nil {
+			idx.remove(resource)
+		}
+	}
+}
+
+// resetUnique discards the indexes of a collection; they are rebuilt on
+// the next write
+func (d *Driver) resetUnique(collection string) {
+	for _, idx := range d.uniqueIndexes(collection) {
+		idx.owners, idx.keys, idx.lists = nil, nil, 0
+	}
+}
+
+func (idx *uniqueIndex) add(resource, key string) {
+	idx.owners[key] = resource
+	idx.keys[resource] = key
+	if idx.listKey(key) {
+		idx.lists++
+	}
+}
+
+func (idx *uniqueIndex) remove(resource string) {
+	if key, ok := idx.keys[resource]; ok {
+		if idx.owners[key] == resource {
+			delete(idx.owners, key)
+		}
+		delete(idx.keys, resource)
+		if idx.listKey(key) {
+			idx.lists--
+		}
+	}
+}
+
+// listKey reports whether the key of a single-field index was built from
+// several values, such as the elements of an array
+func (idx *uniqueIndex) listKey(key string) bool {
+	return len(idx.Fields) == 1 && strings.HasPrefix(key, "[[")
+}
+
+func uniqueViolation(collection string, idx *uniqueIndex, owner, resource string) *DbError {
+	return &DbError{Code: ErrCodeUniqueViolation, Message: fmt.Sprintf("resource '%s' has the same %s as '%s' in collection '%s'", resource, idx, owner, collection)}
+}
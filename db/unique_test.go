@@ -0,0 +1,107 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUniqueConstraintKey(t *testing.T) {
+	nameCountry := UniqueConstraint{Fields: []string{"name", "country"}}
+	folded := UniqueConstraint{Fields: []string{"name"}, IgnoreCase: true}
+
+	tests := []struct {
+		name   string
+		c      UniqueConstraint
+		a, b   map[string]interface{}
+		same   bool
+		hasKey bool
+	}{
+		{"same values", nameCountry,
+			map[string]interface{}{"name": "Opeth", "country": "Sweden"},
+			map[string]interface{}{"name": "Opeth", "country": "Sweden", "year": 1990}, true, true},
+		{"one value differs", nameCountry,
+			map[string]interface{}{"name": "Opeth", "country": "Sweden"},
+			map[string]interface{}{"name": "Opeth", "country": "UK"}, false, true},
+		{"case differs", nameCountry,
+			map[string]interface{}{"name": "Opeth", "country": "Sweden"},
+			map[string]interface{}{"name": "opeth", "country": "Sweden"}, false, true},
+		{"case ignored", folded,
+			map[string]interface{}{"name": "Motörhead"},
+			map[string]interface{}{"name": "MOTORHEAD"}, true, true},
+		{"string and number", folded,
+			map[string]interface{}{"name": "1990"},
+			map[string]interface{}{"name": 1990}, false, true},
+		{"missing field", nameCountry,
+			map[string]interface{}{"name": "Opeth"},
+			map[string]interface{}{"name": "Opeth"}, false, false},
+		{"null field", folded,
+			map[string]interface{}{"name": nil},
+			map[string]interface{}{"name": nil}, false, false},
+	}
+
+	for _, tt := range tests {
+		ka, oka := tt.c.Key(tt.a)
+		kb, okb := tt.c.Key(tt.b)
+		if oka != tt.hasKey || okb != tt.hasKey {
+			t.Errorf("%s: Key() found = %v, %v; want %v", tt.name, oka, okb, tt.hasKey)
+			continue
+		}
+		if tt.hasKey && (ka == kb) != tt.same {
+			t.Errorf("%s: keys %s and %s, want equal %v", tt.name, ka, kb, tt.same)
+		}
+	}
+}
+
+func TestUniqueWrites(t *testing.T) {
+	d := newTestDriver(t, nil)
+	if err := d.AddUnique("bands", UniqueConstraint{Fields: []string{"name"}, IgnoreCase: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name string
+		op   func() error
+		want error
+	}{
+		{"first", func() error { return d.Write("bands", "opeth", map[string]interface{}{"name": "Opeth"}) }, nil},
+		{"same values, other resource", func() error { return d.Write("bands", "opeth2", map[string]interface{}{"name": "OPETH"}) }, ErrUniqueViolation},
+		{"rewrite of the holder", func() error { return d.Write("bands", "opeth", map[string]interface{}{"name": "opeth", "year": 1990}) }, nil},
+		{"no value", func() error { return d.Write("bands", "unnamed", map[string]interface{}{"year": 1990}) }, nil},
+		{"no value again", func() error { return d.Write("bands", "unnamed2", map[string]interface{}{"year": 1991}) }, nil},
+		{"update into a taken value", func() error { return d.Update("bands", "unnamed", map[string]interface{}{"name": "Opeth"}) }, ErrUniqueViolation},
+		{"delete frees the value", func() error { return d.Delete("bands", "opeth") }, nil},
+		{"value reused", func() error { return d.Write("bands", "opeth2", map[string]interface{}{"name": "Opeth"}) }, nil},
+	}
+	for _, step := range steps {
+		if err := step.op(); !errors.Is(err, step.want) && (err != nil || step.want != nil) {
+			t.Errorf("%s: error = %v, want %v", step.name, err, step.want)
+		}
+	}
+}
+
+func TestAddUniqueRejects(t *testing.T) {
+	d := newTestDriver(t, nil)
+	for _, resource := range []string{"a", "b"} {
+		if err := d.Write("bands", resource, map[string]interface{}{"name": "Opeth"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		c    UniqueConstraint
+		want error
+	}{
+		{UniqueConstraint{}, ErrInvalidInput},
+		{UniqueConstraint{Fields: []string{"name", ""}}, ErrInvalidInput},
+		{UniqueConstraint{Fields: []string{"name"}}, ErrUniqueViolation},
+	}
+	for _, tt := range tests {
+		if err := d.AddUnique("bands", tt.c); !errors.Is(err, tt.want) {
+			t.Errorf("AddUnique(%+v) error = %v, want %v", tt.c, err, tt.want)
+		}
+	}
+	// A rejected constraint is not kept
+	if err := d.Write("bands", "c", map[string]interface{}{"name": "Opeth"}); err != nil {
+		t.Errorf("Write() after a rejected constraint error = %v", err)
+	}
+}
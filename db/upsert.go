@@ -0,0 +1,128 @@
+package db
+
+import "time"
+
+// Create writes a new resource and fails with ErrCodeAlreadyExists when
+// the resource is already present
+func (d *Driver) Create(collection, resource string, data interface{}) (err error) {
+	start := time.Now()
+	defer d.done("create", collection, resource, start, &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+	if err := checkNames(collection, resource); err != nil {
+		return err
+	}
+
+	mutex := d.lockCollection(collection)
+	event, err := d.createLocked(collection, resource, data)
+	mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	d.runAfterHooks(AfterWrite, event)
+
+	// Update stats
+	d.updateStats(collection, "create", start)
+	return nil
+}
+
+// Upsert merges updates into an existing resource, or creates the
+// resource from updates when it does not exist yet
+func (d *Driver) Upsert(collection, resource string, updates map[string]interface{}) (err error) {
+	start := time.Now()
+	defer d.done("upsert", collection, resource, start, &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+	if err := checkNames(collection, resource); err != nil {
+		return err
+	}
+
+	mutex := d.lockCollection(collection)
+	exists, err := d.exists(collection, resource)
+	if err != nil {
+		mutex.Unlock()
+		return err
+	}
+
+	var event *HookEvent
+	if exists {
+		event, err = d.updateLocked(collection, resource, func(data map[string]interface{}) (map[string]interface{}, error) {
+			for key, value := range updates {
+				data[key] = value
+			}
+			return data, nil
+		})
+	} else {
+		event, err = d.writeLocked(collection, resource, updates)
+	}
+	mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	d.runAfterHooks(AfterWrite, event)
+	if exists {
+		d.runAfterHooks(AfterUpdate, event)
+	}
+
+	// Update stats
+	d.updateStats(collection, "upsert", start)
+	return nil
+}
+
+// ReplaceIfExists overwrites an existing resource with data and fails
+// with ErrCodeNotFound when the resource does not exist
+func (d *Driver) ReplaceIfExists(collection, resource string, data interface{}) (err error) {
+	start := time.Now()
+	defer d.done("replace", collection, resource, start, &err)
+	end, err := d.BeginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+	if err := checkNames(collection, resource); err != nil {
+		return err
+	}
+
+	mutex := d.lockCollection(collection)
+	event, err := d.replaceLocked(collection, resource, data)
+	mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	d.runAfterHooks(AfterWrite, event)
+
+	// Update stats
+	d.updateStats(collection, "replace", start)
+	return nil
+}
+
+func (d *Driver) createLocked(collection, resource string, data interface{}) (*HookEvent, error) {
+	exists, err := d.exists(collection, resource)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, alreadyExists(collection, resource)
+	}
+	return d.writeLocked(collection, resource, data)
+}
+
+func (d *Driver) replaceLocked(collection, resource string, data interface{}) (*HookEvent, error) {
+	exists, err := d.exists(collection, resource)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, notFound(collection, resource)
+	}
+	return d.writeLocked(collection, resource, data)
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCreateUpsertReplace(t *testing.T) {
+	d := newTestDriver(t, nil)
+	opeth := map[string]interface{}{"name": "Opeth"}
+
+	steps := []struct {
+		name string
+		op   func() error
+		want error
+		doc  map[string]interface{} // Stored afterwards, nil when missing
+	}{
+		{"replace missing", func() error { return d.ReplaceIfExists("bands", "opeth", opeth) }, ErrNotFound, nil},
+		{"create", func() error { return d.Create("bands", "opeth", opeth) }, nil, opeth},
+		{"create again", func() error { return d.Create("bands", "opeth", map[string]interface{}{"name": "Camel"}) }, ErrConflict, opeth},
+		{"upsert existing", func() error { return d.Upsert("bands", "opeth", map[string]interface{}{"year": 1990}) }, nil,
+			map[string]interface{}{"name": "Opeth", "year": float64(1990)}},
+		{"replace", func() error { return d.ReplaceIfExists("bands", "opeth", opeth) }, nil, opeth},
+	}
+	for _, step := range steps {
+		if err := step.op(); !errors.Is(err, step.want) && (err != nil || step.want != nil) {
+			t.Errorf("%s: error = %v, want %v", step.name, err, step.want)
+		}
+		var doc map[string]interface{}
+		err := d.Read("bands", "opeth", &doc)
+		if step.doc == nil {
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: Read() = %v, %v; want ErrNotFound", step.name, doc, err)
+			}
+		} else if err != nil || !reflect.DeepEqual(doc, step.doc) {
+			t.Errorf("%s: Read() = %v, %v; want %v", step.name, doc, err, step.doc)
+		}
+	}
+}
+
+func TestUpsertCreates(t *testing.T) {
+	d := newTestDriver(t, nil)
+	var updates int
+	d.AddHook("bands", AfterUpdate, func(*HookEvent) error {
+		updates++
+		return nil
+	})
+
+	if err := d.Upsert("bands", "camel", map[string]interface{}{"name": "Camel"}); err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := d.Read("bands", "camel", &doc); err != nil || doc["name"] != "Camel" {
+		t.Errorf("Read() = %v, %v; want the upserted document", doc, err)
+	}
+	if err := d.Upsert("bands", "camel", map[string]interface{}{"year": 1971}); err != nil {
+		t.Fatal(err)
+	}
+	if updates != 1 {
+		t.Errorf("after-update hooks ran %d times, want only for the update", updates)
+	}
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"music-database/pkg/lru"
+	"music-database/pkg/models"
+)
+
+// cacheEntry is a parsed document file. Entries are checked against the
+// file's modification time and size, so edits made outside the driver
+// are picked up.
+type cacheEntry struct {
+	band    models.Band
+	doc     map[string]interface{}
+	modTime time.Time
+	size    int64
+}
+
+// EnableCache keeps up to size parsed documents in memory. Documents are
+// dropped on writes through the driver and reloaded when their file
+// changes on disk.
+func (d *Driver) EnableCache(size int) {
+	d.cache = lru.New[string, *cacheEntry](size)
+}
+
+// load returns the parsed document stored at path. fi may be nil, in
+// which case the file is stat'ed first.
+func (d *Driver) load(collection, path string, fi os.FileInfo) (*cacheEntry, error) {
+	if d.cache == nil {
+		return readEntry(path, nil)
+	}
+
+	if fi == nil {
+		var err error
+		if fi, err = os.Stat(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if entry, ok := d.cache.Get(path); ok && entry.modTime.Equal(fi.ModTime()) && entry.size == fi.Size() {
+		d.recordCache(collection, true)
+		return entry, nil
+	}
+	d.recordCache(collection, false)
+
+	entry, err := readEntry(path, fi)
+	if err != nil {
+		return nil, err
+	}
+	d.cache.Add(path, entry)
+	return entry, nil
+}
+
+func readEntry(path string, fi os.FileInfo) (*cacheEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, &entry.band); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &entry.doc); err != nil {
+		return nil, err
+	}
+	if fi != nil {
+		entry.modTime, entry.size = fi.ModTime(), fi.Size()
+	}
+	return entry, nil
+}
+
+// bandOf returns a copy of a cached band that callers may modify
+func bandOf(entry *cacheEntry) models.Band {
+	band := entry.band
+	band.Albums = append([]models.Album(nil), band.Albums...)
+	return band
+}
+
+func (d *Driver) uncache(path string) {
+	if d.cache != nil {
+		d.cache.Remove(path)
+	}
+}
+
+func (d *Driver) recordCache(collection string, hit bool) {
+	if d.Stats != nil {
+		d.Stats.RecordCache(collection, hit)
+	}
+}
@@ -3,16 +3,63 @@ package database
 import (
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"music-database/db"
+	"music-database/pkg/lru"
 	"music-database/pkg/models"
 )
 
 type Driver struct {
-	Dir string
+	Dir   string
+	Stats *db.CollectionStats
+	// Changes, if set, records every write for followers
+	Changes *db.ChangeLog
+	// Log, if set, receives a warning for every Query and Find taking at
+	// least SlowQueryThreshold
+	Log                db.Logger
+	SlowQueryThreshold time.Duration
+	// Gate, if set, admits every write, failing them while the database
+	// is read-only or in maintenance; see db.Driver.BeginWrite
+	Gate interface{ BeginWrite() (func(), error) }
+	// Trash, if set, makes Delete move documents to the trash of their
+	// collection, from which Restore brings them back
+	Trash bool
+	cache *lru.Cache[string, *cacheEntry]
+
+	hooksMutex sync.Mutex
+	hooks      map[string]map[db.HookType][]db.HookFunc
+
+	// writes serializes the checks and writes of collections with unique
+	// constraints
+	writes  sync.Mutex
+	uniques map[string][]db.UniqueConstraint
+}
+
+// Number of suggestions attached to a NotFoundError
+const maxSuggestions = 3
+
+// NotFoundError is returned by Get for a missing document. It matches
+// os.ErrNotExist and lists documents with a similar name or id.
+type NotFoundError struct {
+	Collection  string
+	ID          string
+	Suggestions []db.Suggestion
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("'%s' not found in '%s'", e.ID, e.Collection)
+}
+
+func (e *NotFoundError) Unwrap() error {
+	return os.ErrNotExist
 }
 
 type Query struct {
@@ -22,22 +69,52 @@ type Query struct {
 }
 
 func New(dir string) (*Driver, error) {
-	return &Driver{Dir: dir}, nil
+	return &Driver{Dir: dir, Stats: db.NewCollectionStats()}, nil
 }
 
 func (d *Driver) Query(collection string, query Query) ([]models.Band, error) {
+	start := time.Now()
+	defer d.record(collection, "query", start)
+
+	examined := 0
 	var bands []models.Band
-	files, err := ioutil.ReadDir(filepath.Join(d.Dir, collection))
+	defer func() { d.logSlowQuery(collection, query.String(), start, examined, len(bands)) }()
+
+	err := d.walk(collection, func(_ string, entry *cacheEntry) error {
+		examined++
+		band := bandOf(entry)
+		if query.Field == "" || (query.Field == "genre" && strings.EqualFold(band.Genre, query.Value)) {
+			bands = append(bands, band)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
+	return bands, nil
+}
+
+// Find returns the documents of a collection selected by a statement,
+// typically parsed with db.ParseStatement
+func (d *Driver) Find(collection string, st *db.Statement) ([]models.Band, error) {
+	start := time.Now()
+	defer d.record(collection, "find", start)
+
+	var docs []map[string]interface{}
+	var bands []models.Band
+	defer func() { d.logSlowQuery(collection, st.String(), start, len(docs), len(bands)) }()
+
+	err := d.walk(collection, func(_ string, entry *cacheEntry) error {
+		docs = append(docs, entry.doc)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		data, err := ioutil.ReadFile(filepath.Join(d.Dir, collection, file.Name()))
+	for _, doc := range st.Apply(docs) {
+		data, err := json.Marshal(doc)
 		if err != nil {
 			return nil, err
 		}
@@ -46,43 +123,438 @@ func (d *Driver) Query(collection string, query Query) ([]models.Band, error) {
 		if err := json.Unmarshal(data, &band); err != nil {
 			return nil, err
 		}
-
-		if query.Field == "" || (query.Field == "genre" && strings.EqualFold(band.Genre, query.Value)) {
-			bands = append(bands, band)
-		}
+		bands = append(bands, band)
 	}
 
 	return bands, nil
 }
 
 func (d *Driver) Save(collection string, id string, data interface{}) error {
+	start := time.Now()
+	defer d.record(collection, "save", start)
+
+	end, err := d.beginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	path, stale, err := d.placement(collection, id)
+	if err != nil {
+		return err
+	}
+
+	jsonData, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	event, jsonData, err := d.beforeWrite(collection, id, jsonData)
+	if err != nil {
+		return err
+	}
+
+	if err := d.save(collection, id, path, stale, jsonData); err != nil {
+		return err
+	}
+	d.runAfterHooks(db.AfterWrite, event)
+	return nil
+}
+
+func (d *Driver) save(collection, id, path string, stale []string, jsonData []byte) error {
+	unlock, err := d.checkUnique(collection, id, jsonData)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	defer d.uncache(path)
+	if err := ioutil.WriteFile(path, jsonData, 0644); err != nil {
+		return err
+	}
+	for _, other := range stale {
+		if err := os.Remove(other); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return d.replicate(db.Change{Op: db.OpPut, Collection: collection, Resource: id, Data: jsonData})
+}
+
+// Create stores a new document and fails with an error matching
+// os.ErrExist when a document with the same id is already present
+func (d *Driver) Create(collection string, id string, data interface{}) error {
+	start := time.Now()
+	defer d.record(collection, "create", start)
+
+	end, err := d.beginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	path, _, err := d.placement(collection, id)
+	if err != nil {
+		return err
+	}
+	if existing, err := d.documentPath(collection, id); err != nil {
+		return err
+	} else if existing != path {
+		// Not moved yet by a sharding of the collection
+		if _, err := os.Lstat(existing); err == nil {
+			return &os.LinkError{Op: "link", Old: existing, New: path, Err: os.ErrExist}
+		}
+	}
+
 	jsonData, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
 		return err
 	}
+	event, jsonData, err := d.beforeWrite(collection, id, jsonData)
+	if err != nil {
+		return err
+	}
+
+	if err := d.create(collection, id, path, jsonData); err != nil {
+		return err
+	}
+	d.runAfterHooks(db.AfterWrite, event)
+	return nil
+}
+
+func (d *Driver) create(collection, id, path string, jsonData []byte) error {
+	unlock, err := d.checkUnique(collection, id, jsonData)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	dir := filepath.Join(d.Dir, collection)
+	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%s.json", id)), jsonData, 0644)
+	tmp, err := ioutil.TempFile(dir, "create.*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(jsonData); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// Linking fails if the target exists, so concurrent creates cannot
+	// overwrite each other
+	defer d.uncache(path)
+	if err := os.Link(tmp.Name(), path); err != nil {
+		return err
+	}
+	return d.replicate(db.Change{Op: db.OpPut, Collection: collection, Resource: id, Data: jsonData})
 }
 
 func (d *Driver) Delete(collection string, id string) error {
-	return os.Remove(filepath.Join(d.Dir, collection, fmt.Sprintf("%s.json", id)))
+	start := time.Now()
+	defer d.record(collection, "delete", start)
+
+	end, err := d.beginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	path, err := d.documentPath(collection, id)
+	if err != nil {
+		return err
+	}
+	event, err := d.beforeDelete(collection, id)
+	if err != nil {
+		return err
+	}
+
+	defer d.uncache(path)
+	if d.Trash {
+		if err := d.trash(collection, id, path); err != nil {
+			return err
+		}
+	} else if err := os.Remove(path); err != nil {
+		return err
+	}
+	if err := d.replicate(db.Change{Op: db.OpDelete, Collection: collection, Resource: id}); err != nil {
+		return err
+	}
+	d.runAfterHooks(db.AfterDelete, event)
+	return nil
+}
+
+// trash moves the file of a document to the trash of its collection
+func (d *Driver) trash(collection, id, path string) error {
+	jsonData, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return err
+	}
+	if err := db.WriteTrash(dir, &db.TrashEntry{Resource: id, DeletedAt: time.Now().UTC(), Data: jsonData}); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Restore brings a deleted document back from the trash of its
+// collection. It fails with an error matching os.ErrExist when a document
+// with the same id was created since.
+func (d *Driver) Restore(collection string, id string) error {
+	start := time.Now()
+	defer d.record(collection, "restore", start)
+
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return err
+	}
+	if _, err := db.EncodeName(id); err != nil {
+		return err
+	}
+	entry, err := db.ReadTrash(dir, id)
+	if err != nil {
+		return err
+	}
+
+	// Create checks the constraints, and only one of concurrent restores
+	// succeeds
+	if err := d.Create(collection, id, entry.Data); err != nil {
+		return err
+	}
+	if err := os.Remove(db.TrashPath(dir, id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ListTrash returns the documents in the trash of a collection, most
+// recently deleted first, without their data
+func (d *Driver) ListTrash(collection string) ([]db.TrashEntry, error) {
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return nil, err
+	}
+	return db.ReadTrashDir(dir)
+}
+
+// PurgeTrash permanently removes the documents deleted more than
+// olderThan ago from the trash of a collection and returns their ids
+func (d *Driver) PurgeTrash(collection string, olderThan time.Duration) ([]string, error) {
+	start := time.Now()
+	defer d.record(collection, "purge_trash", start)
+
+	end, err := d.beginWrite()
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return nil, err
+	}
+	return db.PurgeTrashDir(dir, start.Add(-olderThan))
+}
+
+// beginWrite asks Gate, if set, to admit a write
+func (d *Driver) beginWrite() (func(), error) {
+	if d.Gate == nil {
+		return func() {}, nil
+	}
+	return d.Gate.BeginWrite()
 }
 
 func (d *Driver) Get(collection string, id string) (models.Band, error) {
+	start := time.Now()
+	defer d.record(collection, "get", start)
+
 	var band models.Band
-	data, err := ioutil.ReadFile(filepath.Join(d.Dir, collection, fmt.Sprintf("%s.json", id)))
+	path, err := d.documentPath(collection, id)
 	if err != nil {
 		return band, err
 	}
 
-	if err := json.Unmarshal(data, &band); err != nil {
+	entry, err := d.load(collection, path, nil)
+	if err != nil {
+		if os.IsNotExist(err) {
+			suggestions, _ := d.Suggest(collection, id, maxSuggestions)
+			return band, &NotFoundError{Collection: collection, ID: id, Suggestions: suggestions}
+		}
 		return band, err
 	}
 
-	return band, nil
+	return bandOf(entry), nil
+}
+
+// Suggest returns up to limit documents whose name or id is most similar
+// to text, best first
+func (d *Driver) Suggest(collection string, text string, limit int) ([]db.Suggestion, error) {
+	candidates := make(map[string]string)
+	err := d.walk(collection, func(id string, entry *cacheEntry) error {
+		candidates[id] = entry.band.Name
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return db.RankSuggestions(text, candidates, limit), nil
+}
+
+// Counts returns the number of documents in every collection
+func (d *Driver) Counts() (map[string]int, error) {
+	entries, err := ioutil.ReadDir(d.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		collection, err := db.DecodeName(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		count := 0
+		err = db.WalkResources(filepath.Join(d.Dir, entry.Name()), func(string, string, fs.DirEntry) error {
+			count++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		counts[collection] = count
+	}
+
+	return counts, nil
+}
+
+// collectionDir returns the directory of a collection, rejecting names
+// that could escape the data directory
+func (d *Driver) collectionDir(collection string) (string, error) {
+	key, err := db.EncodeName(collection)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d.Dir, key), nil
+}
+
+// documentPath returns the file of a document, wherever it is while its
+// collection is being sharded, rejecting names that could escape the
+// collection directory
+func (d *Driver) documentPath(collection string, id string) (string, error) {
+	dir, layout, err := d.layout(collection, id)
+	if err != nil {
+		return "", err
+	}
+	return layout.Locate(dir, id), nil
+}
+
+// placement returns where a document is written under the layout of its
+// collection, and the paths of copies to remove once it is
+func (d *Driver) placement(collection string, id string) (string, []string, error) {
+	dir, layout, err := d.layout(collection, id)
+	if err != nil {
+		return "", nil, err
+	}
+	if layout.Levels == 0 {
+		return layout.Path(dir, id), nil, nil
+	}
+	return layout.Path(dir, id), layout.Others(dir, id), nil
+}
+
+func (d *Driver) layout(collection string, id string) (string, db.Layout, error) {
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return "", db.Layout{}, err
+	}
+	if _, err := db.EncodeName(id); err != nil {
+		return "", db.Layout{}, err
+	}
+
+	layout, err := db.ReadLayout(dir)
+	if err != nil {
+		return "", db.Layout{}, err
+	}
+	return dir, layout, nil
+}
+
+// walk calls fn with every document of a collection, in the order of
+// their file names
+func (d *Driver) walk(collection string, fn func(id string, entry *cacheEntry) error) error {
+	dir, err := d.collectionDir(collection)
+	if err != nil {
+		return err
+	}
+
+	return db.WalkResources(dir, func(id, path string, file fs.DirEntry) error {
+		fi, err := file.Info()
+		if os.IsNotExist(err) {
+			// Moved by a concurrent sharding
+			if path, err = d.documentPath(collection, id); err != nil {
+				return err
+			}
+			fi = nil
+		} else if err != nil {
+			return err
+		}
+
+		entry, err := d.load(collection, path, fi)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		return fn(id, entry)
+	})
+}
+
+func (d *Driver) replicate(change db.Change) error {
+	if d.Changes == nil {
+		return nil
+	}
+	_, err := d.Changes.Append(change)
+	return err
+}
+
+func (q Query) String() string {
+	if q.Field == "" {
+		return "all"
+	}
+	return fmt.Sprintf("%s %s %q", q.Field, q.Operator, q.Value)
+}
+
+// logSlowQuery warns about a query that took SlowQueryThreshold or more.
+// Queries always scan the whole collection.
+func (d *Driver) logSlowQuery(collection, query string, start time.Time, examined, returned int) {
+	elapsed := time.Since(start)
+	if d.Log == nil || d.SlowQueryThreshold <= 0 || elapsed < d.SlowQueryThreshold {
+		return
+	}
+	db.LogEvent(d.Log, slog.LevelWarn, "Slow query", slog.String("op", "query"), slog.String("collection", collection),
+		slog.String("query", query), slog.String("plan", "full scan"),
+		slog.Int("examined", examined), slog.Int("returned", returned), slog.Duration("duration", elapsed))
+}
+
+func (d *Driver) record(collection, operation string, start time.Time) {
+	if d.Stats != nil {
+		d.Stats.Record(collection, operation, time.Since(start))
+	}
 }
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jcelliott/lumber"
+
+	"music-database/db"
+	"music-database/pkg/models"
+)
+
+// newTestDriver opens a driver on a fresh directory
+func newTestDriver(t *testing.T) *Driver {
+	t.Helper()
+	d, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestShardedCollection(t *testing.T) {
+	d := newTestDriver(t)
+	for _, name := range []string{"Opeth", "Camel", "Yes"} {
+		if err := d.Save("bands", strings.ToLower(name), models.Band{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err := db.New(d.Dir, &db.Options{Logger: lumber.NewConsoleLogger(lumber.ERROR)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ShardCollection("bands", &db.ShardOptions{Levels: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if band, err := d.Get("bands", "opeth"); err != nil || band.Name != "Opeth" {
+		t.Errorf("Get() of a sharded document = %+v, %v", band, err)
+	}
+	if err := d.Create("bands", "camel", models.Band{Name: "Camel"}); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Create() of a sharded document error = %v, want os.ErrExist", err)
+	}
+	if err := d.Save("bands", "genesis", models.Band{Name: "Genesis"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete("bands", "yes"); err != nil {
+		t.Fatal(err)
+	}
+
+	bands, err := d.Query("bands", Query{})
+	if err != nil || len(bands) != 3 {
+		t.Errorf("Query() = %v, %v; want 3 bands", bands, err)
+	}
+	if counts, err := d.Counts(); err != nil || counts["bands"] != 3 {
+		t.Errorf("Counts() = %v, %v; want 3 bands", counts, err)
+	}
+	var doc map[string]interface{}
+	if err := store.Read("bands", "genesis", &doc); err != nil || doc["name"] != "Genesis" {
+		t.Errorf("db.Driver Read() of a document saved after sharding = %v, %v", doc, err)
+	}
+}
+
+func TestGateRejectsWrites(t *testing.T) {
+	d := newTestDriver(t)
+	store, err := db.New(d.Dir, &db.Options{Logger: lumber.NewConsoleLogger(lumber.ERROR)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Gate = store
+	if err := d.Save("bands", "opeth", models.Band{Name: "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.StartMaintenance(context.Background(), "backup"); err != nil {
+		t.Fatal(err)
+	}
+	writes := map[string]func() error{
+		"Save":   func() error { return d.Save("bands", "opeth", models.Band{Name: "Opeth", Year: 1990}) },
+		"Create": func() error { return d.Create("bands", "camel", models.Band{Name: "Camel"}) },
+		"Delete": func() error { return d.Delete("bands", "opeth") },
+	}
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, db.ErrUnavailable) {
+			t.Errorf("%s() during maintenance error = %v, want db.ErrUnavailable", name, err)
+		}
+	}
+	if band, err := d.Get("bands", "opeth"); err != nil || band.Year != 0 {
+		t.Errorf("Get() during maintenance = %+v, %v; want the band unchanged", band, err)
+	}
+
+	store.EndMaintenance()
+	if err := d.Delete("bands", "opeth"); err != nil {
+		t.Errorf("Delete() after maintenance error = %v", err)
+	}
+}
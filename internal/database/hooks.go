@@ -0,0 +1,141 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"music-database/db"
+)
+
+// AddHook registers a hook for a collection, as db.Driver.AddHook does.
+// Save and Create run the write hooks and Delete the delete hooks; the
+// driver has no Update, so AfterUpdate hooks never run.
+func (d *Driver) AddHook(collection string, hookType db.HookType, hook db.HookFunc) {
+	d.hooksMutex.Lock()
+	defer d.hooksMutex.Unlock()
+
+	if d.hooks == nil {
+		d.hooks = make(map[string]map[db.HookType][]db.HookFunc)
+	}
+	if d.hooks[collection] == nil {
+		d.hooks[collection] = make(map[db.HookType][]db.HookFunc)
+	}
+	d.hooks[collection][hookType] = append(d.hooks[collection][hookType], hook)
+}
+
+func (d *Driver) hooksFor(collection string, hookType db.HookType) []db.HookFunc {
+	d.hooksMutex.Lock()
+	defer d.hooksMutex.Unlock()
+
+	return d.hooks[collection][hookType]
+}
+
+func (d *Driver) hasHooks(collection string) bool {
+	d.hooksMutex.Lock()
+	defer d.hooksMutex.Unlock()
+
+	return len(d.hooks[collection]) > 0
+}
+
+// beforeWrite runs the BeforeWrite hooks of a collection on a document
+// about to be stored and returns the document they left. The event is nil
+// when the collection has no hooks.
+func (d *Driver) beforeWrite(collection, id string, jsonData []byte) (*db.HookEvent, []byte, error) {
+	if !d.hasHooks(collection) {
+		return nil, jsonData, nil
+	}
+
+	event, err := d.hookEvent(collection, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(jsonData, &event.New); err != nil {
+		return nil, nil, err
+	}
+	if err := d.runBeforeHooks(db.BeforeWrite, event); err != nil {
+		return nil, nil, err
+	}
+
+	jsonData, err = json.MarshalIndent(event.New, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	return event, jsonData, nil
+}
+
+// beforeDelete runs the BeforeDelete hooks of a collection on a stored
+// document. The event is nil when the collection has no hooks.
+func (d *Driver) beforeDelete(collection, id string) (*db.HookEvent, error) {
+	if !d.hasHooks(collection) {
+		return nil, nil
+	}
+
+	event, err := d.hookEvent(collection, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.runBeforeHooks(db.BeforeDelete, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// hookEvent returns the event of a change to a document, with its
+// stored version if it exists
+func (d *Driver) hookEvent(collection, id string) (*db.HookEvent, error) {
+	path, err := d.documentPath(collection, id)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &db.HookEvent{Collection: collection, Resource: id}
+	entry, err := d.load(collection, path, nil)
+	switch {
+	case err == nil:
+		// Hooks get their own copy of the cached document
+		data, err := json.Marshal(entry.doc)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &event.Old); err != nil {
+			return nil, err
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+	return event, nil
+}
+
+func (d *Driver) runBeforeHooks(hookType db.HookType, event *db.HookEvent) error {
+	event.Type = hookType
+	for _, hook := range d.hooksFor(event.Collection, hookType) {
+		if err := hook(event); err != nil {
+			var dbErr *db.DbError
+			if errors.As(err, &dbErr) {
+				return err
+			}
+			return &db.DbError{Code: db.ErrCodeInvalidInput, Message: fmt.Sprintf("rejected by %s hook", hookType), Err: err}
+		}
+	}
+	return nil
+}
+
+// runAfterHooks runs the hooks of a completed change, logging their
+// errors. It does nothing for a nil event.
+func (d *Driver) runAfterHooks(hookType db.HookType, event *db.HookEvent) {
+	if event == nil {
+		return
+	}
+
+	e := *event
+	e.Type = hookType
+	for _, hook := range d.hooksFor(e.Collection, hookType) {
+		if err := hook(&e); err != nil && d.Log != nil {
+			db.LogEvent(d.Log, slog.LevelError, "Hook failed", slog.String("hook", hookType.String()),
+				slog.String("collection", e.Collection), slog.String("resource", e.Resource), slog.String("error", err.Error()))
+		}
+	}
+}
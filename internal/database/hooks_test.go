@@ -0,0 +1,84 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"music-database/db"
+	"music-database/pkg/models"
+)
+
+func TestHooks(t *testing.T) {
+	d := newTestDriver(t)
+	d.EnableCache(16)
+
+	var events []db.HookEvent
+	record := func(e *db.HookEvent) error {
+		events = append(events, *e)
+		return nil
+	}
+	d.AddHook("bands", db.BeforeWrite, func(e *db.HookEvent) error {
+		if e.New["country"] == "UK" {
+			e.New["country"] = "United Kingdom"
+		}
+		if e.New["name"] == "" {
+			return errors.New("a band needs a name")
+		}
+		return nil
+	})
+	d.AddHook("bands", db.AfterWrite, record)
+	d.AddHook("bands", db.BeforeDelete, func(e *db.HookEvent) error {
+		if e.Old["name"] == "Genesis" {
+			return &db.DbError{Code: db.ErrCodeReferenceViolation, Message: "not Genesis"}
+		}
+		return nil
+	})
+	d.AddHook("bands", db.AfterDelete, record)
+
+	if err := d.Create("bands", "camel", models.Band{Name: "Camel", Country: "UK"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Save("bands", "camel", models.Band{Name: "Camel", Country: "UK", Year: 1971}); err != nil {
+		t.Fatal(err)
+	}
+	if band, _ := d.Get("bands", "camel"); band.Country != "United Kingdom" || band.Year != 1971 {
+		t.Errorf("Get() = %+v, want the country normalized by the hook", band)
+	}
+
+	err := d.Save("bands", "nameless", models.Band{})
+	if !errors.Is(err, db.ErrInvalidInput) {
+		t.Errorf("Save() rejected by a hook error = %v, want ErrInvalidInput", err)
+	}
+	if _, err := d.Get("bands", "nameless"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a document rejected by a hook was stored: %v", err)
+	}
+
+	if err := d.Save("bands", "genesis", models.Band{Name: "Genesis"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete("bands", "genesis"); !errors.Is(err, db.ErrReferenceViolation) {
+		t.Errorf("Delete() rejected by a hook error = %v, want ErrReferenceViolation", err)
+	}
+	if err := d.Delete("bands", "camel"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 4 {
+		t.Fatalf("after-hook events = %+v, want 4", events)
+	}
+	if e := events[0]; e.Type != db.AfterWrite || e.Old != nil || e.New["country"] != "United Kingdom" {
+		t.Errorf("event of Create = %+v", e)
+	}
+	if e := events[1]; e.Old["year"] != float64(0) || e.New["year"] != float64(1971) {
+		t.Errorf("event of Save = %+v, want the old and new versions", e)
+	}
+	if e := events[3]; e.Type != db.AfterDelete || e.Resource != "camel" || e.Old["name"] != "Camel" || e.New != nil {
+		t.Errorf("event of Delete = %+v", e)
+	}
+
+	// Other collections are left alone
+	if err := d.Save("albums", "mirage", models.Band{Country: "UK"}); err != nil {
+		t.Errorf("Save() in a collection without hooks error = %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"music-database/db"
+)
+
+// AddUnique declares that no two documents of a collection may have the
+// same values for the fields of c. Save and Create then fail with a
+// db.DbError of code db.ErrCodeUniqueViolation.
+func (d *Driver) AddUnique(collection string, c db.UniqueConstraint) {
+	d.writes.Lock()
+	defer d.writes.Unlock()
+
+	if d.uniques == nil {
+		d.uniques = make(map[string][]db.UniqueConstraint)
+	}
+	d.uniques[collection] = append(d.uniques[collection], c)
+}
+
+// checkUnique rejects a document whose constrained values are held by
+// another document of the collection. On success it returns with the
+// write mutex held, so that concurrent writes cannot insert the same
+// values; the caller releases it with unlock once the document is stored.
+func (d *Driver) checkUnique(collection, id string, jsonData []byte) (unlock func(), err error) {
+	d.writes.Lock()
+	constraints := d.uniques[collection]
+	if len(constraints) == 0 {
+		d.writes.Unlock()
+		return func() {}, nil
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(jsonData, &doc); err != nil {
+		d.writes.Unlock()
+		return nil, err
+	}
+
+	if err := d.findDuplicate(collection, id, doc, constraints); err != nil {
+		d.writes.Unlock()
+		return nil, err
+	}
+	return d.writes.Unlock, nil
+}
+
+func (d *Driver) findDuplicate(collection, id string, doc map[string]interface{}, constraints []db.UniqueConstraint) error {
+	for _, c := range constraints {
+		key, ok := c.Key(doc)
+		if !ok {
+			continue
+		}
+
+		err := d.walk(collection, func(other string, entry *cacheEntry) error {
+			if other == id {
+				return nil
+			}
+			if otherKey, ok := c.Key(entry.doc); ok && otherKey == key {
+				return &db.DbError{Code: db.ErrCodeUniqueViolation, Message: fmt.Sprintf("'%s' has the same %s as '%s' in '%s'", id, c, other, collection)}
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"music-database/db"
+	"music-database/pkg/models"
+)
+
+func TestUniqueSaveAndCreate(t *testing.T) {
+	d := newTestDriver(t)
+	d.AddUnique("bands", db.UniqueConstraint{Fields: []string{"name"}, IgnoreCase: true})
+
+	steps := []struct {
+		name string
+		op   func() error
+		want int // Error code, 0 on success
+	}{
+		{"save", func() error { return d.Save("bands", "opeth", models.Band{Name: "Opeth"}) }, 0},
+		{"save the holder again", func() error { return d.Save("bands", "opeth", models.Band{Name: "OPETH", Year: 1990}) }, 0},
+		{"save a duplicate", func() error { return d.Save("bands", "opeth2", models.Band{Name: "opeth"}) }, db.ErrCodeUniqueViolation},
+		{"create a duplicate", func() error { return d.Create("bands", "opeth3", models.Band{Name: "Opeth"}) }, db.ErrCodeUniqueViolation},
+		{"create another name", func() error { return d.Create("bands", "camel", models.Band{Name: "Camel"}) }, 0},
+		{"delete frees the name", func() error { return d.Delete("bands", "opeth") }, 0},
+		{"name reused", func() error { return d.Create("bands", "opeth2", models.Band{Name: "Opeth"}) }, 0},
+	}
+	for _, step := range steps {
+		err := step.op()
+		code := 0
+		var dbErr *db.DbError
+		if errors.As(err, &dbErr) {
+			code = dbErr.Code
+		} else if err != nil {
+			code = -1
+		}
+		if code != step.want {
+			t.Errorf("%s: error = %v, want code %d", step.name, err, step.want)
+		}
+	}
+
+	if _, err := d.Get("bands", "opeth3"); err == nil {
+		t.Error("a rejected document was stored")
+	}
+}
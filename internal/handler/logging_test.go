@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLogRequests(t *testing.T) {
+	tests := []struct {
+		status int
+		level  string
+	}{
+		{http.StatusOK, "INFO"},
+		{http.StatusNotFound, "WARN"},
+		{http.StatusInternalServerError, "ERROR"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewJSONHandler(&buf, nil))
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte("hello"))
+		})
+
+		w := httptest.NewRecorder()
+		LogRequests(logger, next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/bands?q=x", nil))
+
+		var entry map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("log output %q: %v", buf.String(), err)
+		}
+		if entry["level"] != tt.level || entry["method"] != "GET" || entry["path"] != "/bands" ||
+			entry["status"] != float64(tt.status) || entry["size"] != float64(5) {
+			t.Errorf("status %d logged as %v", tt.status, entry)
+		}
+		if w.Code != tt.status || w.Body.String() != "hello" {
+			t.Errorf("response = %d %q, want %d hello", w.Code, w.Body, tt.status)
+		}
+	}
+}
+
+func TestResponseRecorderFlushes(t *testing.T) {
+	w := httptest.NewRecorder()
+	rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+	var _ http.Flusher = rec
+
+	rec.Flush()
+	if !w.Flushed {
+		t.Error("Flush() did not reach the underlying writer")
+	}
+}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
@@ -11,6 +12,7 @@ import (
 	"strconv"
 	"strings"
 
+	"music-database/db"
 	"music-database/internal/database"
 	"music-database/pkg/models"
 )
@@ -28,11 +30,49 @@ func formatBandName(name string) string {
 	return strings.ToLower(strings.ReplaceAll(name, " ", "_"))
 }
 
-func NewServer(database *database.Driver) *Server {
-	funcMap := template.FuncMap{
-		"formatBandName": formatBandName,
+// Seconds a client is asked to wait before retrying a write rejected
+// during maintenance
+const retryAfterSeconds = 30
+
+// storageError replies with the HTTP status matching a database error
+func storageError(w http.ResponseWriter, err error) {
+	var notFound *database.NotFoundError
+	switch {
+	case errors.As(err, &notFound) && len(notFound.Suggestions) > 0:
+		var names []string
+		for _, s := range notFound.Suggestions {
+			names = append(names, s.Value)
+		}
+		http.Error(w, "Band not found. Did you mean: "+strings.Join(names, ", ")+"?", http.StatusNotFound)
+	case errors.Is(err, db.ErrNotFound), errors.Is(err, os.ErrNotExist):
+		http.Error(w, "Band not found", http.StatusNotFound)
+	default:
+		if db.IsRetryable(err) {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+		}
+		http.Error(w, err.Error(), db.HTTPStatus(err))
 	}
-	
+}
+
+// sortAlbums is a BeforeWrite hook keeping the albums of a band in
+// release order
+func sortAlbums(e *db.HookEvent) error {
+	albums, ok := e.New["albums"].([]interface{})
+	if !ok {
+		return nil
+	}
+	year := func(i int) float64 {
+		album, _ := albums[i].(map[string]interface{})
+		y, _ := album["year"].(float64)
+		return y
+	}
+	sort.SliceStable(albums, func(i, j int) bool {
+		return year(i) < year(j)
+	})
+	return nil
+}
+
+func NewServer(database *database.Driver) *Server {
 	// Get absolute path to templates
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -40,8 +80,19 @@ func NewServer(database *database.Driver) *Server {
 	}
 	templatePath := filepath.Join(cwd, "templates", "*.html")
 	
+	return newServer(database, templatePath)
+}
+
+// newServer serves database with the templates matching pattern
+func newServer(database *database.Driver, pattern string) *Server {
+	database.AddHook("bands", db.BeforeWrite, sortAlbums)
+
+	funcMap := template.FuncMap{
+		"formatBandName": formatBandName,
+	}
+	
 	templates := template.New("").Funcs(funcMap)
-	templates = template.Must(templates.ParseGlob(templatePath))
+	templates = template.Must(templates.ParseGlob(pattern))
 	return &Server{
 		db:        database,
 		templates: templates,
@@ -86,8 +137,12 @@ func (s *Server) HandleAddBand(w http.ResponseWriter, r *http.Request) {
 	}
 	band.Year = year
 
-	if err := s.db.Save("bands", formatBandName(band.Name), band); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := s.db.Create("bands", formatBandName(band.Name), band); err != nil {
+		if errors.Is(err, os.ErrExist) || errors.Is(err, db.ErrUniqueViolation) {
+			http.Error(w, "Band already exists", http.StatusConflict)
+			return
+		}
+		storageError(w, err)
 		return
 	}
 
@@ -106,7 +161,18 @@ func (s *Server) HandleAddBand(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) HandleBands(w http.ResponseWriter, r *http.Request) {
-	results, err := s.db.Query("bands", database.Query{})
+	var results []models.Band
+	var err error
+	if q := r.URL.Query().Get("q"); q != "" {
+		st, parseErr := db.ParseStatement(q)
+		if parseErr != nil {
+			http.Error(w, "Invalid query: "+parseErr.Error(), http.StatusBadRequest)
+			return
+		}
+		results, err = s.db.Find("bands", st)
+	} else {
+		results, err = s.db.Query("bands", database.Query{})
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -115,6 +181,18 @@ func (s *Server) HandleBands(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(results)
 }
 
+// HandleTrash lists the deleted bands that can still be restored, most
+// recently deleted first
+func (s *Server) HandleTrash(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.db.ListTrash("bands")
+	if err != nil {
+		storageError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(entries)
+}
+
 func (s *Server) HandleDeleteBand(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(r.URL.Path, "/")
 	if len(parts) < 3 {
@@ -127,7 +205,7 @@ func (s *Server) HandleDeleteBand(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodDelete {
 		// Handle band deletion
 		if err := s.db.Delete("bands", formatBandName(bandName)); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			storageError(w, err)
 			return
 		}
 
@@ -142,6 +220,32 @@ func (s *Server) HandleDeleteBand(w http.ResponseWriter, r *http.Request) {
 			Bands: results,
 		}
 
+		s.templates.ExecuteTemplate(w, "bands", data)
+	} else if r.Method == http.MethodPost && len(parts) == 4 && parts[3] == "restore" {
+		// Bring a deleted band back from the trash
+		if err := s.db.Restore("bands", formatBandName(bandName)); err != nil {
+			if errors.Is(err, os.ErrExist) || errors.Is(err, db.ErrUniqueViolation) {
+				http.Error(w, "Band already exists", http.StatusConflict)
+				return
+			}
+			if errors.Is(err, db.ErrNotFound) {
+				http.Error(w, "Band not found in the trash", http.StatusNotFound)
+				return
+			}
+			storageError(w, err)
+			return
+		}
+
+		results, err := s.db.Query("bands", database.Query{})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data := PageData{
+			Bands: results,
+		}
+
 		s.templates.ExecuteTemplate(w, "bands", data)
 	} else if r.Method == http.MethodPost && len(parts) == 4 && parts[3] == "albums" {
 		// Handle album addition
@@ -165,19 +269,16 @@ func (s *Server) HandleDeleteBand(w http.ResponseWriter, r *http.Request) {
 		// Get the band
 		band, err := s.db.Get("bands", bandName)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			storageError(w, err)
 			return
 		}
 
 		// Add the album
 		band.Albums = append(band.Albums, album)
-		sort.Slice(band.Albums, func(i, j int) bool {
-			return band.Albums[i].Year < band.Albums[j].Year
-		})
 
 		// Save the updated band
 		if err := s.db.Save("bands", bandName, band); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			storageError(w, err)
 			return
 		}
 
@@ -231,19 +332,16 @@ func (s *Server) HandleAddAlbum(w http.ResponseWriter, r *http.Request) {
 	// Get the band
 	band, err := s.db.Get("bands", bandName)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		storageError(w, err)
 		return
 	}
 
 	// Add the album
 	band.Albums = append(band.Albums, album)
-	sort.Slice(band.Albums, func(i, j int) bool {
-		return band.Albums[i].Year < band.Albums[j].Year
-	})
 
 	// Save the updated band
 	if err := s.db.Save("bands", bandName, band); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		storageError(w, err)
 		return
 	}
 
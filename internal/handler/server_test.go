@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"music-database/db"
+	"music-database/internal/database"
+	"music-database/pkg/models"
+)
+
+// newTestServer serves a fresh database with the templates of the
+// repository
+func newTestServer(t *testing.T) (*Server, *database.Driver) {
+	t.Helper()
+	d, err := database.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newServer(d, "../../templates/*.html"), d
+}
+
+func postForm(handler http.HandlerFunc, target string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestHandleAddBandConflicts(t *testing.T) {
+	s, d := newTestServer(t)
+	d.AddUnique("bands", db.UniqueConstraint{Fields: []string{"name", "country"}, IgnoreCase: true})
+	if err := d.Save("bands", "opeth_se", models.Band{Name: "Opeth", Country: "Sweden"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		form url.Values
+		want int
+	}{
+		{"new band", url.Values{"name": {"Camel"}, "country": {"UK"}, "year": {"1971"}}, http.StatusOK},
+		{"same resource", url.Values{"name": {"Camel"}, "country": {"UK"}, "year": {"1971"}}, http.StatusConflict},
+		{"same name and country", url.Values{"name": {"OPETH"}, "country": {"sweden"}, "year": {"1990"}}, http.StatusConflict},
+		{"invalid year", url.Values{"name": {"Katatonia"}, "year": {"x"}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := postForm(s.HandleAddBand, "/bands", tt.form); w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d (%s)", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+
+	if _, err := d.Get("bands", "opeth"); err == nil {
+		t.Error("a band rejected by the unique constraint was stored")
+	}
+}
+
+func TestStorageError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		retryAfter string
+	}{
+		{"invalid", &db.DbError{Code: db.ErrCodeInvalidInput, Message: "bad name"}, http.StatusBadRequest, ""},
+		{"missing", os.ErrNotExist, http.StatusNotFound, ""},
+		{"maintenance", db.ErrUnavailable, http.StatusServiceUnavailable, "30"},
+		{"read-only", db.ErrReadOnly, http.StatusForbidden, ""},
+		{"other", errors.New("disk on fire"), http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		storageError(w, tt.err)
+		if w.Code != tt.status || w.Header().Get("Retry-After") != tt.retryAfter {
+			t.Errorf("%s: status = %d, Retry-After %q; want %d, %q", tt.name, w.Code, w.Header().Get("Retry-After"), tt.status, tt.retryAfter)
+		}
+	}
+}
+
+func TestDeleteAndRestoreBand(t *testing.T) {
+	s, d := newTestServer(t)
+	d.Trash = true
+	if err := d.Save("bands", "opeth", models.Band{Name: "Opeth"}); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		method, target string
+		want           int
+	}{
+		{http.MethodDelete, "/bands/opeth", http.StatusOK},
+		{http.MethodDelete, "/bands/opeth", http.StatusNotFound},
+		{http.MethodPost, "/bands/opeth/restore", http.StatusOK},
+		{http.MethodPost, "/bands/opeth/restore", http.StatusNotFound},
+		{http.MethodPost, "/bands/camel/restore", http.StatusNotFound},
+	}
+	for _, step := range steps {
+		w := httptest.NewRecorder()
+		s.HandleDeleteBand(w, httptest.NewRequest(step.method, step.target, nil))
+		if w.Code != step.want {
+			t.Errorf("%s %s = %d, want %d (%s)", step.method, step.target, w.Code, step.want, w.Body)
+		}
+	}
+	if band, err := d.Get("bands", "opeth"); err != nil || band.Name != "Opeth" {
+		t.Errorf("Get() after restoring = %+v, %v", band, err)
+	}
+
+	// A band added again since its delete is not overwritten
+	if err := d.Delete("bands", "opeth"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Save("bands", "opeth", models.Band{Name: "Opeth", Year: 1990}); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.HandleDeleteBand(w, httptest.NewRequest(http.MethodPost, "/bands/opeth/restore", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("restore over a new band = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	w = httptest.NewRecorder()
+	s.HandleTrash(w, httptest.NewRequest(http.MethodGet, "/trash", nil))
+	var entries []db.TrashEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil || len(entries) != 1 || entries[0].Resource != "opeth" {
+		t.Errorf("HandleTrash() = %s, %v", w.Body, err)
+	}
+}
+
+func TestAlbumsStayInReleaseOrder(t *testing.T) {
+	s, d := newTestServer(t)
+	if err := d.Save("bands", "camel", models.Band{Name: "Camel", Albums: []models.Album{{Name: "Moonmadness", Year: 1976}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	adds := []struct {
+		handler http.HandlerFunc
+		target  string
+		album   string
+		year    string
+	}{
+		{s.HandleAddAlbum, "/add-album/camel/albums", "Mirage", "1974"},
+		{s.HandleDeleteBand, "/bands/camel/albums", "Rain Dances", "1977"},
+		{s.HandleDeleteBand, "/bands/camel/albums", "Camel", "1973"},
+	}
+	for _, a := range adds {
+		if w := postForm(a.handler, a.target, url.Values{"albumName": {a.album}, "year": {a.year}}); w.Code != http.StatusOK {
+			t.Fatalf("adding %s = %d (%s)", a.album, w.Code, w.Body)
+		}
+	}
+
+	band, err := d.Get("bands", "camel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, album := range band.Albums {
+		names = append(names, album.Name)
+	}
+	if got, want := strings.Join(names, ", "), "Camel, Mirage, Moonmadness, Rain Dances"; got != want {
+		t.Errorf("albums = %s, want %s", got, want)
+	}
+}
+
+func TestStorageErrorSuggestions(t *testing.T) {
+	s, d := newTestServer(t)
+	if err := d.Save("bands", "ac&dc", models.Band{Name: "AC&DC"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := postForm(s.HandleAddAlbum, "/add-album/acdc/albums", url.Values{"albumName": {"Powerage"}, "year": {"1978"}})
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	// The body is plain text and shown as such, so names are not escaped
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q, want text/plain", ct)
+	}
+	if got, want := w.Body.String(), "Band not found. Did you mean: AC&DC?\n"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+}
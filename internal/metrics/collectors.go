@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"runtime"
+
+	"music-database/db"
+)
+
+// RuntimeCollector reports Go runtime metrics
+func RuntimeCollector() Collector {
+	return func(w *Writer) {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+
+		w.Family("go_info", "Information about the Go environment.", "gauge")
+		w.Sample("go_info", 1, Label{"version", runtime.Version()})
+
+		w.Family("go_goroutines", "Number of goroutines that currently exist.", "gauge")
+		w.Sample("go_goroutines", float64(runtime.NumGoroutine()))
+
+		w.Family("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", "gauge")
+		w.Sample("go_memstats_alloc_bytes", float64(ms.Alloc))
+
+		w.Family("go_memstats_heap_objects", "Number of allocated objects.", "gauge")
+		w.Sample("go_memstats_heap_objects", float64(ms.HeapObjects))
+
+		w.Family("go_memstats_sys_bytes", "Number of bytes obtained from the system.", "gauge")
+		w.Sample("go_memstats_sys_bytes", float64(ms.Sys))
+
+		w.Family("go_gc_cycles_total", "Number of completed GC cycles.", "counter")
+		w.Sample("go_gc_cycles_total", float64(ms.NumGC))
+
+		w.Family("go_gc_pause_seconds_total", "Total time spent in GC stop-the-world pauses.", "counter")
+		w.Sample("go_gc_pause_seconds_total", float64(ms.PauseTotalNs)/1e9)
+	}
+}
+
+// StatsCollector reports database operation counts and latencies from
+// the statistics of a driver
+func StatsCollector(stats *db.CollectionStats) Collector {
+	return func(w *Writer) {
+		samples := stats.Samples()
+
+		w.Family("db_operations_total", "Number of database operations by collection and operation.", "counter")
+		for _, s := range samples {
+			w.Sample("db_operations_total", float64(s.Count),
+				Label{"collection", s.Collection}, Label{"op", s.Operation})
+		}
+
+		w.Family("db_operation_duration_seconds", "Latency of database operations by collection and operation.", "summary")
+		for _, s := range samples {
+			labels := []Label{{"collection", s.Collection}, {"op", s.Operation}}
+			w.Sample("db_operation_duration_seconds_sum", s.Total.Seconds(), labels...)
+			w.Sample("db_operation_duration_seconds_count", float64(s.Count), labels...)
+		}
+
+		w.Family("db_operation_duration_seconds_max", "Slowest database operation by collection and operation.", "gauge")
+		for _, s := range samples {
+			w.Sample("db_operation_duration_seconds_max", s.Max.Seconds(),
+				Label{"collection", s.Collection}, Label{"op", s.Operation})
+		}
+
+		hits, misses := stats.CacheCounts()
+		w.Family("db_cache_hits_total", "Number of documents served from the cache by collection.", "counter")
+		for _, collection := range sortedKeys(hits) {
+			w.Sample("db_cache_hits_total", float64(hits[collection]), Label{"collection", collection})
+		}
+		w.Family("db_cache_misses_total", "Number of documents read from disk by collection.", "counter")
+		for _, collection := range sortedKeys(misses) {
+			w.Sample("db_cache_misses_total", float64(misses[collection]), Label{"collection", collection})
+		}
+	}
+}
+
+// DocumentsCollector reports the number of documents in every collection.
+// The family is left out of a scrape in which counting fails; the error
+// gauge tells it apart from an empty database.
+func DocumentsCollector(counts func() (map[string]int, error)) Collector {
+	return func(w *Writer) {
+		c, err := counts()
+		w.Family("db_documents_scrape_error", "Whether counting the documents failed.", "gauge")
+		if err != nil {
+			w.Sample("db_documents_scrape_error", 1)
+			return
+		}
+		w.Sample("db_documents_scrape_error", 0)
+
+		w.Family("db_documents", "Number of documents stored in a collection.", "gauge")
+		for _, collection := range sortedKeys(c) {
+			w.Sample("db_documents", float64(c[collection]), Label{"collection", collection})
+		}
+	}
+}
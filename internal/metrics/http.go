@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Upper bounds in seconds of the request latency histogram
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+type handlerKey struct {
+	handler string
+	method  string
+	code    int
+}
+
+type handlerStats struct {
+	count   int
+	sum     float64
+	buckets []int
+}
+
+// Instrument wraps a handler so that its requests are counted and timed
+// under the given handler name
+func (r *Registry) Instrument(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h(sw, req)
+		r.observe(handlerKey{handler: name, method: req.Method, code: sw.status}, time.Since(start))
+	}
+}
+
+func (r *Registry) observe(key handlerKey, elapsed time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	st, ok := r.handlers[key]
+	if !ok {
+		st = &handlerStats{buckets: make([]int, len(latencyBuckets))}
+		r.handlers[key] = st
+	}
+
+	seconds := elapsed.Seconds()
+	st.count++
+	st.sum += seconds
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			st.buckets[i]++
+		}
+	}
+}
+
+func (r *Registry) writeHTTP(w *Writer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	keys := make([]handlerKey, 0, len(r.handlers))
+	for k := range r.handlers {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].handler != keys[j].handler {
+			return keys[i].handler < keys[j].handler
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].code < keys[j].code
+	})
+
+	w.Family("http_requests_total", "Total number of HTTP requests by handler, method and status code.", "counter")
+	for _, k := range keys {
+		w.Sample("http_requests_total", float64(r.handlers[k].count),
+			Label{"handler", k.handler}, Label{"method", k.method}, Label{"code", strconv.Itoa(k.code)})
+	}
+
+	w.Family("http_request_duration_seconds", "HTTP request latency by handler, method and status code.", "histogram")
+	for _, k := range keys {
+		st := r.handlers[k]
+		labels := []Label{{"handler", k.handler}, {"method", k.method}, {"code", strconv.Itoa(k.code)}}
+		for i, bound := range latencyBuckets {
+			w.Sample("http_request_duration_seconds_bucket", float64(st.buckets[i]),
+				append(labels, Label{"le", formatValue(bound)})...)
+		}
+		w.Sample("http_request_duration_seconds_bucket", float64(st.count), append(labels, Label{"le", "+Inf"})...)
+		w.Sample("http_request_duration_seconds_sum", st.sum, labels...)
+		w.Sample("http_request_duration_seconds_count", float64(st.count), labels...)
+	}
+}
+
+// statusWriter records the status code written by a handler
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
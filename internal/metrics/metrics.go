@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Collector writes a group of metric families on every scrape
+type Collector func(w *Writer)
+
+// Registry holds the HTTP metrics of the server and the collectors
+// rendered by the /metrics endpoint
+type Registry struct {
+	mutex      sync.Mutex
+	handlers   map[handlerKey]*handlerStats
+	collectors []Collector
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers: make(map[handlerKey]*handlerStats),
+	}
+}
+
+// Register adds a collector to the registry
+func (r *Registry) Register(c Collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// Handler serves every metric in the Prometheus text exposition format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		w := &Writer{w: bufio.NewWriter(rw)}
+		r.writeHTTP(w)
+
+		r.mutex.Lock()
+		collectors := append([]Collector(nil), r.collectors...)
+		r.mutex.Unlock()
+
+		for _, c := range collectors {
+			c(w)
+		}
+		w.w.Flush()
+	})
+}
+
+// Label is a single metric label
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Writer renders metric families in the Prometheus text format
+type Writer struct {
+	w *bufio.Writer
+}
+
+// Family writes the HELP and TYPE header of a metric family
+func (w *Writer) Family(name, help, kind string) {
+	fmt.Fprintf(w.w, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(w.w, "# TYPE %s %s\n", name, kind)
+}
+
+// Sample writes a single sample line
+func (w *Writer) Sample(name string, value float64, labels ...Label) {
+	w.w.WriteString(name)
+	if len(labels) > 0 {
+		w.w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.w.WriteByte(',')
+			}
+			fmt.Fprintf(w.w, "%s=\"%s\"", l.Name, escapeLabel(l.Value))
+		}
+		w.w.WriteByte('}')
+	}
+	w.w.WriteByte(' ')
+	w.w.WriteString(formatValue(value))
+	w.w.WriteByte('\n')
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,378 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"music-database/db"
+)
+
+// family is a metric family parsed from the text exposition format
+type family struct {
+	help    string
+	kind    string
+	samples []sample
+}
+
+type sample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// parseExposition parses the output of a scrape and fails the test on
+// anything the Prometheus text format does not allow
+func parseExposition(t *testing.T, body string) map[string]*family {
+	t.Helper()
+	families := make(map[string]*family)
+	var current string
+	for n, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "# HELP "):
+			name, help, _ := strings.Cut(strings.TrimPrefix(line, "# HELP "), " ")
+			if _, ok := families[name]; ok {
+				t.Fatalf("line %d: family %s declared twice", n+1, name)
+			}
+			families[name] = &family{help: help}
+			current = name
+		case strings.HasPrefix(line, "# TYPE "):
+			name, kind, _ := strings.Cut(strings.TrimPrefix(line, "# TYPE "), " ")
+			if name != current || families[name].kind != "" {
+				t.Fatalf("line %d: TYPE of %s does not follow its HELP", n+1, name)
+			}
+			families[name].kind = kind
+		default:
+			s := parseSample(t, n+1, line)
+			if !belongsTo(s.name, current, families[current]) {
+				t.Fatalf("line %d: sample %s outside of its family (in %q)", n+1, s.name, current)
+			}
+			families[current].samples = append(families[current].samples, s)
+		}
+	}
+	return families
+}
+
+func belongsTo(name, familyName string, f *family) bool {
+	if f == nil {
+		return false
+	}
+	if name == familyName {
+		return true
+	}
+	switch f.kind {
+	case "histogram":
+		return name == familyName+"_bucket" || name == familyName+"_sum" || name == familyName+"_count"
+	case "summary":
+		return name == familyName+"_sum" || name == familyName+"_count"
+	}
+	return false
+}
+
+func parseSample(t *testing.T, n int, line string) sample {
+	t.Helper()
+	s := sample{labels: make(map[string]string)}
+	i := strings.IndexAny(line, "{ ")
+	if i <= 0 {
+		t.Fatalf("line %d: malformed sample %q", n, line)
+	}
+	s.name = line[:i]
+	rest := line[i:]
+	if rest[0] == '{' {
+		rest = rest[1:]
+		for rest[0] != '}' {
+			name, after, ok := strings.Cut(rest, `="`)
+			if !ok {
+				t.Fatalf("line %d: malformed label in %q", n, line)
+			}
+			var value strings.Builder
+			j := 0
+			for ; j < len(after) && after[j] != '"'; j++ {
+				if after[j] == '\\' {
+					j++
+					switch after[j] {
+					case 'n':
+						value.WriteByte('\n')
+					case '\\', '"':
+						value.WriteByte(after[j])
+					default:
+						t.Fatalf("line %d: invalid escape \\%c", n, after[j])
+					}
+					continue
+				}
+				value.WriteByte(after[j])
+			}
+			if j == len(after) {
+				t.Fatalf("line %d: unterminated label value in %q", n, line)
+			}
+			s.labels[name] = value.String()
+			rest = strings.TrimPrefix(after[j+1:], ",")
+		}
+		rest = rest[1:]
+	}
+	value, err := strconv.ParseFloat(strings.TrimPrefix(rest, " "), 64)
+	if err != nil || !strings.HasPrefix(rest, " ") {
+		t.Fatalf("line %d: malformed value in %q", n, line)
+	}
+	s.value = value
+	return s
+}
+
+// find returns the value of the sample with the given name and labels
+func (f *family) find(name string, labels map[string]string) (float64, bool) {
+	for _, s := range f.samples {
+		if s.name != name || len(s.labels) != len(labels) {
+			continue
+		}
+		match := true
+		for k, v := range labels {
+			if s.labels[k] != v {
+				match = false
+			}
+		}
+		if match {
+			return s.value, true
+		}
+	}
+	return 0, false
+}
+
+func scrape(t *testing.T, r *Registry) map[string]*family {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	return parseExposition(t, w.Body.String())
+}
+
+func write(collect Collector) string {
+	var buf bytes.Buffer
+	w := &Writer{w: bufio.NewWriter(&buf)}
+	collect(w)
+	w.w.Flush()
+	return buf.String()
+}
+
+func TestWriterEscaping(t *testing.T) {
+	got := write(func(w *Writer) {
+		w.Family("test_total", "Help with a \\ and a\nnewline.", "counter")
+		w.Sample("test_total", 1.5, Label{"name", `AC"DC` + "\n" + `\o/`}, Label{"op", "read"})
+		w.Sample("test_total", 2)
+	})
+
+	want := "# HELP test_total Help with a \\\\ and a\\nnewline.\n" +
+		"# TYPE test_total counter\n" +
+		"test_total{name=\"AC\\\"DC\\n\\\\o/\",op=\"read\"} 1.5\n" +
+		"test_total 2\n"
+	if got != want {
+		t.Errorf("output =\n%s\nwant\n%s", got, want)
+	}
+
+	f := parseExposition(t, got)["test_total"]
+	if v, ok := f.find("test_total", map[string]string{"name": "AC\"DC\n\\o/", "op": "read"}); !ok || v != 1.5 {
+		t.Errorf("escaped label did not round-trip: %v, %v", v, ok)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{0, "0"},
+		{0.005, "0.005"},
+		{2.5, "2.5"},
+		{1 << 40, "1.099511627776e+12"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, tt := range tests {
+		if got := formatValue(tt.value); got != tt.want {
+			t.Errorf("formatValue(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	r := NewRegistry()
+	ok := r.Instrument("bands", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	missing := r.Instrument("bands", func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	})
+	slow := r.Instrument("slow", func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+		// A second status is ignored by net/http and must be by the metrics
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	for i := 0; i < 3; i++ {
+		ok(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/bands", nil))
+	}
+	missing(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/bands/x", nil))
+	slow(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/slow", nil))
+
+	families := scrape(t, r)
+	requests := families["http_requests_total"]
+	if requests == nil || requests.kind != "counter" {
+		t.Fatalf("http_requests_total = %+v, want a counter", requests)
+	}
+	counts := []struct {
+		handler, method, code string
+		want                  float64
+	}{
+		{"bands", "GET", "200", 3},
+		{"bands", "DELETE", "404", 1},
+		{"slow", "POST", "201", 1},
+	}
+	for _, c := range counts {
+		labels := map[string]string{"handler": c.handler, "method": c.method, "code": c.code}
+		if v, ok := requests.find("http_requests_total", labels); !ok || v != c.want {
+			t.Errorf("http_requests_total%v = %v, %v; want %v", labels, v, ok, c.want)
+		}
+	}
+	if len(requests.samples) != len(counts) {
+		t.Errorf("http_requests_total has %d samples, want %d", len(requests.samples), len(counts))
+	}
+
+	duration := families["http_request_duration_seconds"]
+	if duration == nil || duration.kind != "histogram" {
+		t.Fatalf("http_request_duration_seconds = %+v, want a histogram", duration)
+	}
+	labels := map[string]string{"handler": "slow", "method": "POST", "code": "201"}
+	previous := -1.0
+	for _, bound := range append(append([]float64(nil), latencyBuckets...), math.Inf(1)) {
+		le := formatValue(bound)
+		bucket := map[string]string{"le": le}
+		for k, v := range labels {
+			bucket[k] = v
+		}
+		v, ok := duration.find("http_request_duration_seconds_bucket", bucket)
+		if !ok {
+			t.Fatalf("no bucket le=%s", le)
+		}
+		if v < previous {
+			t.Errorf("bucket le=%s = %v, below the previous bucket %v", le, v, previous)
+		}
+		// The request took at least 30ms
+		if bound < 0.025 && v != 0 {
+			t.Errorf("bucket le=%s = %v, want 0", le, v)
+		}
+		previous = v
+	}
+	if previous != 1 {
+		t.Errorf("bucket le=+Inf = %v, want 1", previous)
+	}
+	if v, ok := duration.find("http_request_duration_seconds_count", labels); !ok || v != 1 {
+		t.Errorf("count = %v, %v; want 1", v, ok)
+	}
+	if v, ok := duration.find("http_request_duration_seconds_sum", labels); !ok || v < 0.03 {
+		t.Errorf("sum = %v, %v; want at least 0.03", v, ok)
+	}
+}
+
+func TestStatsCollector(t *testing.T) {
+	stats := db.NewCollectionStats()
+	stats.Record("bands", "read", 2*time.Millisecond)
+	stats.Record("bands", "read", 4*time.Millisecond)
+	stats.Record(`we"ird`, "write", time.Millisecond)
+	stats.RecordCache("bands", true)
+	stats.RecordCache("bands", false)
+	stats.RecordCache("bands", true)
+
+	r := NewRegistry()
+	r.Register(StatsCollector(stats))
+	families := scrape(t, r)
+
+	ops := families["db_operations_total"]
+	if v, ok := ops.find("db_operations_total", map[string]string{"collection": "bands", "op": "read"}); !ok || v != 2 {
+		t.Errorf("db_operations_total{bands,read} = %v, %v; want 2", v, ok)
+	}
+	if v, ok := ops.find("db_operations_total", map[string]string{"collection": `we"ird`, "op": "write"}); !ok || v != 1 {
+		t.Errorf("db_operations_total{we\"ird,write} = %v, %v; want 1", v, ok)
+	}
+
+	summary := families["db_operation_duration_seconds"]
+	if summary == nil || summary.kind != "summary" {
+		t.Fatalf("db_operation_duration_seconds = %+v, want a summary", summary)
+	}
+	read := map[string]string{"collection": "bands", "op": "read"}
+	if v, _ := summary.find("db_operation_duration_seconds_sum", read); v != 0.006 {
+		t.Errorf("duration sum = %v, want 0.006", v)
+	}
+	if v, _ := families["db_operation_duration_seconds_max"].find("db_operation_duration_seconds_max", read); v != 0.004 {
+		t.Errorf("duration max = %v, want 0.004", v)
+	}
+
+	bands := map[string]string{"collection": "bands"}
+	if v, _ := families["db_cache_hits_total"].find("db_cache_hits_total", bands); v != 2 {
+		t.Errorf("cache hits = %v, want 2", v)
+	}
+	if v, _ := families["db_cache_misses_total"].find("db_cache_misses_total", bands); v != 1 {
+		t.Errorf("cache misses = %v, want 1", v)
+	}
+}
+
+func TestDocumentsCollector(t *testing.T) {
+	r := NewRegistry()
+	r.Register(DocumentsCollector(func() (map[string]int, error) {
+		return map[string]int{"bands": 3, "albums": 12}, nil
+	}))
+	families := scrape(t, r)
+
+	docs := families["db_documents"]
+	if docs == nil || docs.kind != "gauge" || len(docs.samples) != 2 {
+		t.Fatalf("db_documents = %+v, want two gauges", docs)
+	}
+	if v, _ := docs.find("db_documents", map[string]string{"collection": "albums"}); v != 12 {
+		t.Errorf("db_documents{albums} = %v, want 12", v)
+	}
+	if v, ok := families["db_documents_scrape_error"].find("db_documents_scrape_error", nil); !ok || v != 0 {
+		t.Errorf("db_documents_scrape_error = %v, %v; want 0", v, ok)
+	}
+}
+
+func TestDocumentsCollectorError(t *testing.T) {
+	r := NewRegistry()
+	r.Register(DocumentsCollector(func() (map[string]int, error) {
+		return nil, errors.New("disk on fire")
+	}))
+	r.Register(RuntimeCollector())
+	families := scrape(t, r)
+
+	if _, ok := families["db_documents"]; ok {
+		t.Error("db_documents is exposed although counting failed")
+	}
+	if v, ok := families["db_documents_scrape_error"].find("db_documents_scrape_error", nil); !ok || v != 1 {
+		t.Errorf("db_documents_scrape_error = %v, %v; want 1", v, ok)
+	}
+	// Later collectors still run
+	if _, ok := families["go_goroutines"]; !ok {
+		t.Error("go_goroutines missing after a failed collector")
+	}
+}
+
+func TestRuntimeCollector(t *testing.T) {
+	families := parseExposition(t, write(RuntimeCollector()))
+	for _, name := range []string{"go_info", "go_goroutines", "go_memstats_alloc_bytes", "go_gc_cycles_total"} {
+		f, ok := families[name]
+		if !ok || len(f.samples) != 1 {
+			t.Errorf("%s = %+v, want one sample", name, f)
+		}
+	}
+	if v, _ := families["go_goroutines"].find("go_goroutines", nil); v < 1 {
+		t.Errorf("go_goroutines = %v", v)
+	}
+}
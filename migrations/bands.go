@@ -0,0 +1,26 @@
+// Package migrations registers the schema migrations of the music
+// database. Import it for its side effects before opening the database.
+package migrations
+
+import "music-database/db"
+
+func init() {
+	db.RegisterMigration("bands", 1, addBandDefaults)
+}
+
+// addBandDefaults gives every band the fields of models.Band. Documents
+// written before country and year were introduced lack them.
+func addBandDefaults(doc map[string]interface{}) (map[string]interface{}, error) {
+	defaults := map[string]interface{}{
+		"genre":   "",
+		"country": "",
+		"year":    0,
+		"albums":  []interface{}{},
+	}
+	for field, value := range defaults {
+		if current, ok := doc[field]; !ok || current == nil {
+			doc[field] = value
+		}
+	}
+	return doc, nil
+}
@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddBandDefaults(t *testing.T) {
+	doc := map[string]interface{}{"name": "Opeth", "genre": "Progressive Metal", "country": nil}
+
+	got, err := addBandDefaults(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"name":    "Opeth",
+		"genre":   "Progressive Metal",
+		"country": "",
+		"year":    0,
+		"albums":  []interface{}{},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("addBandDefaults() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,123 @@
+// Package fuzzy scores how similar two short strings are, for typo
+// tolerant lookups such as "did you mean" suggestions.
+package fuzzy
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Match is a candidate ranked by its similarity to a query
+type Match struct {
+	Value string
+	Score float64
+}
+
+// Similarity returns a score between 0 and 1 for how alike a and b are,
+// ignoring case and punctuation. It averages trigram similarity and edit
+// distance, and also credits a query that matches the start of a longer
+// candidate, so "van der graff" is close to "Van der Graaf Generator".
+func Similarity(query, candidate string) float64 {
+	q, c := []rune(normalize(query)), []rune(normalize(candidate))
+	if len(q) == 0 || len(c) == 0 {
+		return 0
+	}
+
+	score := similarity(q, c)
+	if len(c) > len(q) {
+		if prefix := 0.9 * similarity(q, c[:len(q)]); prefix > score {
+			score = prefix
+		}
+	}
+	return score
+}
+
+// Rank scores every candidate against query and returns those scoring at
+// least minScore, best first. A limit of zero returns every match.
+func Rank(query string, candidates []string, minScore float64, limit int) []Match {
+	var matches []Match
+	for _, c := range candidates {
+		if score := Similarity(query, c); score >= minScore {
+			matches = append(matches, Match{Value: c, Score: score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// Levenshtein returns the number of single-rune insertions, deletions and
+// substitutions needed to turn a into b
+func Levenshtein(a, b string) int {
+	return levenshtein([]rune(a), []rune(b))
+}
+
+func similarity(a, b []rune) float64 {
+	longest := len(a)
+	if len(b) > longest {
+		longest = len(b)
+	}
+	edit := 1 - float64(levenshtein(a, b))/float64(longest)
+	return (edit + trigramSimilarity(a, b)) / 2
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// trigramSimilarity returns the Sørensen–Dice coefficient of the trigram
+// sets of a and b, padded so short words still produce trigrams
+func trigramSimilarity(a, b []rune) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	return 2 * float64(shared) / float64(len(ta)+len(tb))
+}
+
+func trigrams(s []rune) map[string]bool {
+	padded := append([]rune("  "), append(s, ' ')...)
+	set := make(map[string]bool)
+	for i := 0; i+3 <= len(padded); i++ {
+		set[string(padded[i:i+3])] = true
+	}
+	return set
+}
+
+// normalize lowercases s and reduces every run of characters other than
+// letters and digits to a single space
+func normalize(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
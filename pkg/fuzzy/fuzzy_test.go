@@ -0,0 +1,59 @@
+package fuzzy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"opeth", "", 5},
+		{"opeth", "opeth", 0},
+		{"opeth", "opet", 1},
+		{"megadeth", "metallica", 6},
+		{"motörhead", "motorhead", 1},
+	}
+	for _, tt := range tests {
+		if got := Levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("Levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	if got := Similarity("Opeth", "opeth!"); got != 1 {
+		t.Errorf("Similarity() ignoring case and punctuation = %v, want 1", got)
+	}
+	if got := Similarity("", "Opeth"); got != 0 {
+		t.Errorf("Similarity() of an empty query = %v, want 0", got)
+	}
+	if close, far := Similarity("metalica", "Metallica"), Similarity("metalica", "Opeth"); close <= far {
+		t.Errorf("Similarity() of a typo = %v, not above an unrelated name %v", close, far)
+	}
+	if got := Similarity("van der graff", "Van der Graaf Generator"); got < 0.6 {
+		t.Errorf("Similarity() of a prefix = %v, want at least 0.6", got)
+	}
+}
+
+func TestRank(t *testing.T) {
+	candidates := []string{"Opeth", "Metallica", "Megadeth", "Camel"}
+
+	var got []string
+	for _, m := range Rank("megadet", candidates, 0.4, 0) {
+		got = append(got, m.Value)
+	}
+	if len(got) == 0 || got[0] != "Megadeth" {
+		t.Errorf("Rank() = %v, want Megadeth first", got)
+	}
+
+	if got := Rank("megadet", candidates, 0, 2); len(got) != 2 {
+		t.Errorf("Rank() with limit 2 returned %d matches", len(got))
+	}
+	if got := Rank("zzz", candidates, 0.5, 0); !reflect.DeepEqual(got, []Match(nil)) {
+		t.Errorf("Rank() with no match = %v, want none", got)
+	}
+}
@@ -0,0 +1,48 @@
+package lru
+
+import "testing"
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := New[string, int](2)
+	c.Add("opeth", 1)
+	c.Add("camel", 2)
+
+	// Reading opeth makes camel the least recently used entry
+	if v, ok := c.Get("opeth"); !ok || v != 1 {
+		t.Fatalf("Get(opeth) = %v, %v", v, ok)
+	}
+	c.Add("katatonia", 3)
+
+	if _, ok := c.Get("camel"); ok {
+		t.Error("camel was not evicted")
+	}
+	for key, want := range map[string]int{"opeth": 1, "katatonia": 3} {
+		if v, ok := c.Get(key); !ok || v != want {
+			t.Errorf("Get(%s) = %v, %v; want %v", key, v, ok, want)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+}
+
+func TestCacheRemove(t *testing.T) {
+	c := New[string, int](4)
+	c.Add("bands/opeth", 1)
+	c.Add("bands/camel", 2)
+	c.Add("labels/candlelight", 3)
+	c.Add("bands/opeth", 4)
+
+	if v, _ := c.Get("bands/opeth"); v != 4 {
+		t.Errorf("Get() after replacing = %v, want 4", v)
+	}
+
+	c.Remove("bands/camel")
+	c.RemoveFunc(func(key string) bool { return key == "labels/candlelight" })
+	if c.Len() != 1 {
+		t.Errorf("Len() after removing = %d, want 1", c.Len())
+	}
+	if _, ok := c.Get("labels/candlelight"); ok {
+		t.Error("RemoveFunc() kept a matching entry")
+	}
+}
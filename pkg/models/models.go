@@ -1,6 +1,7 @@
 package models
 
 type Album struct {
+	BandID    string `json:"bandId,omitempty"` // Resource of the band in "bands" when stored in the "albums" collection
 	Name      string `json:"name"`
 	Year      int    `json:"year"`
 	Genre     string `json:"genre"`
@@ -8,6 +9,7 @@ type Album struct {
 }
 
 type Band struct {
+	ID      string  `json:"id,omitempty"`
 	Name    string  `json:"name"`
 	Genre   string  `json:"genre"`
 	Country string  `json:"country"`